@@ -0,0 +1,119 @@
+package gowal
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// AdoptSegments stamps segments of the WAL in dir written by another WAL (see ErrForeignSegment) with the ID
+// of this WAL, so NewWAL loads them. Segments renamed or copied under another name are adopted under their
+// current names as well. Use it only if segments of another WAL were put into the directory on purpose,
+// msgs with the same index are then handled according to Config.DuplicatePolicy.
+//
+// Segments that don't match their checksums are not adopted, recover them first. Adopted compressed segments
+// are stored uncompressed, their index sidecars are removed. The WAL must not be open.
+// It returns paths of adopted segments.
+func AdoptSegments(dir, prefix string) ([]string, error) {
+	segmentsNumbers, err := listSegments(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	mf, err := loadOrCreateManifest(dir, prefix, segmentsNumbers, codecName(defaultCodec), defaultFileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load manifest")
+	}
+
+	id, err := parseID(mf.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse WAL ID")
+	}
+
+	var adopted []string
+	for _, n := range segmentsNumbers {
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		ok, err := adoptSegment(segmentPath, id)
+		if err != nil {
+			return adopted, errors.Wrapf(err, "failed to adopt segment %s", segmentPath)
+		}
+		if ok {
+			adopted = append(adopted, segmentPath)
+		}
+	}
+
+	return adopted, nil
+}
+
+// adoptSegment rewrites header of the segment with the given WAL ID and the name of the segment file,
+// along with its footer, if the header doesn't match them. It reports whether the segment was rewritten.
+func adoptSegment(segmentPath string, id [16]byte) (bool, error) {
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to stat segment")
+	}
+	if stat.Size() == 0 {
+		return false, nil
+	}
+
+	header, err := readSegmentHeaderFromFile(segmentPath)
+	if err != nil {
+		return false, err
+	}
+	if header.id == id && checkSegmentHeader(header, segmentPath) == nil {
+		return false, nil
+	}
+
+	if _, err := os.Stat(segmentPath + checkSumPostfix); err == nil {
+		if ok, err := matchesChecksum(segmentPath); err != nil || !ok {
+			return false, errors.Wrap(ErrCorruptedFrame, "segment doesn't match its checksum")
+		}
+	}
+
+	// footer of the segment must be verified before its checksum is recomputed
+	footer, err := VerifySegment(segmentPath)
+	if err != nil && !errors.Is(err, ErrNoFooter) {
+		return false, err
+	}
+	sealed := err == nil
+
+	data, err := readSegmentData(segmentPath)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read segment")
+	}
+
+	body := data[header.size:]
+	if sealed {
+		_, size, err := readFooter(bytes.NewReader(data))
+		if err != nil {
+			return false, err
+		}
+		body = body[:int64(len(body))-size]
+	}
+
+	prefix, number, err := splitSegmentName(filepath.Base(segmentPath))
+	if err != nil {
+		return false, err
+	}
+
+	data = segmentHeader{id: id, created: header.created, number: number, prefix: prefix}.encode()
+	data = append(data, body...)
+	if sealed {
+		footer.CRC = crc32.ChecksumIEEE(data)
+		data = append(data, footer.encode()...)
+	}
+
+	if err := replaceSegmentData(segmentPath, data, stat.Mode().Perm()); err != nil {
+		return false, err
+	}
+
+	// the sidecar doesn't match the segment anymore
+	if err := os.Remove(segmentPath + sidecarPostfix); err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "failed to remove segment index sidecar")
+	}
+
+	return true, nil
+}
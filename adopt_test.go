@@ -0,0 +1,64 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAdoptSegments(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 2, MaxSegments: 10}
+	foreignCfg := cfg
+	foreignCfg.Dir = "./testlogdata/foreign"
+
+	// the WAL stores msgs 0-2, another WAL stores msgs 3-5
+	for _, c := range []struct {
+		cfg  Config
+		from int
+	}{{cfg, 0}, {foreignCfg, 3}} {
+		log, err := NewWAL(c.cfg)
+		require.NoError(t, err)
+		for i := c.from; i < c.from+3; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+		require.NoError(t, log.Close())
+	}
+
+	// segments of another WAL are copied into the directory as the newest ones
+	for n := 0; n < 2; n++ {
+		for _, postfix := range []string{"", checkSumPostfix} {
+			data, err := os.ReadFile("./testlogdata/foreign/" + segmentName("log_", n) + postfix)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile("./testlogdata/"+segmentName("log_", n+2)+postfix, data, 0755))
+		}
+	}
+
+	_, err := NewWAL(cfg)
+	require.ErrorIs(t, err, ErrForeignSegment)
+
+	adopted, err := AdoptSegments(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{"testlogdata/" + segmentName("log_", 2), "testlogdata/" + segmentName("log_", 3)}, adopted)
+
+	// footer of the adopted sealed segment matches its new header
+	footer, err := VerifySegment("./testlogdata/" + segmentName("log_", 2))
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), footer.FirstIndex)
+
+	adopted, err = AdoptSegments(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Empty(t, adopted)
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok, i)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.NoError(t, log.Write(6, "key6", []byte("value6")))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,132 @@
+package gowal
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// annotationsPostfix is appended to the segment prefix to get the name of the annotations file.
+const annotationsPostfix = "annotations"
+
+// Annotation is operational metadata attached to a range of msgs, e.g. "index 1234 is poisoned"
+// or "range 100-200 was reprocessed". Annotations are stored apart from the segments,
+// so msgs are never rewritten.
+type Annotation struct {
+	// From and To are the first and the last indexes of the annotated range.
+	From uint64
+	To   uint64
+
+	// Label is the annotation itself.
+	Label string
+
+	// Timestamp is the wall-clock time the annotation was written at.
+	Timestamp time.Time
+}
+
+// Annotate attaches label to msgs with indexes in [from, to]. Msgs don't have to exist,
+// so annotations can also be attached to ranges that were already removed or not written yet.
+func (c *Wal) Annotate(from, to uint64, label string) error {
+	if from > to {
+		return errors.Errorf("bad annotated range [%d, %d]", from, to)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the annotations file opened after Close would never be closed
+	if c.closed {
+		return errors.New("failed to annotate, WAL is closed")
+	}
+
+	if c.annotationsLog == nil {
+		f, err := os.OpenFile(c.annotationsPath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, c.fileMode)
+		if err != nil {
+			return errors.Wrap(err, "failed to open annotations file")
+		}
+		c.annotationsLog = f
+	}
+
+	a := Annotation{From: from, To: to, Label: label, Timestamp: time.Now().Round(0)}
+	payload, err := msgpack.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode annotation")
+	}
+
+	if _, err := c.annotationsLog.Write(frame.Encode(payload)); err != nil {
+		return errors.Wrap(err, "failed to write annotation")
+	}
+
+	if c.isInSyncDiskMode {
+		if err := c.annotationsLog.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync annotations file")
+		}
+	}
+
+	c.annotations = append(c.annotations, a)
+
+	return nil
+}
+
+// Annotations returns annotations of the msg with the given index in the order they were written.
+func (c *Wal) Annotations(index uint64) []Annotation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var annotations []Annotation
+	for _, a := range c.annotations {
+		if a.From <= index && index <= a.To {
+			annotations = append(annotations, a)
+		}
+	}
+
+	return annotations
+}
+
+// annotationsPath returns path to the annotations file.
+func (c *Wal) annotationsPath() string {
+	return path.Join(c.pathToLogsDir, c.prefix+annotationsPostfix)
+}
+
+// loadAnnotations reads all annotations from the annotations file, if it exists.
+// The annotation torn by a crash is cut off the file.
+func loadAnnotations(annotationsPath string) ([]Annotation, error) {
+	f, err := os.Open(annotationsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open annotations file")
+	}
+	defer f.Close()
+
+	var (
+		annotations []Annotation
+		offset      int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		payload, n, err := frame.Read(r)
+		if err == io.EOF {
+			return annotations, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, frame.ErrCorrupted) {
+			return annotations, errors.Wrap(os.Truncate(annotationsPath, offset), "failed to truncate torn annotation")
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read annotation")
+		}
+		offset += int64(n)
+
+		var a Annotation
+		if err := msgpack.Unmarshal(payload, &a); err != nil {
+			return nil, errors.Wrap(err, "failed to decode annotation")
+		}
+		annotations = append(annotations, a)
+	}
+}
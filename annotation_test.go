@@ -0,0 +1,71 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	require.NoError(t, log.Annotate(2, 2, "poisoned"))
+	require.NoError(t, log.Annotate(1, 3, "reprocessed"))
+	require.Error(t, log.Annotate(3, 1, "bad range"))
+
+	check := func(log *Wal) {
+		require.Empty(t, log.Annotations(0))
+
+		annotations := log.Annotations(2)
+		require.Len(t, annotations, 2)
+		require.Equal(t, "poisoned", annotations[0].Label)
+		require.Equal(t, "reprocessed", annotations[1].Label)
+		require.Equal(t, uint64(1), annotations[1].From)
+		require.Equal(t, uint64(3), annotations[1].To)
+
+		require.Len(t, log.Annotations(3), 1)
+	}
+	check(log)
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	check(log)
+	require.Equal(t, 5, log.Len())
+	require.NoError(t, log.Annotate(4, 4, "torn"))
+	require.NoError(t, log.Close())
+	require.Error(t, log.Annotate(4, 4, "closed"))
+
+	// the annotation torn by a crash is dropped
+	annotationsPath := log.annotationsPath()
+	stat, err := os.Stat(annotationsPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(annotationsPath, stat.Size()-3))
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	check(log)
+	require.Empty(t, log.Annotations(4))
+	require.NoError(t, log.Annotate(4, 4, "rewritten"))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, "rewritten", log.Annotations(4)[0].Label)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,74 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+// archiveSegment moves the sealed segment with its checksum file and index sidecar to the archive directory
+// instead of deleting it, drops its msgs from the index and removes the oldest archived segments
+// if the archive exceeds Config.ArchiveMaxSegments. The caller must hold the lock.
+func (c *Wal) archiveSegment(seg int) error {
+	c.closeSegmentFile(seg)
+
+	segmentPath := c.segmentPath(seg)
+	archivedPath := path.Join(c.archiveDir, segmentName(c.prefix, seg))
+
+	// the segment goes last, so a segment found in the archive always has its checksum
+	if err := moveFile(segmentPath+sidecarPostfix, archivedPath+sidecarPostfix, c.fileMode); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to archive segment index sidecar")
+	}
+	if err := moveFile(segmentPath+checkSumPostfix, archivedPath+checkSumPostfix, c.fileMode); err != nil {
+		return errors.Wrap(err, "failed to archive segment checksum file")
+	}
+	if err := moveFile(segmentPath, archivedPath, c.fileMode); err != nil {
+		return errors.Wrap(err, "failed to archive segment")
+	}
+
+	c.forgetSegment(seg)
+
+	return c.enforceArchiveRetention()
+}
+
+// enforceArchiveRetention removes the oldest archived segments while there are more than Config.ArchiveMaxSegments.
+func (c *Wal) enforceArchiveRetention() error {
+	if c.archiveMaxSegments <= 0 {
+		return nil
+	}
+
+	archived, err := findSegmentNumber(c.archiveDir, c.prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to find archived segments")
+	}
+
+	for len(archived) > c.archiveMaxSegments {
+		archivedPath := path.Join(c.archiveDir, segmentName(c.prefix, archived[0]))
+		for _, name := range []string{archivedPath, archivedPath + checkSumPostfix, archivedPath + sidecarPostfix} {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to remove archived segment")
+			}
+		}
+		archived = archived[1:]
+	}
+
+	return nil
+}
+
+// moveFile renames the file, copying it to a file with the given mode if the rename fails (e.g. across file systems).
+func moveFile(from, to string, mode os.FileMode) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(to, data, mode); err != nil {
+		return err
+	}
+
+	return os.Remove(from)
+}
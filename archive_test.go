@@ -0,0 +1,60 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestArchiveDir(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                "./testlogdata",
+		Prefix:             "log_",
+		SegmentThreshold:   2,
+		MaxSegments:        2,
+		OffsetOnlyIndex:    true,
+		ArchiveDir:         "./testlogdata/archive",
+		ArchiveMaxSegments: 2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.False(t, log.Exists(5))
+	require.True(t, log.Exists(6))
+
+	// segments 0, 1 and 2 were archived, only 2 newest are kept in the archive
+	for _, name := range []string{"log_000000000", "log_000000000.checksum", "log_000000000.idx", "log_000000002", "log_000000002.checksum", "log_000000002.idx"} {
+		_, err = os.Stat("./testlogdata/" + name)
+		require.True(t, os.IsNotExist(err), name)
+	}
+	_, err = os.Stat("./testlogdata/archive/log_000000000")
+	require.True(t, os.IsNotExist(err))
+	for _, name := range []string{"log_000000001", "log_000000001.checksum", "log_000000001.idx", "log_000000002", "log_000000002.checksum", "log_000000002.idx"} {
+		_, err = os.Stat("./testlogdata/archive/" + name)
+		require.NoError(t, err, name)
+	}
+
+	// archived segments are intact
+	f, err := os.Open("./testlogdata/archive/log_000000002")
+	require.NoError(t, err)
+	require.NoError(t, compareChecksums(f, mustOpen(t, "./testlogdata/archive/log_000000002.checksum")))
+	msgs, err := loadIndexes(f, defaultCodec)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Len(t, msgs, 2)
+	require.Equal(t, "value5", string(msgs[5].Value))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	return f
+}
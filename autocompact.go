@@ -0,0 +1,104 @@
+package gowal
+
+import (
+	"time"
+)
+
+// compactInBackground compacts sealed segments every CompactionInterval until the WAL is closed,
+// see Config.CompactionInterval. Runs are skipped while compaction is paused or the WAL is not idle.
+func (c *Wal) compactInBackground() {
+	ticker := time.NewTicker(c.compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+
+		idle := c.compactionIdle <= 0 || time.Since(c.lastWrite) >= c.compactionIdle
+		if !c.compactionPaused && idle && c.loadErr == nil {
+			c.runCompaction()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// runCompaction drops msgs that are not in the index anymore from sealed segments and merges small segments
+// if Config.CompactionMergeBytes is set, recording the outcome for Stats. The caller must hold the lock.
+func (c *Wal) runCompaction() {
+	before, err := c.sealedDiskSize()
+	if err == nil {
+		err = c.compactSegments()
+	}
+	if err == nil && c.compactionMergeBytes > 0 {
+		err = c.mergeSegments(c.compactionMergeBytes)
+	}
+
+	var after int64
+	if err == nil {
+		after, err = c.sealedDiskSize()
+	}
+
+	c.compaction.Runs++
+	c.compaction.LastRun = time.Now()
+	if err != nil {
+		c.compaction.Failures++
+		return
+	}
+	c.compaction.ReclaimedBytes += max(before-after, 0)
+}
+
+// sealedDiskSize returns the size of files of all sealed segments. The caller must hold the lock.
+func (c *Wal) sealedDiskSize() (int64, error) {
+	var total int64
+	for _, seg := range c.sealedSegments() {
+		size, err := c.segmentDiskSize(seg)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+// PauseCompaction pauses background compaction (see Config.CompactionInterval), e.g. while taking a backup.
+// A run in progress is completed before it returns.
+func (c *Wal) PauseCompaction() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.compactionPaused = true
+}
+
+// ResumeCompaction resumes background compaction paused by PauseCompaction.
+func (c *Wal) ResumeCompaction() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.compactionPaused = false
+}
+
+// CompactionStats describes background compaction, see Config.CompactionInterval.
+type CompactionStats struct {
+	// Runs is the number of background compaction runs, Failures is the number of failed ones.
+	Runs     uint64
+	Failures uint64
+
+	// ReclaimedBytes is the disk space freed by background compaction.
+	ReclaimedBytes int64
+
+	// LastRun is the time the last run finished at, zero if there were no runs.
+	LastRun time.Time
+
+	// Paused is set while background compaction is paused by PauseCompaction.
+	Paused bool
+}
@@ -0,0 +1,147 @@
+package gowal
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// badRecordsPostfix is appended to the name of the segment skipped by SkipCorrupted to get the name of its report.
+const badRecordsPostfix = ".badrecords"
+
+// BadRecords is the report written to the file named by the segment file followed by ".badrecords"
+// for every segment skipped by the SkipCorrupted recovery policy, so records of the segment can be recovered
+// by hand later. The segment file itself is left untouched.
+type BadRecords struct {
+	// Segment is the path to the segment file.
+	Segment string `json:"segment"`
+
+	// Skipped are byte ranges of the segment that can't be read.
+	Skipped []SkippedRange `json:"skipped"`
+
+	// Records are positions of records of the segment that are still readable, they are skipped
+	// along with the segment.
+	Records []SkippedRecord `json:"records"`
+}
+
+// SkippedRange is the byte range of the segment that can't be read.
+type SkippedRange struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Err    string `json:"error"`
+
+	// Indexes is the range of indexes the range may hold, between indexes of readable records around it,
+	// nil if there are no readable records before or after it.
+	Indexes *IndexRange `json:"indexes,omitempty"`
+}
+
+// SkippedRecord is the position of the readable record of the skipped segment.
+type SkippedRecord struct {
+	Index  uint64 `json:"index"`
+	Offset int64  `json:"offset"`
+	Size   int    `json:"size"`
+}
+
+// ReadBadRecords reads the report written for the segment skipped by SkipCorrupted.
+func ReadBadRecords(segmentPath string) (BadRecords, error) {
+	data, err := os.ReadFile(segmentPath + badRecordsPostfix)
+	if err != nil {
+		return BadRecords{}, err
+	}
+
+	var report BadRecords
+	if err := json.Unmarshal(data, &report); err != nil {
+		return BadRecords{}, errors.Wrapf(err, "failed to decode bad records of segment %s", segmentPath)
+	}
+
+	return report, nil
+}
+
+// writeBadRecords scans the corrupted segment and writes the report of its bad records next to it.
+func writeBadRecords(segmentPath string, mode os.FileMode, codec Codec) error {
+	report, err := scanBadRecords(segmentPath, codec)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode bad records")
+	}
+
+	return errors.Wrap(writeFileAtomic(segmentPath+badRecordsPostfix, data, mode), "failed to write bad records")
+}
+
+// scanBadRecords reads the corrupted segment like salvageSegment, collecting positions of readable records
+// and byte ranges skipped between them.
+func scanBadRecords(segmentPath string, codec Codec) (BadRecords, error) {
+	report := BadRecords{Segment: segmentPath, Skipped: []SkippedRange{}, Records: []SkippedRecord{}}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return report, err
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(content, 0, content.Size()))
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		report.Skipped = append(report.Skipped, SkippedRange{Size: content.Size(), Err: err.Error()})
+		return report, nil
+	}
+
+	for offset := int64(header.size); ; {
+		m, size, err := readFrame(r, codec)
+		if err == errFooter {
+			offset += int64(size)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			next, ok, rerr := resync(content, offset, content.Size())
+			if rerr != nil {
+				return report, rerr
+			}
+			if !ok {
+				next = content.Size()
+			}
+
+			report.Skipped = append(report.Skipped, SkippedRange{Offset: offset, Size: next - offset, Err: err.Error()})
+
+			if !ok {
+				break
+			}
+			offset = next
+			r = bufio.NewReader(io.NewSectionReader(content, offset, content.Size()-offset))
+			continue
+		}
+
+		report.Records = append(report.Records, SkippedRecord{Index: m.Idx, Offset: offset, Size: size})
+		offset += int64(size)
+	}
+
+	// records are ordered by offset, so indexes a range may hold lie between the records around it
+	for i, skipped := range report.Skipped {
+		after := sort.Search(len(report.Records), func(j int) bool { return report.Records[j].Offset > skipped.Offset })
+		if after == 0 || after == len(report.Records) {
+			continue
+		}
+
+		prev, next := report.Records[after-1].Index, report.Records[after].Index
+		if next > prev+1 {
+			report.Skipped[i].Indexes = &IndexRange{From: prev + 1, To: next - 1}
+		}
+	}
+
+	return report, nil
+}
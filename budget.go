@@ -0,0 +1,77 @@
+package gowal
+
+// indexEntryOverhead is the approximate memory taken by an index entry apart from key and value of the msg:
+// the msg itself, its slot in the index map and in the ordered list of indexes.
+const indexEntryOverhead = 128
+
+// msgMemory returns the approximate memory taken by key and value of the msg kept in memory.
+func msgMemory(m Msg) int64 {
+	if m.onDisk {
+		return 0
+	}
+
+	return int64(len(m.Key) + len(m.Value))
+}
+
+// indexMemory returns the approximate memory taken by the index. The caller must hold the lock.
+func (c *Wal) indexMemory() int64 {
+	return c.residentBytes + int64(c.index.Len())*indexEntryOverhead
+}
+
+// addResident accounts memory taken by key and value of the msg kept in memory (released if sign is -1)
+// in the total and in the metadata of the msg segment. The caller must hold the lock.
+func (c *Wal) addResident(m Msg, sign int64) {
+	n := sign * msgMemory(m)
+	if n == 0 {
+		return
+	}
+
+	c.residentBytes += n
+	meta := c.metas[m.seg]
+	meta.resident += n
+	c.metas[m.seg] = meta
+}
+
+// enforceIndexBudget switches sealed segments to offset-only index, from the oldest to the newest,
+// until the index fits into the memory budget. The active segment is never switched, so the budget
+// may stay exceeded until it is sealed. The caller must hold the lock.
+func (c *Wal) enforceIndexBudget() {
+	if c.maxIndexMemoryBytes <= 0 || c.indexMemory() <= c.maxIndexMemoryBytes {
+		return
+	}
+
+	for _, seg := range c.segments {
+		if c.indexMemory() <= c.maxIndexMemoryBytes || seg == c.activeSegment {
+			return
+		}
+
+		if c.metas[seg].resident > 0 {
+			c.stripSegment(seg)
+		}
+	}
+}
+
+// stripSegment keeps only positions of msgs of the segment in memory, including msgs written
+// to the segment later. The caller must hold the lock.
+func (c *Wal) stripSegment(seg int) {
+	c.offsetOnlySegments[seg] = struct{}{}
+	delete(c.arenas, seg)
+
+	meta, ok := c.metas[seg]
+	if !ok || meta.resident == 0 {
+		return
+	}
+
+	var stripped []Msg
+	c.index.Range(meta.first, func(m Msg) bool {
+		if m.seg == seg && !m.onDisk {
+			stripped = append(stripped, m)
+		}
+		return m.Idx < meta.last
+	})
+
+	for _, m := range stripped {
+		c.addResident(m, -1)
+		c.index.Put(m.position())
+	}
+}
@@ -0,0 +1,85 @@
+package gowal
+
+import (
+	"container/list"
+	"sync"
+)
+
+// readCache is LRU cache of msgs read from disk, it is safe for concurrent use.
+type readCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[uint64]*list.Element
+	lru     *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+func newReadCache(size int) *readCache {
+	return &readCache{size: size, entries: make(map[uint64]*list.Element), lru: list.New()}
+}
+
+// get returns cached copy of msg read from the given position.
+// Copies corrupted in memory are dropped, so the msg is read from disk again.
+func (rc *readCache) get(pos Msg) (Msg, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	e, ok := rc.entries[pos.Idx]
+	if !ok {
+		rc.misses++
+		return Msg{}, false
+	}
+
+	m := e.Value.(Msg)
+	if m.seg != pos.seg || m.off != pos.off || (!m.unchecked && m.checksum() != m.sum) {
+		rc.lru.Remove(e)
+		delete(rc.entries, pos.Idx)
+		rc.misses++
+		return Msg{}, false
+	}
+
+	rc.lru.MoveToFront(e)
+	rc.hits++
+
+	return m, true
+}
+
+func (rc *readCache) put(m Msg) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if e, ok := rc.entries[m.Idx]; ok {
+		e.Value = m
+		rc.lru.MoveToFront(e)
+		return
+	}
+
+	rc.entries[m.Idx] = rc.lru.PushFront(m)
+	if rc.lru.Len() > rc.size {
+		oldest := rc.lru.Back()
+		rc.lru.Remove(oldest)
+		delete(rc.entries, oldest.Value.(Msg).Idx)
+	}
+}
+
+// evictSegment drops cached msgs of the removed segment.
+func (rc *readCache) evictSegment(seg int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for idx, e := range rc.entries {
+		if e.Value.(Msg).seg == seg {
+			rc.lru.Remove(e)
+			delete(rc.entries, idx)
+		}
+	}
+}
+
+func (rc *readCache) stats() (hits, misses uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.hits, rc.misses
+}
@@ -0,0 +1,185 @@
+package gowal
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"math"
+	"os"
+	"path"
+	"time"
+)
+
+// checkpointsPostfix is appended to the segment prefix to get the name of the checkpoints file.
+const checkpointsPostfix = "checkpoints"
+
+// Checkpoint marks the point the application snapshotted its state at, so replay after a restart starts
+// right after it instead of from the oldest msg, see WriteCheckpoint and ReplaySinceCheckpoint.
+type Checkpoint struct {
+	// Next is the index of the first msg not covered by the checkpoint, i.e. one past the index
+	// of the newest msg written before the checkpoint (0 if the log was empty).
+	Next uint64
+
+	// Meta is defined by the application, e.g. the location of its snapshot.
+	Meta []byte
+
+	// Timestamp is the wall-clock time the checkpoint was written at.
+	Timestamp time.Time
+}
+
+// WriteCheckpoint writes checkpoint covering all msgs written so far. Msgs are synced to disk first,
+// so the checkpoint never survives a crash without the msgs it covers.
+//
+// Checkpoints are stored apart from the segments, so they are kept when segments are compacted or evicted.
+// Checkpoints covering msgs removed by TruncateAfter are removed as well.
+func (c *Wal) WriteCheckpoint(meta []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	cp := Checkpoint{Meta: meta, Timestamp: time.Now().Round(0)}
+	if last, ok := c.seekReverse(math.MaxUint64); ok {
+		cp.Next = last.Idx + 1
+	}
+
+	if err := c.sync(); err != nil {
+		return err
+	}
+
+	if c.checkpointsLog == nil {
+		f, err := os.OpenFile(c.checkpointsPath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, c.fileMode)
+		if err != nil {
+			return errors.Wrap(err, "failed to open checkpoints file")
+		}
+		c.checkpointsLog = f
+	}
+
+	data, err := encodeCheckpoint(cp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.checkpointsLog.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+
+	if c.isInSyncDiskMode {
+		if err := c.checkpointsLog.Sync(); err != nil {
+			return errors.Wrap(err, "failed to sync checkpoints file")
+		}
+	}
+
+	c.checkpoints = append(c.checkpoints, cp)
+
+	return nil
+}
+
+// LastCheckpoint returns the newest checkpoint, false if there is no checkpoint.
+func (c *Wal) LastCheckpoint() (Checkpoint, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.checkpoints) == 0 {
+		return Checkpoint{}, false
+	}
+
+	return c.checkpoints[len(c.checkpoints)-1], true
+}
+
+// ReplaySinceCheckpoint works like Replay starting from the first msg not covered by the newest checkpoint,
+// or from the oldest msg if there is no checkpoint.
+func (c *Wal) ReplaySinceCheckpoint(apply func(Msg) error) (uint64, error) {
+	cp, _ := c.LastCheckpoint()
+
+	return c.Replay(cp.Next, apply)
+}
+
+// dropCheckpointsAfter removes checkpoints covering msgs with indexes greater than index and rewrites
+// the checkpoints file. The caller must hold the lock.
+func (c *Wal) dropCheckpointsAfter(index uint64) error {
+	kept := c.checkpoints[:0]
+	for _, cp := range c.checkpoints {
+		if cp.Next <= index+1 {
+			kept = append(kept, cp)
+		}
+	}
+	if len(kept) == len(c.checkpoints) {
+		return nil
+	}
+	c.checkpoints = kept
+
+	var data []byte
+	for _, cp := range kept {
+		encoded, err := encodeCheckpoint(cp)
+		if err != nil {
+			return err
+		}
+		data = append(data, encoded...)
+	}
+
+	if c.checkpointsLog != nil {
+		if err := c.checkpointsLog.Close(); err != nil {
+			return errors.Wrap(err, "failed to close checkpoints file")
+		}
+		c.checkpointsLog = nil
+	}
+
+	return errors.Wrap(writeFileAtomic(c.checkpointsPath(), data, c.fileMode), "failed to rewrite checkpoints file")
+}
+
+// checkpointsPath returns path to the checkpoints file.
+func (c *Wal) checkpointsPath() string {
+	return path.Join(c.pathToLogsDir, c.prefix+checkpointsPostfix)
+}
+
+func encodeCheckpoint(cp Checkpoint) ([]byte, error) {
+	payload, err := msgpack.Marshal(cp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode checkpoint")
+	}
+
+	return frame.Encode(payload), nil
+}
+
+// loadCheckpoints reads all checkpoints from the checkpoints file, if it exists. A checkpoint torn
+// by a crash at the end of the file is truncated, so new checkpoints are appended after the valid ones.
+func loadCheckpoints(checkpointsPath string) ([]Checkpoint, error) {
+	f, err := os.Open(checkpointsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to open checkpoints file")
+	}
+	defer f.Close()
+
+	var (
+		checkpoints []Checkpoint
+		offset      int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		payload, n, err := frame.Read(r)
+		if err == io.EOF {
+			return checkpoints, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, frame.ErrCorrupted) {
+			return checkpoints, errors.Wrap(os.Truncate(checkpointsPath, offset), "failed to truncate torn checkpoint")
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read checkpoint")
+		}
+		offset += int64(n)
+
+		var cp Checkpoint
+		if err := msgpack.Unmarshal(payload, &cp); err != nil {
+			return nil, errors.Wrap(err, "failed to decode checkpoint")
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+}
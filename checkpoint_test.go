@@ -0,0 +1,79 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	_, ok := log.LastCheckpoint()
+	require.False(t, ok)
+
+	// replay starts from the oldest msg without checkpoints
+	require.NoError(t, log.WriteCheckpoint([]byte("empty")))
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.WriteCheckpoint([]byte("snapshot-4")))
+	for i := 5; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.WriteCheckpoint([]byte("snapshot-9")))
+
+	replayed := func(log *Wal) []uint64 {
+		var indexes []uint64
+		_, err := log.ReplaySinceCheckpoint(func(m Msg) error {
+			indexes = append(indexes, m.Idx)
+			return nil
+		})
+		require.NoError(t, err)
+		return indexes
+	}
+	require.Empty(t, replayed(log))
+
+	require.NoError(t, log.Write(10, "key10", []byte("value10")))
+	require.Equal(t, []uint64{10}, replayed(log))
+	require.NoError(t, log.Close())
+
+	// checkpoints survive restarts, a torn checkpoint is dropped
+	f, err := os.OpenFile("./testlogdata/log_"+checkpointsPostfix, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	cp, ok := log.LastCheckpoint()
+	require.True(t, ok)
+	require.Equal(t, uint64(10), cp.Next)
+	require.Equal(t, "snapshot-9", string(cp.Meta))
+	require.Equal(t, []uint64{10}, replayed(log))
+
+	// checkpoints covering truncated msgs are dropped
+	require.NoError(t, log.TruncateAfter(6))
+	cp, ok = log.LastCheckpoint()
+	require.True(t, ok)
+	require.Equal(t, "snapshot-4", string(cp.Meta))
+	require.Equal(t, []uint64{5, 6}, replayed(log))
+
+	require.NoError(t, log.WriteCheckpoint([]byte("snapshot-6")))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	cp, ok = log.LastCheckpoint()
+	require.True(t, ok)
+	require.Equal(t, "snapshot-6", string(cp.Meta))
+	require.Equal(t, uint64(7), cp.Next)
+	require.Len(t, log.checkpoints, 3)
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -59,7 +59,7 @@ func writeChecksum(fd *os.File, chk *os.File) error {
 
 	sum := h.Sum(nil)
 
-	chk, err = os.OpenFile(chk.Name(), os.O_RDWR|os.O_CREATE, 0755)
+	chk, err = os.OpenFile(chk.Name(), os.O_RDWR, 0)
 	if err != nil {
 		return errors.Wrap(err, "failed to create new log file")
 	}
@@ -0,0 +1,123 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"os"
+)
+
+// customCodec is the name of codecs not provided by this package recorded in the manifest, see codecName.
+const customCodec = "custom"
+
+var (
+	// ErrCodecMismatch is returned by NewWAL if Config.Codec differs from the codec the WAL was created with.
+	ErrCodecMismatch = errors.New("codec doesn't match the codec of the WAL")
+
+	// ErrCustomCodec is returned by functions working on WAL directories without opening the WAL
+	// (e.g. Verify) for WALs created with a codec not provided by this package, they can't decode msgs of them.
+	ErrCustomCodec = errors.New("WAL is written with a custom codec")
+)
+
+// Codec encodes msgs into payloads of frames and decodes them back, see Config.Codec.
+// Idx, Key, Value and Timestamp of the msg must survive the round trip, other fields are not encoded.
+type Codec interface {
+	Marshal(Msg) ([]byte, error)
+	Unmarshal([]byte) (Msg, error)
+}
+
+// MsgpackCodec encodes msgs with msgpack, it is the default codec.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(m Msg) ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (MsgpackCodec) Unmarshal(payload []byte) (Msg, error) {
+	var m Msg
+	err := msgpack.Unmarshal(payload, &m)
+
+	return m, err
+}
+
+// defaultCodec is used when Config.Codec is not set and for WALs without codec recorded in the manifest,
+// see walCodec.
+var defaultCodec Codec = MsgpackCodec{}
+
+// codecName returns the name of the codec recorded in the manifest of the WAL, "custom" for codecs
+// not provided by this package.
+func codecName(codec Codec) string {
+	switch codec.(type) {
+	case MsgpackCodec:
+		return "msgpack"
+	case ProtobufCodec:
+		return "protobuf"
+	case RawCodec:
+		return "raw"
+	default:
+		return customCodec
+	}
+}
+
+// codecByName returns the codec provided by this package with the given name, see codecName.
+// Empty name stands for msgpack, see manifest.Codec.
+func codecByName(name string) (Codec, bool) {
+	for _, codec := range []Codec{MsgpackCodec{}, ProtobufCodec{}, RawCodec{}} {
+		if name == "" && codec == defaultCodec || name == codecName(codec) {
+			return codec, true
+		}
+	}
+
+	return nil, false
+}
+
+// walCodec returns the codec of the WAL in dir recorded in its manifest, it is used by functions working
+// on WAL directories without opening the WAL. WALs without manifest are not initialized yet or were written
+// by versions that encoded msgs with msgpack only, so the default codec is returned for them.
+func walCodec(dir, prefix string) (Codec, error) {
+	mf, err := readManifest(dir, prefix)
+	if os.IsNotExist(err) {
+		return defaultCodec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	codec, ok := codecByName(mf.Codec)
+	if !ok {
+		return nil, errors.Wrapf(ErrCustomCodec, "codec %q of WAL in %s", mf.Codec, dir)
+	}
+
+	return codec, nil
+}
+
+// checkCodec checks that the codec matches the codec recorded in the manifest of the WAL in dir,
+// if there is one. Custom codecs can't be told apart, so they match each other.
+func checkCodec(dir, prefix string, codec Codec) error {
+	mf, err := readManifest(dir, prefix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to load manifest")
+	}
+
+	recorded := mf.Codec
+	if recorded == "" {
+		recorded = codecName(defaultCodec)
+	}
+	if name := codecName(codec); name != recorded {
+		return errors.Wrapf(ErrCodecMismatch, "WAL is written with %s codec, configured codec is %s", recorded, name)
+	}
+
+	return nil
+}
+
+// decodePayload decodes msg from the frame payload with the codec.
+func decodePayload(payload []byte, codec Codec) (Msg, error) {
+	m, err := codec.Unmarshal(payload)
+	if err != nil {
+		return Msg{}, errors.Wrap(err, "failed to decode msg")
+	}
+
+	return m, nil
+}
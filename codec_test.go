@@ -0,0 +1,202 @@
+package gowal
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// binaryCodec encodes msgs as index, timestamp, key length, key and value.
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(m Msg) ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, m.Idx)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.Timestamp.UnixNano()))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(m.Key)))
+	buf = append(buf, m.Key...)
+
+	return append(buf, m.Value...), nil
+}
+
+func (binaryCodec) Unmarshal(data []byte) (Msg, error) {
+	if len(data) < 20 || len(data) < 20+int(binary.LittleEndian.Uint32(data[16:20])) {
+		return Msg{}, errors.New("payload is too short")
+	}
+
+	n := 20 + int(binary.LittleEndian.Uint32(data[16:20]))
+
+	return Msg{Idx: binary.LittleEndian.Uint64(data[0:8]), Timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(data[8:16]))),
+		Key: string(data[20:n]), Value: append([]byte(nil), data[n:]...)}, nil
+}
+
+func TestCodec(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, Codec: binaryCodec{}}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// frames hold payloads encoded by the codec
+	bundle, _, err := log.ReadFrames(0, 1<<20)
+	require.NoError(t, err)
+	msgs, err := DecodeFramesWith(bundle, log.Stats().ID, binaryCodec{})
+	require.NoError(t, err)
+	require.Len(t, msgs, 10)
+	_, err = DecodeFrames(bundle, log.Stats().ID)
+	require.Error(t, err)
+
+	report, err := log.Verify()
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.NoError(t, log.Close())
+
+	// msgs of custom codecs can't be decoded without opening the WAL
+	_, err = Verify("./testlogdata", "log_")
+	require.ErrorIs(t, err, ErrCustomCodec)
+	_, err = SafeRecover("./testlogdata", "log_")
+	require.ErrorIs(t, err, ErrCustomCodec)
+
+	// the WAL is loaded back with the codec
+	cfg.OffsetOnlyIndex = true
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok, i)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestProtobufCodec(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, Codec: ProtobufCodec{}}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		m, err := log.GetMsg(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, "key"+strconv.Itoa(i), m.Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+		require.False(t, m.Timestamp.IsZero())
+	}
+	require.NoError(t, log.Close())
+
+	// fields unknown to the codec are skipped
+	payload, err := ProtobufCodec{}.Marshal(Msg{Idx: 7, Key: "key", Value: []byte("value")})
+	require.NoError(t, err)
+	payload = protowire.AppendTag(payload, 100, protowire.BytesType)
+	payload = protowire.AppendString(payload, "unknown")
+	m, err := ProtobufCodec{}.Unmarshal(payload)
+	require.NoError(t, err)
+	require.Equal(t, Msg{Idx: 7, Key: "key", Value: []byte("value")}, m)
+
+	_, err = ProtobufCodec{}.Unmarshal(payload[:len(payload)-1])
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRawCodec(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, Codec: RawCodec{}}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		m, err := log.GetMsg(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, "key"+strconv.Itoa(i), m.Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+		require.False(t, m.Timestamp.IsZero())
+	}
+	require.NoError(t, log.Close())
+
+	payload, err := RawCodec{}.Marshal(Msg{Idx: 7, Key: "key", Value: []byte("value")})
+	require.NoError(t, err)
+	m, err := RawCodec{}.Unmarshal(payload)
+	require.NoError(t, err)
+	require.Equal(t, Msg{Idx: 7, Key: "key", Value: []byte("value")}, m)
+
+	// the key length can't point past the payload
+	_, err = RawCodec{}.Unmarshal(payload[:rawHeaderSize+2])
+	require.Error(t, err)
+	_, err = RawCodec{}.Unmarshal(payload[:rawHeaderSize-1])
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRecoverWithCodec(t *testing.T) {
+	for _, codec := range []Codec{ProtobufCodec{}, RawCodec{}} {
+		cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, Codec: codec}
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+		m, err := log.GetMsg(5)
+		require.NoError(t, err)
+		require.NoError(t, log.Close())
+
+		// functions working on the directory decode msgs with the codec of the WAL
+		report, err := Verify("./testlogdata", "log_")
+		require.NoError(t, err)
+		require.True(t, report.OK(), codecName(codec))
+		msgs, skipped, err := Salvage("./testlogdata", "log_")
+		require.NoError(t, err)
+		require.Empty(t, skipped)
+		require.Len(t, msgs, 10)
+
+		// the WAL can't be opened with another codec
+		_, err = NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10})
+		require.ErrorIs(t, err, ErrCodecMismatch)
+
+		// only the corrupted msg and msgs after it are dropped from the segment
+		segmentPath := "./testlogdata/" + segmentName("log_", m.seg)
+		data, err := os.ReadFile(segmentPath)
+		require.NoError(t, err)
+		data[m.off+int64(m.size)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(segmentPath, data, 0755))
+
+		dryRun, err := SafeRecoverDryRun("./testlogdata", "log_")
+		require.NoError(t, err)
+		recovered, err := SafeRecover("./testlogdata", "log_")
+		require.NoError(t, err)
+		require.Equal(t, dryRun, recovered)
+		require.Len(t, recovered.Segments, 1)
+		require.Equal(t, 2, recovered.Segments[0].Records)
+		require.Equal(t, uint64(3), recovered.Segments[0].FirstIndex)
+		require.Equal(t, uint64(4), recovered.Segments[0].LastIndex)
+		require.False(t, recovered.Segments[0].Removed)
+
+		log, err = NewWAL(cfg)
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			_, _, ok := log.Get(uint64(i))
+			require.Equal(t, i != 5, ok, i)
+		}
+		require.NoError(t, log.Close())
+
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	}
+}
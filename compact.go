@@ -0,0 +1,175 @@
+package gowal
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// Compact rewrites sealed segments dropping msgs that are stored on disk but are not in the index anymore,
+// e.g. msgs superseded by msgs with the same index stored in newer segments. Segment files are replaced
+// by renaming, so readers never see partially written segments. Segments left without msgs are removed.
+func (c *Wal) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	return c.compactSegments()
+}
+
+// compactSegments rewrites sealed segments dropping msgs that are not in the index anymore. The caller must hold the lock.
+func (c *Wal) compactSegments() error {
+	for _, seg := range c.sealedSegments() {
+		if err := c.rewriteSegment(seg, func(Msg) bool { return true }); err != nil {
+			return errors.Wrapf(err, "failed to compact segment %d", seg)
+		}
+	}
+
+	return nil
+}
+
+// sealedSegments returns numbers of all segments except the active one. The caller must hold the lock.
+func (c *Wal) sealedSegments() []int {
+	var sealed []int
+	for _, seg := range c.segments {
+		if seg != c.activeSegment {
+			sealed = append(sealed, seg)
+		}
+	}
+
+	return sealed
+}
+
+// rewriteSegment rewrites the sealed segment keeping only msgs that are in the index and for which keep
+// returns true, and updates positions of kept msgs in the index. Msgs that are not kept are removed
+// from the index, the segment is removed if no msgs are kept. It does nothing if all msgs are kept.
+// The caller must hold the lock.
+func (c *Wal) rewriteSegment(seg int, keep func(Msg) bool) error {
+	data, headerSize, frames, err := c.segmentFrames(seg)
+	if err != nil {
+		return err
+	}
+
+	var (
+		kept    []Msg
+		dropped []uint64
+		meta    segmentMeta
+	)
+	out := bytes.Clone(data[:headerSize])
+	for _, f := range frames {
+		cur, ok := c.index.Get(f.Idx)
+		switch {
+		case !ok || cur.seg != seg || cur.off != f.off:
+			// not referenced by the index
+		case !keep(cur):
+			dropped = append(dropped, cur.Idx)
+		default:
+			cur.off = int64(len(out))
+			kept = append(kept, cur)
+			meta.add(cur)
+			out = append(out, data[f.off:f.off+int64(f.size)]...)
+		}
+	}
+
+	if len(kept) == len(frames) {
+		return nil
+	}
+
+	if len(kept) == 0 {
+		return c.removeSegment(seg)
+	}
+
+	out = append(out, newFooter(out, meta).encode()...)
+	if err := c.replaceSegmentFile(seg, out); err != nil {
+		return err
+	}
+
+	for _, idx := range dropped {
+		c.index.Delete(idx)
+	}
+
+	for _, m := range kept {
+		c.index.Put(m)
+	}
+	c.metas[seg] = meta
+
+	if c.cache != nil {
+		c.cache.evictSegment(seg)
+	}
+	c.reindex()
+
+	return c.writeSidecar(seg)
+}
+
+// segmentFrames reads the segment file and returns its content and the size of its header along with
+// decoded msgs of all its frames (with their offsets and sizes) in order they are stored, footers are skipped.
+func (c *Wal) segmentFrames(seg int) ([]byte, int64, []Msg, error) {
+	data, err := readSegmentData(c.segmentPath(seg))
+	if err != nil {
+		return nil, 0, nil, errors.Wrap(err, "failed to read segment")
+	}
+
+	r := bytes.NewReader(data)
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	var frames []Msg
+	for offset := int64(header.size); ; {
+		m, size, err := readFrame(r, c.codec)
+		if err == errFooter {
+			offset += int64(size)
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				return data, int64(header.size), frames, nil
+			}
+			return nil, 0, nil, errors.Wrapf(err, "failed to read frame at offset %d", offset)
+		}
+
+		m.seg, m.off, m.size = seg, offset, size
+		frames = append(frames, m)
+		offset += int64(size)
+	}
+}
+
+// replaceSegmentFile replaces content of the segment file and its checksum file with the given content,
+// which is compressed first if Config.CompressSegments is set. The caller must hold the lock.
+func (c *Wal) replaceSegmentFile(seg int, data []byte) error {
+	if c.compressSegments {
+		var err error
+		if data, err = compressSegmentData(data); err != nil {
+			return err
+		}
+	}
+
+	c.closeSegmentFile(seg)
+
+	return replaceSegmentData(c.segmentPath(seg), data, c.fileMode)
+}
+
+// writeFileSync writes data to the file (created with the given mode) and syncs it to disk.
+func writeFileSync(name string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
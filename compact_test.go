@@ -0,0 +1,131 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	before, err := log.Segments()
+	require.NoError(t, err)
+
+	// msgs dropped from the index stay on disk until compaction
+	for _, idx := range []uint64{1, 3, 4, 5} {
+		log.index.Delete(idx)
+	}
+	require.NoError(t, log.Compact())
+
+	after, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, after, 2)
+	require.Equal(t, 0, after[0].Number)
+	require.Less(t, after[0].Size, before[0].Size)
+	require.Equal(t, 2, after[0].Records)
+
+	for _, idx := range []uint64{0, 2, 6, 7} {
+		_, value, ok := log.Get(idx)
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(int(idx)), string(value))
+	}
+	require.NoError(t, log.Close())
+
+	// compacted segments are consistent with their checksums and sidecars
+	report, err := RebuildIndex(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Empty(t, report)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 4, log.Len())
+	_, value, ok := log.Get(2)
+	require.True(t, ok)
+	require.Equal(t, "value2", string(value))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestBackgroundCompaction(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                  "./testlogdata",
+		Prefix:               "log_",
+		SegmentThreshold:     3,
+		MaxSegments:          5,
+		CompactionInterval:   10 * time.Millisecond,
+		CompactionMergeBytes: 1 << 20,
+	})
+	require.NoError(t, err)
+	log.PauseCompaction()
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	log.mu.Lock()
+	for _, idx := range []uint64{1, 3, 4} {
+		log.index.Delete(idx)
+	}
+	log.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	stats := log.Stats()
+	require.True(t, stats.Compaction.Paused)
+	require.Zero(t, stats.Compaction.Runs)
+
+	log.ResumeCompaction()
+	require.Eventually(t, func() bool { return log.Stats().Compaction.Runs > 0 }, time.Second, 10*time.Millisecond)
+
+	stats = log.Stats()
+	require.False(t, stats.Compaction.Paused)
+	require.Zero(t, stats.Compaction.Failures)
+	require.Positive(t, stats.Compaction.ReclaimedBytes)
+
+	// sealed segments are compacted and merged into one
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	for _, idx := range []uint64{0, 2, 5, 6, 7} {
+		_, value, ok := log.Get(idx)
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(int(idx)), string(value))
+	}
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestBackgroundCompactionIdle(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                "./testlogdata",
+		Prefix:             "log_",
+		SegmentThreshold:   3,
+		MaxSegments:        5,
+		CompactionInterval: 10 * time.Millisecond,
+		CompactionIdle:     time.Hour,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Write(0, "key0", []byte("value0")))
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, log.Stats().Compaction.Runs)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,327 @@
+package gowal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// compressedMagic starts files of compressed segments, files of regular segments start with segmentMagic.
+	compressedMagic = "GWZS"
+
+	// compressedBlockSize is the size of blocks of the segment compressed independently,
+	// so reading a msg decompresses only blocks holding it.
+	compressedBlockSize = 64 << 10
+
+	// compressedHeaderSize is the size of the header of the compressed segment without its block table.
+	compressedHeaderSize = 16
+
+	// compressedBlockEntrySize is the size of the block table entry.
+	compressedBlockEntrySize = 12
+)
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+// compressSegmentData compresses content of the segment, see Config.CompressSegments.
+//
+// Compressed segment layout (little endian):
+//
+//	+-------+----------+--------+-------------------------------+--------+
+//	| magic | raw size | blocks | block table                   | blocks |
+//	| 4     | 8        | 4      | blocks * (offset 8 + size 4)  |        |
+//	+-------+----------+--------+-------------------------------+--------+
+//
+// Every block holds compressedBlockSize bytes of the segment (the last one may be shorter) compressed
+// with zstd, offsets of blocks are relative to the start of the file. Offsets of msgs in the index
+// and in the index sidecar are offsets in the uncompressed segment.
+func compressSegmentData(data []byte) ([]byte, error) {
+	enc, err := zstdEncoder()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd encoder")
+	}
+
+	count := (len(data) + compressedBlockSize - 1) / compressedBlockSize
+	headerSize := compressedHeaderSize + count*compressedBlockEntrySize
+
+	out := make([]byte, headerSize, headerSize+len(data)/2)
+	copy(out, compressedMagic)
+	binary.LittleEndian.PutUint64(out[4:12], uint64(len(data)))
+	binary.LittleEndian.PutUint32(out[12:16], uint32(count))
+	for i := 0; i < count; i++ {
+		block := data[i*compressedBlockSize : min((i+1)*compressedBlockSize, len(data))]
+
+		offset := len(out)
+		out = enc.EncodeAll(block, out)
+
+		entry := out[compressedHeaderSize+i*compressedBlockEntrySize:]
+		binary.LittleEndian.PutUint64(entry[0:8], uint64(offset))
+		binary.LittleEndian.PutUint32(entry[8:12], uint32(len(out)-offset))
+	}
+
+	return out, nil
+}
+
+// isCompressed reports whether the segment file content starts as content of the compressed segment.
+func isCompressed(r io.ReaderAt) bool {
+	magic := make([]byte, len(compressedMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return false
+	}
+
+	return string(magic) == compressedMagic
+}
+
+// segmentContent is the uncompressed content of the segment file.
+type segmentContent interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// rawSegment is the content of the segment file stored uncompressed.
+type rawSegment struct {
+	*os.File
+	size int64
+}
+
+func (s rawSegment) Size() int64 {
+	return s.size
+}
+
+// openSegmentContent returns the uncompressed content of the segment file, decompressing it on reads
+// if the segment is compressed.
+func openSegmentContent(f *os.File) (segmentContent, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat segment")
+	}
+
+	if !isCompressed(f) {
+		return rawSegment{File: f, size: stat.Size()}, nil
+	}
+
+	return openCompressedSegment(f, stat.Size())
+}
+
+// compressedBlock is the position of the compressed block in the file of the compressed segment.
+type compressedBlock struct {
+	off  int64
+	size int
+}
+
+// compressedSegment reads the uncompressed content of the compressed segment, decompressing only blocks
+// holding the requested bytes. The last decompressed block is kept, so sequential reads decompress
+// every block once.
+type compressedSegment struct {
+	r      io.ReaderAt
+	size   int64
+	blocks []compressedBlock
+
+	mu        sync.Mutex
+	lastBlock int
+	last      []byte
+}
+
+func openCompressedSegment(r io.ReaderAt, fileSize int64) (*compressedSegment, error) {
+	header := make([]byte, compressedHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, errors.Wrap(err, "failed to read header of compressed segment")
+	}
+
+	size := int64(binary.LittleEndian.Uint64(header[4:12]))
+	count := int64(binary.LittleEndian.Uint32(header[12:16]))
+	if count != (size+compressedBlockSize-1)/compressedBlockSize || compressedHeaderSize+count*compressedBlockEntrySize > fileSize {
+		return nil, errors.New("bad header of compressed segment")
+	}
+
+	table := make([]byte, count*compressedBlockEntrySize)
+	if _, err := r.ReadAt(table, compressedHeaderSize); err != nil {
+		return nil, errors.Wrap(err, "failed to read block table of compressed segment")
+	}
+
+	blocks := make([]compressedBlock, count)
+	for i := range blocks {
+		entry := table[i*compressedBlockEntrySize:]
+		blocks[i] = compressedBlock{off: int64(binary.LittleEndian.Uint64(entry[0:8])), size: int(binary.LittleEndian.Uint32(entry[8:12]))}
+		if blocks[i].off < 0 || blocks[i].off+int64(blocks[i].size) > fileSize {
+			return nil, errors.Errorf("block %d of compressed segment is out of file", i)
+		}
+	}
+
+	return &compressedSegment{r: r, size: size, blocks: blocks, lastBlock: -1}, nil
+}
+
+func (s *compressedSegment) Size() int64 {
+	return s.size
+}
+
+func (s *compressedSegment) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < s.size {
+		i := int(off / compressedBlockSize)
+		block, err := s.block(i)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], block[off-int64(i)*compressedBlockSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// block returns the decompressed block. The caller must hold mu.
+func (s *compressedSegment) block(i int) ([]byte, error) {
+	if i == s.lastBlock {
+		return s.last, nil
+	}
+
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zstd decoder")
+	}
+
+	compressed := make([]byte, s.blocks[i].size)
+	if _, err := s.r.ReadAt(compressed, s.blocks[i].off); err != nil {
+		return nil, errors.Wrapf(err, "failed to read block %d of compressed segment", i)
+	}
+
+	// the buffer of the last block is reused
+	s.lastBlock = -1
+	block, err := dec.DecodeAll(compressed, s.last[:0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decompress block %d of compressed segment", i)
+	}
+
+	if want := min(s.size-int64(i)*compressedBlockSize, compressedBlockSize); int64(len(block)) != want {
+		return nil, errors.Errorf("block %d of compressed segment has %d bytes, expected %d", i, len(block), want)
+	}
+	s.lastBlock, s.last = i, block
+
+	return block, nil
+}
+
+// readSegmentData reads the uncompressed content of the segment file.
+func readSegmentData(segmentPath string) ([]byte, error) {
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(data)
+	if !isCompressed(r) {
+		return data, nil
+	}
+
+	content, err := openCompressedSegment(r, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, content.Size())
+	if _, err := content.ReadAt(raw, 0); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// replaceSegmentData replaces content of the segment file and its checksum file with the given content,
+// writing them to temporary files (with the given mode) first and renaming them then.
+func replaceSegmentData(segmentPath string, data []byte, mode os.FileMode) error {
+	// a crash between the renames leaves the segment with the old checksum, which is detected on startup
+	sum := sha256.Sum256(data)
+	tmp := path.Join(path.Dir(segmentPath), "."+path.Base(segmentPath)+".compact")
+	if err := writeFileSync(tmp, data, mode); err != nil {
+		return errors.Wrap(err, "failed to write segment")
+	}
+	if err := writeFileSync(tmp+checkSumPostfix, sum[:], mode); err != nil {
+		return errors.Wrap(err, "failed to write segment checksum")
+	}
+
+	if err := os.Rename(tmp, segmentPath); err != nil {
+		return errors.Wrap(err, "failed to replace segment")
+	}
+	if err := os.Rename(tmp+checkSumPostfix, segmentPath+checkSumPostfix); err != nil {
+		return errors.Wrap(err, "failed to replace segment checksum")
+	}
+
+	return nil
+}
+
+// decompressSegmentFile replaces the compressed segment file with its uncompressed content, so it can be
+// appended to or truncated. It does nothing if the segment is not compressed.
+func decompressSegmentFile(segmentPath string, mode os.FileMode) error {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to open log segment file")
+	}
+	compressed := isCompressed(f)
+	f.Close()
+
+	if !compressed {
+		return nil
+	}
+
+	data, err := readSegmentData(segmentPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to decompress segment")
+	}
+
+	return replaceSegmentData(segmentPath, data, mode)
+}
+
+// compressSegment compresses the sealed segment and rewrites its index sidecar, see Config.CompressSegments.
+// Modification time of the segment is kept, see writeFooter. The caller must hold the lock.
+func (c *Wal) compressSegment(seg int) error {
+	segmentPath := c.segmentPath(seg)
+	stat, err := os.Stat(segmentPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat segment")
+	}
+
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read segment")
+	}
+
+	if isCompressed(bytes.NewReader(data)) {
+		return nil
+	}
+
+	if err := c.replaceSegmentFile(seg, data); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(segmentPath, time.Time{}, stat.ModTime()); err != nil {
+		return errors.Wrap(err, "failed to restore modification time of segment")
+	}
+
+	return c.writeSidecar(seg)
+}
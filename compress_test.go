@@ -0,0 +1,143 @@
+package gowal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/gowal/frame"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressSegments(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 50,
+		MaxSegments:      10,
+		OffsetOnlyIndex:  true,
+		CompressSegments: true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	value := func(i int) string { return strings.Repeat("value"+strconv.Itoa(i), 100) }
+	for i := 0; i < 180; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte(value(i))))
+	}
+
+	segments, err := ListSegments(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Len(t, segments, 4)
+	for _, s := range segments[:3] {
+		data, err := os.ReadFile(s.Path)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(data, []byte(compressedMagic)))
+		require.Equal(t, 50, s.Records)
+		require.Less(t, s.Size, int64(50*len(value(0))/3))
+
+		_, err = VerifySegment(s.Path)
+		require.NoError(t, err)
+	}
+
+	check := func(log *Wal, n int) {
+		for i := 0; i < n; i++ {
+			_, v, ok := log.Get(uint64(i))
+			require.True(t, ok)
+			require.Equal(t, value(i), string(v))
+		}
+
+		var count int
+		for m := range log.Iterator() {
+			require.Equal(t, value(int(m.Idx)), string(m.Value))
+			count++
+		}
+		require.Equal(t, n, count)
+	}
+	check(log, 180)
+
+	pos, err := log.Position(10)
+	require.NoError(t, err)
+	data, err := log.FrameAt(pos.Segment, pos.Offset)
+	require.NoError(t, err)
+	r, _, err := frame.DecodeFrame(data)
+	require.NoError(t, err)
+	require.Equal(t, value(10), string(r.Value))
+
+	// compacted segments stay compressed
+	log.mu.Lock()
+	log.index.Delete(3)
+	log.mu.Unlock()
+	require.NoError(t, log.Compact())
+	segments, err = ListSegments(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, 49, segments[0].Records)
+	compacted, err := os.ReadFile(segments[0].Path)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(compacted, []byte(compressedMagic)))
+	require.NoError(t, log.Close())
+
+	report, err := RebuildIndex(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Empty(t, report)
+
+	// compressed segments are decoded on startup, sidecars are used if they are valid
+	for _, useSidecars := range []bool{true, false} {
+		if !useSidecars {
+			for _, s := range segments[:3] {
+				require.NoError(t, os.Remove(s.Path+sidecarPostfix))
+			}
+		}
+
+		log, err = NewWAL(cfg)
+		require.NoError(t, err)
+		require.Equal(t, 179, log.Len())
+		_, v, ok := log.Get(120)
+		require.True(t, ok)
+		require.Equal(t, value(120), string(v))
+		require.NoError(t, log.Close())
+	}
+
+	// segment truncated by TruncateAfter is decompressed and becomes the active one
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.NoError(t, log.TruncateAfter(120))
+	require.NoError(t, log.Write(121, "key121", []byte(value(121))))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(121), log.CurrentIndex())
+	_, v, ok := log.Get(121)
+	require.True(t, ok)
+	require.Equal(t, value(121), string(v))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestCompressedSegmentReadAt(t *testing.T) {
+	data := make([]byte, 3*compressedBlockSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	compressed, err := compressSegmentData(data)
+	require.NoError(t, err)
+
+	content, err := openCompressedSegment(bytes.NewReader(compressed), int64(len(compressed)))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), content.Size())
+
+	for _, r := range [][2]int{{0, 10}, {compressedBlockSize - 5, 10}, {10, 2*compressedBlockSize + 50}, {len(data) - 10, 10}} {
+		buf := make([]byte, r[1])
+		_, err := content.ReadAt(buf, int64(r[0]))
+		require.NoError(t, err)
+		require.Equal(t, data[r[0]:r[0]+r[1]], buf)
+	}
+
+	buf := make([]byte, 20)
+	n, err := content.ReadAt(buf, int64(len(data)-10))
+	require.Equal(t, 10, n)
+	require.Error(t, err)
+}
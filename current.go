@@ -0,0 +1,74 @@
+package gowal
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+// currentPostfix is appended to the segment prefix to get the name of the file pointing to the active segment.
+const currentPostfix = "current"
+
+// ErrMissingSegment is returned by NewWAL if the active segment recorded in the current file is missing,
+// e.g. the newest segments were removed from the WAL directory. Use UnsafeRecover to open the WAL anyway.
+var ErrMissingSegment = errors.New("active segment is missing")
+
+// CurrentSegment is the content of the current file of the WAL (named by the segment prefix followed
+// by "current"), which points to the active segment like CURRENT file of LevelDB. It is replaced atomically
+// every time the active segment changes, so external tools can find the tail of the log without listing
+// the WAL directory.
+type CurrentSegment struct {
+	// Active is the number of the active segment.
+	Active int `json:"active_segment"`
+
+	// Next is the number of the segment created by the next rotation.
+	Next int `json:"next_segment"`
+}
+
+// ReadCurrentSegment reads the current file of the WAL in dir.
+func ReadCurrentSegment(dir, prefix string) (CurrentSegment, error) {
+	currentPath := path.Join(dir, prefix+currentPostfix)
+
+	data, err := os.ReadFile(currentPath)
+	if err != nil {
+		return CurrentSegment{}, err
+	}
+
+	var current CurrentSegment
+	if err := json.Unmarshal(data, &current); err != nil {
+		return CurrentSegment{}, errors.Wrapf(err, "failed to decode current file %s", currentPath)
+	}
+
+	return current, nil
+}
+
+// writeCurrentSegment atomically replaces the current file of the WAL, pointing it to the given active segment.
+func writeCurrentSegment(dir, prefix string, active int, mode os.FileMode) error {
+	data, err := json.Marshal(CurrentSegment{Active: active, Next: active + 1})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode current file")
+	}
+
+	return errors.Wrap(writeFileAtomic(path.Join(dir, prefix+currentPostfix), data, mode), "failed to write current file")
+}
+
+// checkCurrentSegment checks that the active segment recorded in the current file exists and points
+// the current file to the newest segment found in the directory. Segments newer than the recorded one
+// are created by rotations interrupted before the current file was updated.
+func checkCurrentSegment(dir, prefix string, newest int, mode os.FileMode) error {
+	current, err := ReadCurrentSegment(dir, prefix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && current.Active > newest {
+		return errors.Wrapf(ErrMissingSegment, "current file points to segment %d, but the newest segment is %d", current.Active, newest)
+	}
+
+	if err == nil && current.Active == newest {
+		return nil
+	}
+
+	return writeCurrentSegment(dir, prefix, newest, mode)
+}
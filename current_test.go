@@ -0,0 +1,58 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCurrentSegment(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 2, MaxSegments: 10}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	current, err := ReadCurrentSegment(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSegment{Active: 0, Next: 1}, current)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	current, err = ReadCurrentSegment(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSegment{Active: 2, Next: 3}, current)
+
+	require.NoError(t, log.TruncateAfter(2))
+	current, err = ReadCurrentSegment(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSegment{Active: 1, Next: 2}, current)
+
+	require.NoError(t, log.Rotate())
+	require.NoError(t, log.Close())
+
+	// the current file is fixed if rotation was interrupted before it was updated
+	require.NoError(t, writeCurrentSegment(cfg.Dir, cfg.Prefix, 1, defaultFileMode))
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	current, err = ReadCurrentSegment(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, CurrentSegment{Active: 2, Next: 3}, current)
+
+	// the active segment is lost
+	for _, postfix := range []string{"", checkSumPostfix, sidecarPostfix} {
+		require.NoError(t, os.RemoveAll(log.segmentPath(2)+postfix))
+	}
+	_, err = NewWAL(cfg)
+	require.ErrorIs(t, err, ErrMissingSegment)
+
+	_, err = UnsafeRecover(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), log.CurrentIndex())
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,115 @@
+package gowal
+
+import "math"
+
+// Cursor moves over messages of the log in both directions, in order of their indexes.
+// It doesn't hold any locks, so messages written after the cursor was created are visible to it.
+//
+// Should be used like this:
+//
+//	cur := wal.Cursor()
+//	for ok := cur.Seek(savedIndex); ok; ok = cur.Next() {
+//		msg := cur.Value()
+//		...
+type Cursor struct {
+	w *Wal
+
+	msg   Msg
+	valid bool
+	err   error
+}
+
+// Cursor returns a new cursor. It is not positioned, call Seek, First, Last, Next or Prev to move it.
+func (c *Wal) Cursor() *Cursor {
+	return &Cursor{w: c}
+}
+
+// Seek moves the cursor to the msg with the smallest index that is greater than or equal to index.
+// It returns false if there is no such msg, position of the cursor is not changed in this case.
+func (cur *Cursor) Seek(index uint64) bool {
+	cur.w.mu.RLock()
+	defer cur.w.mu.RUnlock()
+
+	return cur.moveTo(cur.w.seek(index))
+}
+
+// First moves the cursor to the oldest msg. It returns false if the log is empty.
+func (cur *Cursor) First() bool {
+	return cur.Seek(0)
+}
+
+// Last moves the cursor to the newest msg. It returns false if the log is empty.
+func (cur *Cursor) Last() bool {
+	cur.w.mu.RLock()
+	defer cur.w.mu.RUnlock()
+
+	return cur.moveTo(cur.w.seekReverse(math.MaxUint64))
+}
+
+// Next moves the cursor to the next msg, or to the oldest msg if the cursor is not positioned.
+// It returns false if there is no next msg, position of the cursor is not changed in this case,
+// so Next can be called again after new messages are written.
+func (cur *Cursor) Next() bool {
+	cur.w.mu.RLock()
+	defer cur.w.mu.RUnlock()
+
+	if !cur.valid {
+		return cur.moveTo(cur.w.seek(0))
+	}
+
+	if cur.msg.Idx == math.MaxUint64 {
+		return false
+	}
+
+	return cur.moveTo(cur.w.seek(cur.msg.Idx + 1))
+}
+
+// Prev moves the cursor to the previous msg, or to the newest msg if the cursor is not positioned.
+// It returns false if there is no previous msg, position of the cursor is not changed in this case.
+func (cur *Cursor) Prev() bool {
+	cur.w.mu.RLock()
+	defer cur.w.mu.RUnlock()
+
+	if !cur.valid {
+		return cur.moveTo(cur.w.seekReverse(math.MaxUint64))
+	}
+
+	if cur.msg.Idx == 0 {
+		return false
+	}
+
+	return cur.moveTo(cur.w.seekReverse(cur.msg.Idx - 1))
+}
+
+// Valid reports whether the cursor is positioned at a msg.
+func (cur *Cursor) Valid() bool {
+	return cur.valid
+}
+
+// Value returns the msg the cursor is positioned at, the zero Msg if the cursor is not positioned.
+func (cur *Cursor) Value() Msg {
+	return cur.msg
+}
+
+// Err returns the error that stopped the last move of the cursor, if the msg couldn't be read from disk.
+func (cur *Cursor) Err() error {
+	return cur.err
+}
+
+// moveTo moves the cursor to the found msg, the caller must hold the lock.
+func (cur *Cursor) moveTo(m Msg, found bool) bool {
+	cur.err = nil
+	if !found {
+		return false
+	}
+
+	m, err := cur.w.materialize(m)
+	if err != nil {
+		cur.err = err
+		return false
+	}
+
+	cur.msg, cur.valid = m, true
+
+	return true
+}
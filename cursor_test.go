@@ -0,0 +1,61 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	// write only even indexes
+	for i := 0; i < 10; i += 2 {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	cur := log.Cursor()
+	require.False(t, cur.Valid())
+
+	t.Run("forward", func(t *testing.T) {
+		var seen []uint64
+		for ok := cur.First(); ok; ok = cur.Next() {
+			seen = append(seen, cur.Value().Idx)
+		}
+		require.Equal(t, []uint64{0, 2, 4, 6, 8}, seen)
+
+		// cursor stays at the last msg and sees new ones
+		require.Equal(t, uint64(8), cur.Value().Idx)
+		require.NoError(t, log.Write(10, "key10", []byte("value10")))
+		require.True(t, cur.Next())
+		require.Equal(t, "value10", string(cur.Value().Value))
+	})
+
+	t.Run("backward", func(t *testing.T) {
+		var seen []uint64
+		for ok := cur.Last(); ok; ok = cur.Prev() {
+			seen = append(seen, cur.Value().Idx)
+		}
+		require.Equal(t, []uint64{10, 8, 6, 4, 2, 0}, seen)
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		require.True(t, cur.Seek(3))
+		require.Equal(t, uint64(4), cur.Value().Idx)
+		require.True(t, cur.Prev())
+		require.Equal(t, uint64(2), cur.Value().Idx)
+
+		require.False(t, cur.Seek(11))
+		require.Equal(t, uint64(2), cur.Value().Idx)
+	})
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,78 @@
+package gowal
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/pkg/errors"
+	"slices"
+)
+
+// ErrDuplicateIndex is returned by NewWAL if msgs with the same index are stored in more than one segment
+// and DuplicatePolicy is FailOnDuplicate.
+var ErrDuplicateIndex = errors.New("msg with the same index is stored in more than one segment")
+
+// DuplicatePolicy defines what NewWAL does with msgs with the same index stored in more than one segment,
+// e.g. after segments were copied between WAL directories by hand, see Config.DuplicatePolicy.
+type DuplicatePolicy int
+
+const (
+	// NewestWins keeps the msg from the newest segment, msgs from older segments are ignored
+	// and removed by compaction. Duplicates are reported in Stats.
+	NewestWins DuplicatePolicy = iota
+
+	// FailOnDuplicate makes NewWAL fail with ErrDuplicateIndex.
+	FailOnDuplicate
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case NewestWins:
+		return "newest wins"
+	case FailOnDuplicate:
+		return "fail on duplicate"
+	default:
+		return fmt.Sprintf("DuplicatePolicy(%d)", int(p))
+	}
+}
+
+// duplicates collects numbers of segments storing msgs with the same index, found while indexes of segments
+// are merged on load.
+type duplicates map[uint64][]int
+
+// merge copies msgs of newer segments to index, recording indexes stored in both. Msgs of newer segments win.
+func (d duplicates) merge(index, newer map[uint64]Msg) {
+	for idx, m := range newer {
+		if old, ok := index[idx]; ok && old.seg != m.seg {
+			if len(d[idx]) == 0 {
+				d[idx] = []int{old.seg}
+			}
+			d[idx] = append(d[idx], m.seg)
+		}
+		index[idx] = m
+	}
+}
+
+// list returns duplicates ordered by index, segments of every duplicate are ordered from the oldest to the newest.
+func (d duplicates) list() []DuplicateIndex {
+	list := make([]DuplicateIndex, 0, len(d))
+	for idx, segments := range d {
+		segments = slices.Clone(segments)
+		slices.Sort(segments)
+		list = append(list, DuplicateIndex{Index: idx, Segments: slices.Compact(segments)})
+	}
+	slices.SortFunc(list, func(a, b DuplicateIndex) int { return cmp.Compare(a.Index, b.Index) })
+
+	return list
+}
+
+// check returns ErrDuplicateIndex describing the first duplicates if there are any and the policy is FailOnDuplicate.
+func (d duplicates) check(policy DuplicatePolicy) error {
+	if policy != FailOnDuplicate || len(d) == 0 {
+		return nil
+	}
+
+	list := d.list()
+	first := list[0]
+
+	return errors.Wrapf(ErrDuplicateIndex, "%d indexes are duplicated, e.g. msg %d is stored in segments %v", len(list), first.Index, first.Segments)
+}
@@ -0,0 +1,57 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDuplicatePolicy(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// msg 1 is written again to the active segment, e.g. as if segments were copied by hand
+	log.mu.Lock()
+	log.index.Delete(1)
+	log.mu.Unlock()
+	require.NoError(t, log.Write(1, "key1", []byte("newer")))
+	active := log.activeSegment
+	require.NoError(t, log.Close())
+
+	// the newest msg wins by default
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, []DuplicateIndex{{Index: 1, Segments: []int{0, active}}}, log.Stats().DuplicateIndexes)
+	_, value, ok := log.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "newer", string(value))
+	require.Equal(t, 7, log.Len())
+	require.NoError(t, log.Close())
+
+	// older segments are loaded in the background with LazyLoad, duplicates are merged the same way
+	cfg.LazyLoad = true
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	_, value, ok = log.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "newer", string(value))
+	require.Equal(t, []DuplicateIndex{{Index: 1, Segments: []int{0, active}}}, log.Stats().DuplicateIndexes)
+	require.NoError(t, log.Close())
+
+	cfg.DuplicatePolicy = FailOnDuplicate
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.ErrorIs(t, log.Write(7, "key7", []byte("value7")), ErrDuplicateIndex)
+	require.NoError(t, log.Close())
+
+	cfg.LazyLoad = false
+	_, err = NewWAL(cfg)
+	require.ErrorIs(t, err, ErrDuplicateIndex)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
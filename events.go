@@ -0,0 +1,75 @@
+package gowal
+
+import "fmt"
+
+// EventType is the type of Event.
+type EventType int
+
+const (
+	// EventSegmentSealed is emitted when the active segment is sealed by rotation.
+	EventSegmentSealed EventType = iota
+
+	// EventSegmentCreated is emitted when a new active segment is created by rotation.
+	EventSegmentCreated
+
+	// EventSegmentEvicted is emitted when the oldest segment is deleted or moved to Config.ArchiveDir
+	// because of MaxSegments, MaxTotalBytes or RetentionAge.
+	EventSegmentEvicted
+
+	// EventCorruptionDetected is emitted when a corrupted msg is found on disk or in memory.
+	EventCorruptionDetected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSegmentSealed:
+		return "segment sealed"
+	case EventSegmentCreated:
+		return "segment created"
+	case EventSegmentEvicted:
+		return "segment evicted"
+	case EventCorruptionDetected:
+		return "corruption detected"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes something that happened to the WAL, see Config.EventHandler.
+type Event struct {
+	Type EventType
+
+	// Segment is the number of the segment the event is about and Path is the path of its file
+	// (evicted segments don't exist there anymore).
+	Segment int
+	Path    string
+
+	// Index is the index of the corrupted msg, set for EventCorruptionDetected.
+	Index uint64
+
+	// Err describes the corruption, set for EventCorruptionDetected.
+	Err error
+}
+
+// EventHandler handles events of the WAL, see Config.EventHandler.
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// emit passes the event to the event handler if it is set.
+func (c *Wal) emit(e Event) {
+	if c.eventHandler != nil {
+		c.eventHandler.HandleEvent(e)
+	}
+}
+
+// diskCorruption counts msg found corrupted on disk and reports it to the event handler and OnCorruption.
+func (c *Wal) diskCorruption(m Msg, err error) {
+	c.diskCorruptions.Add(1)
+
+	segmentPath := c.segmentPath(m.seg)
+	c.emit(Event{Type: EventCorruptionDetected, Segment: m.seg, Path: segmentPath, Index: m.Idx, Err: err})
+	if c.onCorruption != nil {
+		c.onCorruption(segmentPath, m.off, m.Idx, err)
+	}
+}
@@ -0,0 +1,58 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+type recordedEvents []Event
+
+func (r *recordedEvents) HandleEvent(e Event) {
+	*r = append(*r, e)
+}
+
+func TestEventHandler(t *testing.T) {
+	var events recordedEvents
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      2,
+		OffsetOnlyIndex:  true,
+		EventHandler:     &events,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	types := make([]EventType, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	require.Equal(t, []EventType{EventSegmentSealed, EventSegmentCreated, EventSegmentSealed, EventSegmentCreated, EventSegmentEvicted}, types)
+	require.Equal(t, Event{Type: EventSegmentEvicted, Segment: 0, Path: log.segmentPath(0)}, events[4])
+	require.Equal(t, 2, events[3].Segment)
+
+	pos, err := log.Position(3)
+	require.NoError(t, err)
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	events = nil
+	_, err = log.GetMsg(3)
+	require.ErrorIs(t, err, ErrCorruptedFrame)
+	require.Len(t, events, 1)
+	require.Equal(t, EventCorruptionDetected, events[0].Type)
+	require.Equal(t, uint64(3), events[0].Index)
+	require.ErrorIs(t, events[0].Err, ErrCorruptedFrame)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
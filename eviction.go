@@ -0,0 +1,61 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// ErrFull is returned by writes when the active segment has to be rotated, but the WAL already has
+// MaxSegments segments and EvictionPolicy is Error.
+var ErrFull = errors.New("maximum number of segments reached")
+
+// EvictionPolicy defines what happens when the active segment has to be rotated, but the WAL
+// already has MaxSegments segments, see Config.EvictionPolicy.
+type EvictionPolicy int
+
+const (
+	// DeleteOldest evicts the oldest segments (deletes them or moves them to Config.ArchiveDir).
+	DeleteOldest EvictionPolicy = iota
+
+	// BlockWrites makes writes wait until segments are removed, e.g. by TruncateBefore or Compact.
+	BlockWrites
+
+	// Error makes writes fail with ErrFull until segments are removed.
+	Error
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case DeleteOldest:
+		return "delete oldest"
+	case BlockWrites:
+		return "block writes"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+	}
+}
+
+// segmentsFull reports whether rotation would exceed MaxSegments while segments may not be evicted.
+// The caller must hold the lock.
+func (c *Wal) segmentsFull() bool {
+	return c.evictionPolicy != DeleteOldest && len(c.segments) >= max(c.maxSegments, 1)
+}
+
+// waitForFreeSegment waits until the active segment can be rotated if it has to be
+// and EvictionPolicy is BlockWrites. The caller must hold the lock, which is released while waiting.
+func (c *Wal) waitForFreeSegment() error {
+	if c.evictionPolicy != BlockWrites {
+		return nil
+	}
+
+	for c.needsRotation() && c.segmentsFull() {
+		if c.closed {
+			return errors.New("wal was closed while waiting for segments to be removed")
+		}
+		c.segmentRemoved.Wait()
+	}
+
+	return nil
+}
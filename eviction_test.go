@@ -0,0 +1,71 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEvictionPolicyError(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      2,
+		EvictionPolicy:   Error,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.ErrorIs(t, log.Write(4, "key4", []byte("value4")), ErrFull)
+	require.True(t, log.Exists(0))
+	require.False(t, log.Exists(4))
+
+	require.NoError(t, log.TruncateBefore(2))
+	require.NoError(t, log.Write(4, "key4", []byte("value4")))
+	require.True(t, log.Exists(4))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestEvictionPolicyBlockWrites(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      2,
+		EvictionPolicy:   BlockWrites,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	written := make(chan error, 1)
+	go func() { written <- log.Write(4, "key4", []byte("value4")) }()
+
+	select {
+	case err := <-written:
+		t.Fatalf("write wasn't blocked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, log.TruncateBefore(2))
+	require.NoError(t, <-written)
+	require.True(t, log.Exists(4))
+
+	// blocked writes fail when the WAL is closed
+	require.NoError(t, log.Write(5, "key5", []byte("value5")))
+	go func() { written <- log.Write(6, "key6", []byte("value6")) }()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, log.Close())
+	require.Error(t, <-written)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
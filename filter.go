@@ -0,0 +1,153 @@
+package gowal
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"os"
+)
+
+const (
+	// filterBitsPerKey is the number of filter bits per key, gives ~1% false positive rate.
+	filterBitsPerKey = 10
+
+	// filterHashes is the number of hash functions of the filter.
+	filterHashes = 7
+
+	// defaultFilterKeys is the number of keys the filter of the active segment is sized for
+	// if segments are not rotated by the number of records.
+	defaultFilterKeys = 1024
+)
+
+// keyFilter is a bloom filter over keys of msgs of a segment,
+// used to skip segments that definitely don't contain the key.
+type keyFilter struct {
+	bits []uint64
+
+	// number of keys added to the filter, unknown for filters loaded from sidecars
+	n int
+}
+
+// newKeyFilter creates filter sized for n keys.
+func newKeyFilter(n int) *keyFilter {
+	words := (max(n, 1)*filterBitsPerKey + 63) / 64
+	return &keyFilter{bits: make([]uint64, words)}
+}
+
+// addToFilter adds key of the msg written to the segment to the key filter of the segment.
+func (c *Wal) addToFilter(seg int, key string) {
+	f, ok := c.filters[seg]
+	if !ok {
+		n := c.segmentsThreshold
+		if n <= 0 {
+			n = defaultFilterKeys
+		}
+		f = newKeyFilter(n)
+		c.filters[seg] = f
+	}
+
+	f.add(key)
+}
+
+// sealFilter rebuilds the key filter of the sealed segment for the actual number of its keys if more keys
+// were written to the segment than the filter was sized for, e.g. if segments are not rotated by the number
+// of records. Keys of msgs not kept in memory are read from the segment. The caller must hold the lock.
+func (c *Wal) sealFilter(seg int) error {
+	f, ok := c.filters[seg]
+	if !ok || f.n <= len(f.bits)*64/filterBitsPerKey {
+		return nil
+	}
+
+	meta := c.metas[seg]
+	var (
+		keys   []string
+		onDisk bool
+	)
+	c.index.Range(meta.first, func(m Msg) bool {
+		if m.seg == seg {
+			keys = append(keys, m.Key)
+			onDisk = onDisk || m.onDisk
+		}
+		return m.Idx < meta.last
+	})
+
+	if onDisk {
+		file, err := os.Open(c.segmentPath(seg))
+		if err != nil {
+			return errors.Wrap(err, "failed to open log segment file")
+		}
+		msgs, err := loadIndexes(file, c.codec)
+		file.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to read keys of segment")
+		}
+
+		keys = keys[:0]
+		for _, m := range msgs {
+			keys = append(keys, m.Key)
+		}
+	}
+
+	sealed := newKeyFilter(len(keys))
+	for _, key := range keys {
+		sealed.add(key)
+	}
+	c.filters[seg] = sealed
+
+	return nil
+}
+
+func (f *keyFilter) add(key string) {
+	f.n++
+	h1, h2 := filterHash(key)
+	m := uint64(len(f.bits) * 64)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain reports whether the key may be in the filter, false means the key is definitely not there.
+func (f *keyFilter) mayContain(key string) bool {
+	h1, h2 := filterHash(key)
+	m := uint64(len(f.bits) * 64)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *keyFilter) encode() []byte {
+	buf := make([]byte, 0, len(f.bits)*8)
+	for _, w := range f.bits {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+
+	return buf
+}
+
+func decodeKeyFilter(data []byte) (*keyFilter, error) {
+	if len(data) == 0 || len(data)%8 != 0 {
+		return nil, errors.Errorf("bad key filter size %d", len(data))
+	}
+
+	f := &keyFilter{bits: make([]uint64, len(data)/8)}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+
+	return f, nil
+}
+
+// filterHash returns two hashes of the key for double hashing.
+func filterHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+
+	return sum & 0xffffffff, sum>>32 | 1
+}
@@ -0,0 +1,206 @@
+package gowal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// footerMagic starts the payload of the footer frame, msgpack-encoded msgs never start with it.
+	footerMagic = "GWFT"
+
+	// footerPayloadSize is the size of the payload of the footer frame.
+	footerPayloadSize = 28
+
+	// footerSize is the size of the footer frame.
+	footerSize = frame.MaxHeaderSize + footerPayloadSize
+
+	// legacyFooterSize is the size of the footer frame without header checksum, written by older versions.
+	legacyFooterSize = frame.HeaderSize + footerPayloadSize
+)
+
+var (
+	// ErrNoFooter is returned for segments without footer: the active segment and segments sealed by older versions.
+	ErrNoFooter = errors.New("segment has no footer")
+
+	// errFooter is returned by readFrame for the footer frame, which doesn't hold a msg.
+	errFooter = errors.New("segment footer")
+)
+
+// SegmentFooter describes a sealed segment, it is written at the end of the segment when it is sealed,
+// so the segment can be described and verified without decoding its records.
+//
+// Footer is a regular frame with fixed size payload (little endian):
+//
+//	+-------+---------+-------------+------------+-------+
+//	| magic | records | first index | last index | crc32 |
+//	| 4     | 4       | 8           | 8          | 4     |
+//	+-------+---------+-------------+------------+-------+
+//
+// crc32 is the checksum of all bytes of the segment before the footer frame. It is updated on every write
+// to the active segment, so sealing the segment doesn't read it back.
+type SegmentFooter struct {
+	// Records is the number of records stored in the segment.
+	Records int
+
+	// FirstIndex and LastIndex are the lowest and the highest indexes of records stored in the segment.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// CRC is the crc32 (IEEE) of all bytes of the segment before the footer.
+	CRC uint32
+}
+
+func (f SegmentFooter) encode() []byte {
+	payload := make([]byte, 0, footerPayloadSize)
+	payload = append(payload, footerMagic...)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(f.Records))
+	payload = binary.LittleEndian.AppendUint64(payload, f.FirstIndex)
+	payload = binary.LittleEndian.AppendUint64(payload, f.LastIndex)
+	payload = binary.LittleEndian.AppendUint32(payload, f.CRC)
+
+	return frame.Encode(payload)
+}
+
+// isFooter reports whether the frame payload is the payload of the footer.
+func isFooter(payload []byte) bool {
+	return len(payload) == footerPayloadSize && bytes.HasPrefix(payload, []byte(footerMagic))
+}
+
+func decodeFooter(payload []byte) SegmentFooter {
+	return SegmentFooter{
+		Records:    int(binary.LittleEndian.Uint32(payload[4:8])),
+		FirstIndex: binary.LittleEndian.Uint64(payload[8:16]),
+		LastIndex:  binary.LittleEndian.Uint64(payload[16:24]),
+		CRC:        binary.LittleEndian.Uint32(payload[24:28]),
+	}
+}
+
+// newFooter returns footer of the segment with the given content and metadata.
+func newFooter(data []byte, meta segmentMeta) SegmentFooter {
+	return SegmentFooter{Records: meta.records, FirstIndex: meta.first, LastIndex: meta.last, CRC: crc32.ChecksumIEEE(data)}
+}
+
+// segmentFileCRC returns crc32 (IEEE) of the content of the uncompressed segment file, it is the starting value
+// of the running checksum of the active segment (see Wal.segmentCRC).
+func segmentFileCRC(segmentPath string) (uint32, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open segment")
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, errors.Wrap(err, "failed to read segment")
+	}
+
+	return h.Sum32(), nil
+}
+
+// writeFooter appends footer to the active segment before it is sealed and updates its checksum.
+// Modification time of the segment is kept, it stands for the time of the newest msg (see Config.RetentionAge).
+// The caller must hold the lock.
+func (c *Wal) writeFooter() error {
+	stat, err := c.log.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat segment")
+	}
+
+	meta := c.metas[c.activeSegment]
+	footer := SegmentFooter{Records: meta.records, FirstIndex: meta.first, LastIndex: meta.last, CRC: c.segmentCRC}.encode()
+	if _, err := c.log.Write(footer); err != nil {
+		return errors.Wrap(err, "failed to write segment footer")
+	}
+	c.lastOffset += int64(len(footer))
+
+	if err := writeChecksum(c.log, c.checksum); err != nil {
+		return errors.Wrap(err, "failed to write checksum")
+	}
+
+	if err := c.sync(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(c.log.Name(), time.Time{}, stat.ModTime()); err != nil {
+		return errors.Wrap(err, "failed to restore modification time of segment")
+	}
+
+	return nil
+}
+
+// ReadSegmentFooter reads footer of the segment file without reading the rest of the segment.
+// It fails with ErrNoFooter if the segment has no footer.
+func ReadSegmentFooter(segmentPath string) (SegmentFooter, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return SegmentFooter{}, errors.Wrap(err, "failed to open segment")
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return SegmentFooter{}, err
+	}
+
+	footer, _, err := readFooter(content)
+
+	return footer, err
+}
+
+// readFooter reads footer at the end of the segment content and returns it along with the size of the footer frame.
+func readFooter(content segmentContent) (SegmentFooter, int64, error) {
+	for _, size := range []int64{footerSize, legacyFooterSize} {
+		if content.Size() < segmentHeaderSize+size {
+			continue
+		}
+
+		buf := make([]byte, size)
+		if _, err := content.ReadAt(buf, content.Size()-size); err != nil {
+			return SegmentFooter{}, 0, errors.Wrap(err, "failed to read segment footer")
+		}
+
+		if payload, n, err := frame.Decode(buf); err == nil && int64(n) == size && isFooter(payload) {
+			return decodeFooter(payload), size, nil
+		}
+	}
+
+	return SegmentFooter{}, 0, ErrNoFooter
+}
+
+// VerifySegment checks that content of the sealed segment file matches the checksum from its footer,
+// without decoding records of the segment. It returns the footer of the segment.
+func VerifySegment(segmentPath string) (SegmentFooter, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return SegmentFooter{}, errors.Wrap(err, "failed to open segment")
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return SegmentFooter{}, err
+	}
+
+	footer, size, err := readFooter(content)
+	if err != nil {
+		return SegmentFooter{}, err
+	}
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, io.NewSectionReader(content, 0, content.Size()-size)); err != nil {
+		return SegmentFooter{}, errors.Wrap(err, "failed to read segment")
+	}
+
+	if h.Sum32() != footer.CRC {
+		return SegmentFooter{}, errors.Errorf("segment %s is corrupted, checksum doesn't match its footer", segmentPath)
+	}
+
+	return footer, nil
+}
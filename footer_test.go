@@ -0,0 +1,96 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSegmentFooter(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      100,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	footer, err := VerifySegment(log.segmentPath(1))
+	require.NoError(t, err)
+	require.Equal(t, SegmentFooter{Records: 3, FirstIndex: 3, LastIndex: 5, CRC: footer.CRC}, footer)
+
+	_, err = ReadSegmentFooter(log.segmentPath(2))
+	require.ErrorIs(t, err, ErrNoFooter)
+
+	// footer is rewritten along with the segment
+	log.index.Delete(4)
+	require.NoError(t, log.Compact())
+	footer, err = VerifySegment(log.segmentPath(1))
+	require.NoError(t, err)
+	require.Equal(t, 2, footer.Records)
+	require.NoError(t, log.Close())
+
+	// footers are skipped on startup
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 6, log.Len())
+	_, value, ok := log.Get(5)
+	require.True(t, ok)
+	require.Equal(t, "value5", string(value))
+	require.NoError(t, log.Close())
+
+	// sealed segment with stale checksum is kept by recovery
+	segmentPath := "./testlogdata/" + segmentName("log_", 0)
+	require.NoError(t, os.WriteFile(segmentPath+checkSumPostfix, []byte("stale"), 0755))
+	removed, err := UnsafeRecover("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Empty(t, removed)
+
+	data, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	data[segmentHeaderSize+10] ^= 0xff
+	require.NoError(t, os.WriteFile(segmentPath, data, 0755))
+	_, err = VerifySegment(segmentPath)
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentFooterRunningCRC(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 4,
+		MaxSegments:      100,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	// running checksum of the active segment survives restarts and truncation
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.NoError(t, log.TruncateAfter(1))
+	for i := 2; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	for _, seg := range []int{0, 1} {
+		footer, err := VerifySegment(log.segmentPath(seg))
+		require.NoError(t, err)
+		require.Equal(t, 4, footer.Records)
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
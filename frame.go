@@ -0,0 +1,133 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"io"
+)
+
+var ErrCorruptedFrame = frame.ErrCorrupted
+
+// Checksum is the algorithm of checksums of msgs, see Config.Checksum.
+type Checksum = frame.Checksum
+
+const (
+	CRC32IEEE       = frame.CRC32IEEE
+	CRC32Castagnoli = frame.CRC32Castagnoli
+	XXHash64        = frame.XXHash64
+)
+
+// encodeFrame encodes msg with the codec into a frame with the given checksum algorithm, see package frame
+// for the layout. Checksum of the frame is not computed if unchecked is set.
+func encodeFrame(m Msg, codec Codec, checksum Checksum, unchecked bool) ([]byte, error) {
+	payload, err := codec.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode msg")
+	}
+
+	if len(payload) > frame.MaxPayloadSize {
+		return nil, errors.Errorf("encoded msg is %d bytes, max size is %d", len(payload), frame.MaxPayloadSize)
+	}
+
+	if unchecked {
+		return frame.EncodeUnchecked(payload), nil
+	}
+
+	return frame.EncodeWith(payload, checksum), nil
+}
+
+// readFrame reads one frame from r and returns msg decoded with the codec and the size of the frame in bytes.
+// It returns io.EOF if r has no more frames and errFooter (with the size of the frame) for the segment footer.
+func readFrame(r io.Reader, codec Codec) (Msg, int, error) {
+	payload, n, err := frame.Read(r)
+	if err != nil {
+		return Msg{}, 0, err
+	}
+
+	if isFooter(payload) {
+		return Msg{}, n, errFooter
+	}
+
+	m, err := decodePayload(payload, codec)
+	if err != nil {
+		return Msg{}, 0, err
+	}
+
+	return m, n, nil
+}
+
+// resync returns offset of the first valid frame after the corrupted frame at the given offset of the segment
+// content of the given size, false if there is none, see frame.Resync.
+func resync(content io.ReaderAt, offset, size int64) (int64, bool, error) {
+	rest := make([]byte, size-offset)
+	if _, err := content.ReadAt(rest, offset); err != nil {
+		return 0, false, errors.Wrap(err, "failed to read segment")
+	}
+
+	n, ok := frame.Resync(rest)
+
+	return offset + int64(n), ok, nil
+}
+
+// bundleMagic starts bundles returned by ReadFrames.
+const bundleMagic = "GWFB"
+
+// BundleHeaderSize is the size of the header of bundles returned by ReadFrames: 4 bytes of magic "GWFB"
+// followed by 16 bytes of ID of the WAL the frames were read from. Frames follow the header, so tools
+// working with the frame package skip it.
+const BundleHeaderSize = len(bundleMagic) + 16
+
+var (
+	// ErrForeignBundle is returned by DecodeFrames for bundles read from another WAL than the expected one.
+	ErrForeignBundle = errors.New("bundle was read from another WAL")
+
+	// ErrMalformedBundle is returned for data that doesn't start with the bundle header, see ReadFrames.
+	ErrMalformedBundle = errors.New("malformed bundle")
+)
+
+// BundleID returns ID of the WAL the bundle returned by ReadFrames was read from, see Stats.ID.
+func BundleID(bundle []byte) (string, error) {
+	if len(bundle) < BundleHeaderSize || string(bundle[:len(bundleMagic)]) != bundleMagic {
+		return "", ErrMalformedBundle
+	}
+
+	return formatID([16]byte(bundle[len(bundleMagic):BundleHeaderSize])), nil
+}
+
+// DecodeFrames decodes frames of the bundle returned by ReadFrames, verifying checksum of every frame.
+// It fails with ErrForeignBundle if the bundle was read from a WAL other than the one with the given ID
+// (see Stats.ID), so frames of a wrong log are never applied.
+// Frames must be written with the default codec, use DecodeFramesWith otherwise.
+func DecodeFrames(bundle []byte, walID string) ([]Msg, error) {
+	return DecodeFramesWith(bundle, walID, defaultCodec)
+}
+
+// DecodeFramesWith works like DecodeFrames for frames written with the given codec, see Config.Codec.
+func DecodeFramesWith(bundle []byte, walID string, codec Codec) ([]Msg, error) {
+	id, err := BundleID(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != walID {
+		return nil, errors.Wrapf(ErrForeignBundle, "bundle was read from WAL %s, expected %s", id, walID)
+	}
+
+	var msgs []Msg
+	for data := bundle[BundleHeaderSize:]; len(data) > 0; {
+		payload, n, err := frame.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := decodePayload(payload, codec)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+		data = data[n:]
+	}
+
+	return msgs, nil
+}
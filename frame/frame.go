@@ -0,0 +1,290 @@
+// Package frame implements the on-disk frame format of gowal.
+//
+// Every log record is stored as a frame: a fixed header followed by the payload.
+// Header holds payload length and checksum of the payload, so every frame can be verified on its own,
+// and crc32 (Castagnoli) of the first 8 bytes of the header, so the length can be trusted before the payload is read.
+//
+// Frame layout (little endian):
+//
+//	+----------------+-------------------+-----------------------+------------------+
+//	| payload length | checksum(payload) | crc32c(length, check) | payload          |
+//	| 4 bytes        | 4 bytes           | 4 bytes               | length bytes     |
+//	+----------------+-------------------+-----------------------+------------------+
+//
+// The highest bit of the length is set in frames written without checksum (see EncodeUnchecked),
+// their checksum field is zero and is not verified by readers. The next two bits hold the checksum
+// algorithm (see Checksum), so frames written with different algorithms can be mixed. Frames written
+// by older versions have zeros there, which stands for crc32 (IEEE). The next bit is set in frames
+// with the header checksum, frames written by older versions have no header checksum.
+//
+// A reader hitting a corrupted frame can't trust its length, use Resync to find the next valid frame.
+//
+// Payload of gowal records is msgpack-encoded Record (unless the WAL is configured with another codec),
+// use EncodeFrame and DecodeFrame to produce and consume gowal-compatible bytes without a Wal instance.
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// HeaderSize is the size of the header fields enough to get the size of the whole frame, see Size.
+const HeaderSize = 8
+
+// MaxHeaderSize is the size of the frame header with the header checksum.
+const MaxHeaderSize = HeaderSize + 4
+
+// headerChecksumFlag is set in the length field of frames with the header checksum.
+const headerChecksumFlag = 1 << 28
+
+// uncheckedFlag is set in the length field of frames written without checksum.
+const uncheckedFlag = 1 << 31
+
+// checksumShift and checksumMask select the checksum algorithm in the length field.
+const (
+	checksumShift = 29
+	checksumMask  = 3 << checksumShift
+)
+
+// MaxPayloadSize is the maximum size of the frame payload.
+const MaxPayloadSize = headerChecksumFlag - 1
+
+// Checksum is the algorithm of the frame checksum.
+type Checksum uint8
+
+const (
+	// CRC32IEEE is crc32 with the IEEE polynomial, the default algorithm.
+	CRC32IEEE Checksum = iota
+
+	// CRC32Castagnoli is crc32 with the Castagnoli polynomial, which is computed by CPU instructions
+	// on modern amd64 and arm64 CPUs, so it's several times faster than CRC32IEEE.
+	CRC32Castagnoli
+
+	// XXHash64 is xxhash64 truncated to its lower 32 bits.
+	XXHash64
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) String() string {
+	switch c {
+	case CRC32IEEE:
+		return "crc32-ieee"
+	case CRC32Castagnoli:
+		return "crc32-castagnoli"
+	case XXHash64:
+		return "xxhash64"
+	default:
+		return fmt.Sprintf("Checksum(%d)", int(c))
+	}
+}
+
+// Valid reports whether the algorithm is known.
+func (c Checksum) Valid() bool {
+	return c <= XXHash64
+}
+
+// sum returns checksum of the payload.
+func (c Checksum) sum(payload []byte) uint32 {
+	switch c {
+	case CRC32Castagnoli:
+		return crc32.Checksum(payload, castagnoliTable)
+	case XXHash64:
+		return uint32(xxhash.Sum64(payload))
+	default:
+		return crc32.ChecksumIEEE(payload)
+	}
+}
+
+var ErrCorrupted = errors.New("frame is corrupted, checksums do not match")
+
+// Record is a log record as it is encoded into the frame payload.
+type Record struct {
+	Idx       uint64
+	Key       string
+	Value     []byte
+	Timestamp time.Time
+}
+
+// EncodeFrame encodes record into a frame.
+func EncodeFrame(r Record) ([]byte, error) {
+	payload, err := msgpack.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode record")
+	}
+
+	return Encode(payload), nil
+}
+
+// DecodeFrame decodes the first frame in data into a record, verifying its checksum.
+// It returns the size of the frame, so data[n:] holds the rest of frames.
+func DecodeFrame(data []byte) (Record, int, error) {
+	payload, n, err := Decode(data)
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	var r Record
+	if err := msgpack.Unmarshal(payload, &r); err != nil {
+		return Record{}, 0, errors.Wrap(err, "failed to decode record")
+	}
+
+	return r, n, nil
+}
+
+// Encode wraps payload into a frame with crc32 (IEEE) checksum.
+func Encode(payload []byte) []byte {
+	return EncodeWith(payload, CRC32IEEE)
+}
+
+// EncodeWith wraps payload into a frame with checksum computed by the given algorithm.
+func EncodeWith(payload []byte, checksum Checksum) []byte {
+	return encode(payload, uint32(checksum)<<checksumShift, checksum.sum(payload))
+}
+
+// EncodeUnchecked wraps payload into a frame without computing its checksum,
+// for payloads already protected by the upper layer. Readers don't verify such frames.
+func EncodeUnchecked(payload []byte) []byte {
+	return encode(payload, uncheckedFlag, 0)
+}
+
+func encode(payload []byte, flags, sum uint32) []byte {
+	frame := make([]byte, MaxHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(payload))|flags|headerChecksumFlag)
+	binary.LittleEndian.PutUint32(frame[4:8], sum)
+	binary.LittleEndian.PutUint32(frame[8:12], crc32.Checksum(frame[0:8], castagnoliTable))
+	copy(frame[MaxHeaderSize:], payload)
+
+	return frame
+}
+
+// headerSize returns the size of the frame header, header must hold at least HeaderSize bytes.
+func headerSize(header []byte) int {
+	if binary.LittleEndian.Uint32(header[0:4])&headerChecksumFlag != 0 {
+		return MaxHeaderSize
+	}
+
+	return HeaderSize
+}
+
+// verifyHeader checks the header against the header checksum, if the frame has it.
+func verifyHeader(header []byte) error {
+	if len(header) < MaxHeaderSize || headerSize(header) != MaxHeaderSize {
+		return nil
+	}
+
+	if crc32.Checksum(header[0:8], castagnoliTable) != binary.LittleEndian.Uint32(header[8:12]) {
+		return errors.Wrap(ErrCorrupted, "header checksum mismatch")
+	}
+
+	return nil
+}
+
+// verify checks payload against the checksum from the frame header.
+func verify(header, payload []byte) error {
+	if binary.LittleEndian.Uint32(header[0:4])&uncheckedFlag != 0 {
+		return nil
+	}
+
+	checksum := Checksum(binary.LittleEndian.Uint32(header[0:4]) & checksumMask >> checksumShift)
+	if !checksum.Valid() {
+		return errors.Wrapf(ErrCorrupted, "unknown checksum algorithm %d", checksum)
+	}
+
+	if checksum.sum(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return ErrCorrupted
+	}
+
+	return nil
+}
+
+// PayloadSize returns size of the payload from the first HeaderSize bytes of the frame header.
+func PayloadSize(header []byte) int {
+	return int(binary.LittleEndian.Uint32(header[0:4]) &^ (uncheckedFlag | checksumMask | headerChecksumFlag))
+}
+
+// Size returns size of the whole frame (header and payload) from the first HeaderSize bytes of the frame header.
+func Size(header []byte) int {
+	return headerSize(header) + PayloadSize(header)
+}
+
+// Decode returns payload of the first frame in data, verifying its checksum.
+// It returns the size of the frame, so data[n:] holds the rest of frames.
+func Decode(data []byte) (payload []byte, n int, err error) {
+	if len(data) < HeaderSize || len(data) < headerSize(data) {
+		return nil, 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read frame header")
+	}
+
+	hs := headerSize(data)
+	if err := verifyHeader(data[:hs]); err != nil {
+		return nil, 0, err
+	}
+
+	size := PayloadSize(data)
+	if len(data)-hs < size {
+		return nil, 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read frame payload")
+	}
+
+	payload = data[hs : hs+size]
+	if err := verify(data, payload); err != nil {
+		return nil, 0, err
+	}
+
+	return payload, hs + size, nil
+}
+
+// Resync returns the offset of the first frame in data following a corrupted frame (or garbage) at its start,
+// so reading can continue after the corruption. Only frames with the header checksum are looked for,
+// a frame is found if both its header and its payload are valid. It returns false if there is no such frame.
+func Resync(data []byte) (int, bool) {
+	for off := 1; off+MaxHeaderSize <= len(data); off++ {
+		if binary.LittleEndian.Uint32(data[off:])&headerChecksumFlag == 0 {
+			continue
+		}
+
+		if _, _, err := Decode(data[off:]); err == nil {
+			return off, true
+		}
+	}
+
+	return 0, false
+}
+
+// Read reads the next frame from r and returns its payload, verifying its checksum.
+// It returns the size of the frame in bytes and io.EOF if r has no more frames.
+func Read(r io.Reader) (payload []byte, n int, err error) {
+	var header [MaxHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:HeaderSize]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errors.Wrap(err, "failed to read frame header")
+	}
+
+	hs := headerSize(header[:])
+	if _, err := io.ReadFull(r, header[HeaderSize:hs]); err != nil {
+		return nil, 0, errors.Wrap(io.ErrUnexpectedEOF, "failed to read frame header")
+	}
+
+	// the length is not trusted before the header is verified, so garbage doesn't make huge allocations
+	if err := verifyHeader(header[:hs]); err != nil {
+		return nil, 0, err
+	}
+
+	payload = make([]byte, PayloadSize(header[:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to read frame payload")
+	}
+
+	if err := verify(header[:], payload); err != nil {
+		return nil, 0, err
+	}
+
+	return payload, hs + len(payload), nil
+}
@@ -0,0 +1,148 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestEncodeDecodeFrame(t *testing.T) {
+	var data []byte
+	for i := 0; i < 10; i++ {
+		f, err := EncodeFrame(Record{Idx: uint64(i), Key: "key" + strconv.Itoa(i), Value: []byte("value" + strconv.Itoa(i))})
+		require.NoError(t, err)
+		data = append(data, f...)
+	}
+
+	t.Run("DecodeFrame", func(t *testing.T) {
+		rest := data
+		for i := 0; i < 10; i++ {
+			r, n, err := DecodeFrame(rest)
+			require.NoError(t, err)
+			require.Equal(t, uint64(i), r.Idx)
+			require.Equal(t, "key"+strconv.Itoa(i), r.Key)
+			require.Equal(t, "value"+strconv.Itoa(i), string(r.Value))
+			rest = rest[n:]
+		}
+		require.Empty(t, rest)
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		r := bytes.NewReader(data)
+		for i := 0; i < 10; i++ {
+			_, _, err := Read(r)
+			require.NoError(t, err)
+		}
+		_, _, err := Read(r)
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[MaxHeaderSize] ^= 0xff
+
+		_, _, err := DecodeFrame(corrupted)
+		require.ErrorIs(t, err, ErrCorrupted)
+
+		_, _, err = Read(bytes.NewReader(corrupted))
+		require.ErrorIs(t, err, ErrCorrupted)
+	})
+
+	t.Run("unchecked", func(t *testing.T) {
+		f := EncodeUnchecked([]byte("payload"))
+		f[MaxHeaderSize] ^= 0xff
+
+		payload, n, err := Decode(f)
+		require.NoError(t, err)
+		require.Len(t, f, n)
+		require.Len(t, payload, len("payload"))
+
+		_, _, err = Read(bytes.NewReader(f))
+		require.NoError(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, _, err := DecodeFrame(data[:HeaderSize+1])
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestChecksums(t *testing.T) {
+	payload := []byte("payload")
+	for _, checksum := range []Checksum{CRC32IEEE, CRC32Castagnoli, XXHash64} {
+		t.Run(checksum.String(), func(t *testing.T) {
+			f := EncodeWith(payload, checksum)
+
+			decoded, n, err := Decode(f)
+			require.NoError(t, err)
+			require.Equal(t, payload, decoded)
+			require.Equal(t, len(f), n)
+
+			f[len(f)-1] ^= 0xff
+			_, _, err = Decode(f)
+			require.ErrorIs(t, err, ErrCorrupted)
+		})
+	}
+
+	// frames of older versions have no algorithm bits and are verified with crc32 (IEEE)
+	require.Equal(t, Encode(payload), EncodeWith(payload, CRC32IEEE))
+	require.NotEqual(t, EncodeWith(payload, CRC32IEEE)[4:8], EncodeWith(payload, CRC32Castagnoli)[4:8])
+
+	f := EncodeWith(payload, XXHash64)
+	f[3] |= 0x60
+	_, _, err := Decode(f)
+	require.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestResync(t *testing.T) {
+	var data []byte
+	offsets := make([]int, 5)
+	for i := range offsets {
+		offsets[i] = len(data)
+		f, err := EncodeFrame(Record{Idx: uint64(i), Key: "key" + strconv.Itoa(i), Value: []byte("value" + strconv.Itoa(i))})
+		require.NoError(t, err)
+		data = append(data, f...)
+	}
+
+	// length of the corrupted frame can't be trusted, the next frame is found by its header checksum
+	corrupted := bytes.Clone(data)
+	corrupted[offsets[1]+1] ^= 0xff
+	_, _, err := Decode(corrupted[offsets[1]:])
+	require.ErrorIs(t, err, ErrCorrupted)
+
+	off, ok := Resync(corrupted[offsets[1]:])
+	require.True(t, ok)
+	require.Equal(t, offsets[2]-offsets[1], off)
+
+	r, _, err := DecodeFrame(corrupted[offsets[1]+off:])
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), r.Idx)
+
+	// garbage in the middle of the frame
+	corrupted = bytes.Clone(data)
+	copy(corrupted[offsets[3]+MaxHeaderSize:], "garbage")
+	off, ok = Resync(corrupted[offsets[3]:])
+	require.True(t, ok)
+	require.Equal(t, offsets[4]-offsets[3], off)
+
+	_, ok = Resync(corrupted[offsets[4]:])
+	require.False(t, ok)
+
+	// frames without header checksum are not found
+	legacy := make([]byte, HeaderSize+len("payload"))
+	binary.LittleEndian.PutUint32(legacy[0:4], uint32(len("payload")))
+	binary.LittleEndian.PutUint32(legacy[4:8], crc32.ChecksumIEEE([]byte("payload")))
+	copy(legacy[HeaderSize:], "payload")
+	payload, n, err := Decode(legacy)
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(payload))
+	require.Equal(t, len(legacy), n)
+	require.Equal(t, len(legacy), Size(legacy))
+
+	_, ok = Resync(append([]byte("garbage"), legacy...))
+	require.False(t, ok)
+}
@@ -0,0 +1,211 @@
+package gowal
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// segmentMagic is written at the very beginning of every segment file.
+	segmentMagic = "GWAL"
+
+	// segmentFormatVersion is the version of the segment format written by this package.
+	segmentFormatVersion = 2
+
+	// segmentHeaderSize is the size of the fields of the header written by all format versions.
+	segmentHeaderSize = 24
+
+	// segmentHeaderV2Size is the size of the fields of the header added in the format version 2, except the prefix.
+	segmentHeaderV2Size = 18
+
+	// segmentFormatLegacy is the format version of segments written before segments had headers:
+	// msgpack-encoded msgs one after another, without frames. Such segments are named by the prefix
+	// followed by the segment number without zero padding, see migrateLegacySegments.
+	segmentFormatLegacy = 0
+
+	// legacyMsgMarker is the first byte of segments of the legacy format, msgs of them are msgpack maps
+	// of 3 fields (Idx, Key and Value).
+	legacyMsgMarker = 0x83
+)
+
+var (
+	ErrBadSegmentHeader = errors.New("bad segment header")
+
+	// ErrForeignSegment is returned by NewWAL for segments written by another WAL, e.g. copied from
+	// another node by mistake. Use AdoptSegments if they were put into the directory on purpose.
+	ErrForeignSegment = errors.New("segment belongs to another WAL")
+
+	// ErrLegacySegment is returned for segments of the legacy format without header (see segmentFormatLegacy)
+	// by functions that can't read them. NewWAL migrates such segments to the current format.
+	ErrLegacySegment = errors.New("segment has legacy format")
+)
+
+// segmentHeader is written at the start of every segment file.
+//
+// Header layout (little endian):
+//
+//	+-------+---------+-------------+--------+------------+----------------+---------------+--------+
+//	| magic | version | header size | WAL ID | created at | segment number | prefix length | prefix |
+//	| 4     | 2       | 2           | 16     | 8          | 8              | 2             | length |
+//	+-------+---------+-------------+--------+------------+----------------+---------------+--------+
+//
+// Fields after WAL ID are written since the format version 2, created at is in unix nanoseconds.
+// Readers skip `header size` bytes to get to the first frame, so new fields can be appended
+// to the header without breaking older readers.
+type segmentHeader struct {
+	version uint16
+	size    uint16
+	id      [16]byte
+
+	// set since the format version 2
+	created time.Time
+	number  int
+	prefix  string
+}
+
+func (h segmentHeader) encode() []byte {
+	size := segmentHeaderSize + segmentHeaderV2Size + len(h.prefix)
+
+	buf := make([]byte, segmentHeaderSize, size)
+	copy(buf[0:4], segmentMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], segmentFormatVersion)
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(size))
+	copy(buf[8:24], h.id[:])
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.created.UnixNano()))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.number))
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(h.prefix)))
+	buf = append(buf, h.prefix...)
+
+	return buf
+}
+
+// readSegmentHeader reads and validates header of the segment. Segments of the legacy format have no header,
+// for them header of format version 0 is returned along with ErrLegacySegment.
+func readSegmentHeader(r io.Reader) (segmentHeader, error) {
+	buf := make([]byte, segmentHeaderSize)
+	if _, err := io.ReadFull(r, buf[:len(segmentMagic)]); err != nil {
+		return segmentHeader{}, errors.Wrap(ErrBadSegmentHeader, err.Error())
+	}
+
+	if string(buf[0:4]) != segmentMagic {
+		if buf[0] == legacyMsgMarker {
+			return segmentHeader{version: segmentFormatLegacy}, ErrLegacySegment
+		}
+		return segmentHeader{}, errors.Wrap(ErrBadSegmentHeader, "unknown magic bytes")
+	}
+
+	if _, err := io.ReadFull(r, buf[len(segmentMagic):]); err != nil {
+		return segmentHeader{}, errors.Wrap(ErrBadSegmentHeader, err.Error())
+	}
+
+	h := segmentHeader{
+		version: binary.LittleEndian.Uint16(buf[4:6]),
+		size:    binary.LittleEndian.Uint16(buf[6:8]),
+	}
+	copy(h.id[:], buf[8:24])
+
+	if h.size < segmentHeaderSize {
+		return segmentHeader{}, errors.Wrapf(ErrBadSegmentHeader, "header size %d is too small", h.size)
+	}
+
+	rest := make([]byte, h.size-segmentHeaderSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return segmentHeader{}, errors.Wrap(ErrBadSegmentHeader, err.Error())
+	}
+
+	// fields added by the newer format versions are skipped
+	if h.version >= 2 {
+		if len(rest) < segmentHeaderV2Size {
+			return segmentHeader{}, errors.Wrapf(ErrBadSegmentHeader, "header size %d is too small for format version %d", h.size, h.version)
+		}
+
+		h.created = time.Unix(0, int64(binary.LittleEndian.Uint64(rest[0:8])))
+		h.number = int(binary.LittleEndian.Uint64(rest[8:16]))
+		prefix, n := rest[segmentHeaderV2Size:], int(binary.LittleEndian.Uint16(rest[16:18]))
+		if n > len(prefix) {
+			return segmentHeader{}, errors.Wrap(ErrBadSegmentHeader, "prefix doesn't fit into the header")
+		}
+		h.prefix = string(prefix[:n])
+	}
+
+	return h, nil
+}
+
+// readSegmentHeaderFromFile reads header of the segment file with the given path.
+func readSegmentHeaderFromFile(segmentPath string) (segmentHeader, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return segmentHeader{}, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return segmentHeader{}, err
+	}
+
+	return readSegmentHeader(io.NewSectionReader(content, 0, content.Size()))
+}
+
+// isLegacySegment reports whether the segment file has the legacy format, see segmentFormatLegacy.
+func isLegacySegment(segmentPath string) bool {
+	_, err := readSegmentHeaderFromFile(segmentPath)
+
+	return errors.Is(err, ErrLegacySegment)
+}
+
+// checkSegmentHeader checks that the header was written for the segment file with the given path,
+// so segment files renamed or copied under another name are not loaded. Headers of format version 1
+// don't hold the name of the segment and are not checked.
+func checkSegmentHeader(h segmentHeader, segmentPath string) error {
+	if h.version < 2 {
+		return nil
+	}
+
+	prefix, number, err := splitSegmentName(filepath.Base(segmentPath))
+	if err != nil {
+		return err
+	}
+
+	if h.prefix != prefix || h.number != number {
+		return errors.Wrapf(ErrBadSegmentHeader, "segment %s has header of segment %s", segmentPath, segmentName(h.prefix, h.number))
+	}
+
+	return nil
+}
+
+// splitSegmentName returns prefix and number of the segment with the given file name.
+func splitSegmentName(name string) (string, int, error) {
+	prefix := strings.TrimRight(name, "0123456789")
+	number, ok := parseSegmentName(name, prefix)
+	if !ok {
+		return "", 0, errors.Errorf("%s is not a segment name", name)
+	}
+
+	return prefix, number, nil
+}
+
+// writeSegmentHeader writes header of the segment with the given path into the empty segment
+// and updates segment checksum.
+func writeSegmentHeader(fd, chk *os.File, id [16]byte) (int64, error) {
+	prefix, number, err := splitSegmentName(filepath.Base(fd.Name()))
+	if err != nil {
+		return 0, err
+	}
+
+	header := segmentHeader{id: id, created: time.Now(), number: number, prefix: prefix}.encode()
+	if _, err := fd.Write(header); err != nil {
+		return 0, errors.Wrap(err, "failed to write segment header")
+	}
+
+	if err := writeChecksum(fd, chk); err != nil {
+		return 0, errors.Wrap(err, "failed to write checksum")
+	}
+
+	return int64(len(header)), nil
+}
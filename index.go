@@ -0,0 +1,297 @@
+package gowal
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+)
+
+// Index stores msgs of the WAL by their indexes. The default index is a map with an ordered list of indexes,
+// set Config.NewIndex to use another structure (e.g. a B-tree or an on-disk index) for very large logs.
+// The WAL serializes access to the index, so implementations don't have to be safe for concurrent use.
+// Msgs must be stored and returned as is.
+type Index interface {
+	// Put stores msg, replacing the msg with the same index.
+	Put(m Msg)
+
+	// Get returns msg with the given index.
+	Get(idx uint64) (Msg, bool)
+
+	// Delete removes msg with the given index.
+	Delete(idx uint64)
+
+	// Range calls fn for every msg with index greater than or equal to from in ascending order of indexes,
+	// until fn returns false. The index is not modified while Range is running.
+	Range(from uint64, fn func(Msg) bool)
+
+	// Len returns the number of msgs in the index.
+	Len() int
+}
+
+// ReverseIndex is implemented by indexes that can iterate msgs in descending order of indexes,
+// otherwise moving Cursor backwards and finding the last index take a full scan of the index.
+type ReverseIndex interface {
+	Index
+
+	// RangeReverse calls fn for every msg with index less than or equal to from in descending order of indexes,
+	// until fn returns false. The index is not modified while RangeReverse is running.
+	RangeReverse(from uint64, fn func(Msg) bool)
+}
+
+// mapIndex is the default Index: msgs are kept in a map along with ascending list of their indexes.
+type mapIndex struct {
+	msgs  map[uint64]Msg
+	order []uint64
+}
+
+func newMapIndex() Index {
+	return &mapIndex{msgs: make(map[uint64]Msg)}
+}
+
+func (mi *mapIndex) Put(m Msg) {
+	if _, exists := mi.msgs[m.Idx]; !exists {
+		// indexes are usually written in ascending order, so it's almost always an append
+		if n := len(mi.order); n == 0 || mi.order[n-1] < m.Idx {
+			mi.order = append(mi.order, m.Idx)
+		} else {
+			i, _ := slices.BinarySearch(mi.order, m.Idx)
+			mi.order = slices.Insert(mi.order, i, m.Idx)
+		}
+	}
+
+	mi.msgs[m.Idx] = m
+}
+
+func (mi *mapIndex) Get(idx uint64) (Msg, bool) {
+	m, ok := mi.msgs[idx]
+	return m, ok
+}
+
+func (mi *mapIndex) Delete(idx uint64) {
+	if _, exists := mi.msgs[idx]; !exists {
+		return
+	}
+
+	delete(mi.msgs, idx)
+	i, _ := slices.BinarySearch(mi.order, idx)
+	mi.order = slices.Delete(mi.order, i, i+1)
+}
+
+func (mi *mapIndex) Range(from uint64, fn func(Msg) bool) {
+	i, _ := slices.BinarySearch(mi.order, from)
+	for _, idx := range mi.order[i:] {
+		if !fn(mi.msgs[idx]) {
+			return
+		}
+	}
+}
+
+func (mi *mapIndex) RangeReverse(from uint64, fn func(Msg) bool) {
+	i, found := slices.BinarySearch(mi.order, from)
+	if found {
+		i++
+	}
+
+	for j := i - 1; j >= 0; j-- {
+		if !fn(mi.msgs[mi.order[j]]) {
+			return
+		}
+	}
+}
+
+func (mi *mapIndex) Len() int {
+	return len(mi.msgs)
+}
+
+// buildIndex returns a new index with the given msgs.
+func (c *Wal) buildIndex(msgs map[uint64]Msg) Index {
+	sorted := slices.SortedFunc(maps.Values(msgs), func(a, b Msg) int { return cmp.Compare(a.Idx, b.Idx) })
+
+	index := c.newIndex()
+	for _, m := range sorted {
+		index.Put(m)
+	}
+
+	return index
+}
+
+// setIndex replaces the index with the one loaded from disk and rebuilds key index and key filters.
+// Filters loaded from sidecars are used as is, filters of other segments are built from keys of their msgs.
+func (c *Wal) setIndex(index map[uint64]Msg, filters map[int]*keyFilter) {
+	if c.keys != nil {
+		c.keys = make(map[string]uint64, len(index))
+		for _, m := range index {
+			if !m.onDisk {
+				c.keys[m.Key] = m.Idx
+			}
+		}
+	}
+
+	c.filters = make(map[int]*keyFilter, len(filters))
+	maps.Copy(c.filters, filters)
+	for _, m := range index {
+		if _, ok := filters[m.seg]; !ok && !m.onDisk {
+			c.addToFilter(m.seg, m.Key)
+		}
+	}
+
+	for idx, m := range index {
+		if _, ok := c.offsetOnlySegments[m.seg]; ok || c.offsetOnlyIndex {
+			index[idx] = m.position()
+		} else {
+			index[idx] = c.compact(m)
+		}
+	}
+
+	c.index = c.buildIndex(index)
+	c.metas = make(map[int]segmentMeta)
+	for _, m := range index {
+		c.addToMeta(m)
+	}
+	c.reindex()
+	c.enforceIndexBudget()
+}
+
+// addToIndex puts msg into the index.
+// Only position of the msg is kept if the index (or the index of the msg segment) is offset-only.
+func (c *Wal) addToIndex(m Msg) {
+	if old, exists := c.index.Get(m.Idx); exists {
+		c.addResident(old, -1)
+	} else {
+		c.addToMeta(m)
+	}
+
+	if c.keys != nil {
+		c.keys[m.Key] = m.Idx
+	}
+
+	if _, ok := c.offsetOnlySegments[m.seg]; ok || c.offsetOnlyIndex {
+		m = m.position()
+	} else {
+		m = c.compact(m)
+	}
+
+	c.index.Put(m)
+	c.addResident(m, 1)
+}
+
+// reindex rebuilds key index and memory accounting after the index was replaced.
+func (c *Wal) reindex() {
+	c.residentBytes = 0
+	for seg, meta := range c.metas {
+		meta.resident = 0
+		c.metas[seg] = meta
+	}
+	c.index.Range(0, func(m Msg) bool {
+		c.addResident(m, 1)
+		return true
+	})
+
+	// msgs may be kept without keys, so just forget keys of removed msgs
+	for key, idx := range c.keys {
+		if _, ok := c.index.Get(idx); !ok {
+			delete(c.keys, key)
+		}
+	}
+}
+
+// seek returns the msg with the smallest index that is greater than or equal to from.
+func (c *Wal) seek(from uint64) (found Msg, ok bool) {
+	c.index.Range(from, func(m Msg) bool {
+		found, ok = m, true
+		return false
+	})
+
+	return found, ok
+}
+
+// seekReverse returns the msg with the greatest index that is less than or equal to from.
+func (c *Wal) seekReverse(from uint64) (found Msg, ok bool) {
+	if ri, isReverse := c.index.(ReverseIndex); isReverse {
+		ri.RangeReverse(from, func(m Msg) bool {
+			found, ok = m, true
+			return false
+		})
+
+		return found, ok
+	}
+
+	c.index.Range(0, func(m Msg) bool {
+		if m.Idx > from {
+			return false
+		}
+		found, ok = m, true
+		return true
+	})
+
+	return found, ok
+}
+
+// ascend calls fn for every msg with index greater than or equal to from in ascending order of indexes,
+// until fn returns false. The lock is not held while fn is running, so fn may call other methods of Wal,
+// messages written or removed concurrently are seen or skipped accordingly.
+// It returns an error if a msg can't be read from disk.
+func (c *Wal) ascend(from uint64, fn func(Msg) bool) error {
+	for {
+		c.mu.RLock()
+		m, ok := c.seek(from)
+		var err error
+		if ok {
+			m, err = c.materialize(m)
+		}
+		c.mu.RUnlock()
+
+		if err != nil {
+			return err
+		}
+
+		if !ok || !fn(m) || m.Idx == math.MaxUint64 {
+			return nil
+		}
+
+		from = m.Idx + 1
+	}
+}
+
+// FirstIndex returns the smallest index in the log, false if the log is empty.
+func (c *Wal) FirstIndex() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, ok := c.seek(0)
+
+	return m.Idx, ok
+}
+
+// LastIndex returns the greatest index in the log, false if the log is empty.
+func (c *Wal) LastIndex() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, ok := c.seekReverse(math.MaxUint64)
+
+	return m.Idx, ok
+}
+
+// Gaps returns ranges of indexes missing between FirstIndex and LastIndex in ascending order,
+// so the log is contiguous if there are none. It takes a full scan of the index.
+func (c *Wal) Gaps() []IndexRange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var (
+		gaps []IndexRange
+		prev uint64
+		seen bool
+	)
+	c.index.Range(0, func(m Msg) bool {
+		if seen && m.Idx > prev+1 {
+			gaps = append(gaps, IndexRange{From: prev + 1, To: m.Idx - 1})
+		}
+		prev, seen = m.Idx, true
+		return true
+	})
+
+	return gaps
+}
@@ -0,0 +1,54 @@
+package gowal
+
+import (
+	"unique"
+)
+
+// valueArena allocates values of msgs of a segment in large chunks, so the index holds a few big allocations
+// instead of one allocation per value. Chunks are freed by GC when the segment is removed or switched
+// to offset-only index and no returned msgs refer to them.
+type valueArena struct {
+	chunkSize int
+	chunk     []byte
+}
+
+// alloc returns a copy of the value allocated in the arena.
+// Values larger than a quarter of the chunk are allocated separately, so chunks are not wasted.
+func (a *valueArena) alloc(value []byte) []byte {
+	if len(value) > a.chunkSize/4 {
+		return append([]byte(nil), value...)
+	}
+
+	if cap(a.chunk)-len(a.chunk) < len(value) {
+		a.chunk = make([]byte, 0, a.chunkSize)
+	}
+
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, value...)
+
+	// capacity is limited, so appending to the value doesn't overwrite values allocated after it
+	return a.chunk[start:len(a.chunk):len(a.chunk)]
+}
+
+// compact returns msg with interned key and value allocated in the arena of its segment,
+// according to Config.InternKeys and Config.ValueArenaChunkSize. The caller must hold the lock.
+func (c *Wal) compact(m Msg) Msg {
+	if m.onDisk {
+		return m
+	}
+
+	if c.internKeys {
+		m.Key = unique.Make(m.Key).Value()
+	}
+
+	if c.arenaChunkSize > 0 && len(m.Value) > 0 {
+		a, ok := c.arenas[m.seg]
+		if !ok {
+			a = &valueArena{chunkSize: c.arenaChunkSize}
+			c.arenas[m.seg] = a
+		}
+		m.Value = a.alloc(m.Value)
+	}
+
+	return m
+}
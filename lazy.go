@@ -0,0 +1,50 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"maps"
+	"math"
+	"os"
+	"path"
+)
+
+// loadOlderSegments loads indexes of the older segments in the background and merges them with the index
+// of the newest segment. The lock must be taken before the call, it is released when the index is complete,
+// so all methods of Wal wait for the older segments to be loaded.
+func (c *Wal) loadOlderSegments(segmentsNumbers []int, index map[uint64]Msg, filters map[int]*keyFilter, useSidecars bool) {
+	defer c.mu.Unlock()
+
+	older, olderFilters, err := loadSegmentIndexes(segmentsNumbers, path.Join(c.pathToLogsDir, c.prefix), c.id, useSidecars, c.fileMode, c.duplicates, c.codec)
+	if err == nil {
+		// msgs of the newest segment win
+		c.duplicates.merge(older, index)
+		err = c.duplicates.check(c.duplicatePolicy)
+	}
+	if err != nil {
+		// keep working with the newest segment only, but don't let writes go unchecked against older segments
+		c.loadErr = errors.Wrap(err, "failed to load older segments")
+		c.setIndex(index, filters)
+	} else {
+		maps.Copy(olderFilters, filters)
+		c.setIndex(older, olderFilters)
+	}
+
+	c.initTiers()
+
+	if m, ok := c.seekReverse(math.MaxUint64); ok {
+		c.lastIndex.Store(m.Idx)
+	}
+}
+
+// loadSegmentIndexes loads indexes of the segments without keeping the segment files open.
+func loadSegmentIndexes(segmentsNumbers []int, basePath string, id [16]byte, useSidecars bool, mode os.FileMode, dups duplicates, codec Codec) (map[uint64]Msg, map[int]*keyFilter, error) {
+	fd, chk, _, index, filters, err := segmentInfoAndIndex(segmentsNumbers, basePath, id, useSidecars, mode, dups, codec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fd.Close()
+	chk.Close()
+
+	return index, filters, nil
+}
@@ -0,0 +1,196 @@
+package gowal
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"time"
+)
+
+// legacySegment is the segment file of the legacy format, see segmentFormatLegacy.
+type legacySegment struct {
+	number int
+	name   string
+}
+
+// MigrateLegacySegments rewrites segments of the WAL in dir written by early versions without segment headers
+// (msgpack-encoded msgs one after another) in the current format, encoding msgs with the given codec
+// (MsgpackCodec if nil). NewWAL does the same on open, use it to migrate the WAL ahead of the upgrade
+// or with tools that don't open the WAL. Nothing is migrated if some of the segments don't match their
+// checksum files, see UnsafeRecover. The WAL must not be open. It returns paths of migrated segments.
+func MigrateLegacySegments(dir, prefix string, codec Codec) ([]string, error) {
+	if codec == nil {
+		codec = defaultCodec
+	}
+
+	if err := checkCodec(dir, prefix, codec); err != nil {
+		return nil, err
+	}
+
+	return migrateLegacySegments(dir, prefix, codec, CRC32IEEE, defaultFileMode)
+}
+
+// findLegacySegments returns segments of the WAL in dir written in the legacy format, from the oldest to the newest.
+func findLegacySegments(dir, prefix string) ([]legacySegment, error) {
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read dir for wal")
+	}
+
+	var segments []legacySegment
+	for _, d := range de {
+		n, ok := parseSegmentName(d.Name(), prefix)
+		if !ok || d.IsDir() || !isLegacySegment(path.Join(dir, d.Name())) {
+			continue
+		}
+		segments = append(segments, legacySegment{number: n, name: d.Name()})
+	}
+	slices.SortFunc(segments, func(a, b legacySegment) int { return cmp.Compare(a.number, b.number) })
+
+	return segments, nil
+}
+
+// readLegacySegment checks the legacy segment against its checksum file and decodes its msgs,
+// it fails with ErrCorruptedFrame if the segment is corrupted.
+// Legacy format doesn't store timestamps of msgs, so msgs get the given timestamp.
+func readLegacySegment(segmentPath string, ts time.Time) ([]Msg, error) {
+	data, err := os.ReadFile(segmentPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read log segment file")
+	}
+
+	expected, err := os.ReadFile(segmentPath + checkSumPostfix)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read checksum file")
+	}
+
+	// older versions didn't check segments with empty checksum files as well
+	if sum := sha256.Sum256(data); len(expected) > 0 && !bytes.Equal(sum[:], expected) {
+		return nil, errors.Wrapf(ErrCorruptedFrame, "legacy segment %s doesn't match its checksum", segmentPath)
+	}
+
+	var msgs []Msg
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	for {
+		var m Msg
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrapf(ErrCorruptedFrame, "failed to decode msg of legacy segment %s: %v", segmentPath, err)
+		}
+		m.Timestamp = ts
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// migrateLegacySegments rewrites segments of the WAL in dir written in the legacy format (see segmentFormatLegacy)
+// in the current format: with headers holding the WAL ID, msgs encoded by the codec into frames with the given
+// checksum algorithm, and footers in all segments but the newest one. Migrated segments get zero-padded names,
+// their msgs get modification time of the segment file as timestamp.
+//
+// All legacy segments are checked against their checksum files before any of them is rewritten, so the directory
+// is left untouched if some of them are corrupted (see UnsafeRecover). Every segment is replaced atomically
+// and its legacy files are removed after that, so an interrupted migration is completed by the next call.
+// It returns paths of migrated segments.
+func migrateLegacySegments(dir, prefix string, codec Codec, checksum Checksum, mode os.FileMode) ([]string, error) {
+	legacy, err := findLegacySegments(dir, prefix)
+	if err != nil || len(legacy) == 0 {
+		return nil, err
+	}
+
+	segmentsNumbers, err := listSegments(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	newest := segmentsNumbers[len(segmentsNumbers)-1]
+
+	modTimes := make([]time.Time, len(legacy))
+	msgs := make([][]Msg, len(legacy))
+	for i, seg := range legacy {
+		stat, err := os.Stat(path.Join(dir, seg.name))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to stat segment")
+		}
+		modTimes[i] = stat.ModTime()
+
+		if msgs[i], err = readLegacySegment(path.Join(dir, seg.name), modTimes[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	mf, err := loadOrCreateManifest(dir, prefix, segmentsNumbers, codecName(codec), mode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load manifest")
+	}
+
+	id, err := parseID(mf.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse WAL ID")
+	}
+
+	var migrated []string
+	for i, seg := range legacy {
+		legacyPath, segmentPath := path.Join(dir, seg.name), path.Join(dir, segmentName(prefix, seg.number))
+
+		// segments rewritten by the interrupted migration are not rewritten again, only their legacy files are removed
+		_, err := os.Stat(segmentPath)
+		if legacyPath == segmentPath || os.IsNotExist(err) {
+			header := segmentHeader{id: id, created: modTimes[i], number: seg.number, prefix: prefix}
+			data, err := encodeLegacySegment(msgs[i], header, codec, checksum, seg.number != newest)
+			if err != nil {
+				return migrated, errors.Wrapf(err, "failed to migrate segment %s", legacyPath)
+			}
+
+			if err := replaceSegmentData(segmentPath, data, mode); err != nil {
+				return migrated, errors.Wrapf(err, "failed to migrate segment %s", legacyPath)
+			}
+
+			if err := os.Chtimes(segmentPath, time.Time{}, modTimes[i]); err != nil {
+				return migrated, errors.Wrap(err, "failed to restore modification time of segment")
+			}
+		}
+
+		if legacyPath != segmentPath {
+			for _, name := range []string{legacyPath + checkSumPostfix, legacyPath} {
+				if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+					return migrated, errors.Wrapf(err, "failed to remove legacy file %s", name)
+				}
+			}
+		}
+
+		migrated = append(migrated, segmentPath)
+	}
+
+	return migrated, nil
+}
+
+// encodeLegacySegment encodes msgs of the legacy segment into the content of the segment of the current format
+// with the given header, the footer is appended if sealed is set.
+func encodeLegacySegment(msgs []Msg, header segmentHeader, codec Codec, checksum Checksum, sealed bool) ([]byte, error) {
+	data := header.encode()
+
+	var meta segmentMeta
+	for _, m := range msgs {
+		f, err := encodeFrame(m, codec, checksum, false)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, f...)
+		meta.add(m)
+	}
+
+	if sealed {
+		data = append(data, newFooter(data, meta).encode()...)
+	}
+
+	return data, nil
+}
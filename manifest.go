@@ -0,0 +1,137 @@
+package gowal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// manifestPostfix is appended to the segment prefix to get the name of the manifest file.
+const manifestPostfix = "manifest"
+
+// manifest holds metadata of the WAL that must survive restarts.
+type manifest struct {
+	// ID is unique identifier of the WAL instance generated on the first init.
+	ID string `json:"id"`
+
+	// CreatedAt is the time the WAL was initialized for the first time.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Codec is the name of the codec msgs are encoded with (see codecName), empty for WALs
+	// initialized before the codec became configurable, their msgs are encoded with msgpack.
+	Codec string `json:"codec,omitempty"`
+}
+
+// readManifest reads manifest of the WAL, the error satisfies os.IsNotExist if there is no manifest.
+func readManifest(dir, prefix string) (manifest, error) {
+	manifestPath := path.Join(dir, prefix+manifestPostfix)
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return manifest{}, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, errors.Wrapf(err, "failed to decode manifest %s", manifestPath)
+	}
+
+	return m, nil
+}
+
+// loadOrCreateManifest reads manifest of the WAL, creating it with the given codec name if the WAL is initialized
+// for the first time. If the manifest is missing but segments exist, WAL ID is taken from the segment headers.
+func loadOrCreateManifest(dir, prefix string, segmentNumbers []int, codec string, mode os.FileMode) (manifest, error) {
+	manifestPath := path.Join(dir, prefix+manifestPostfix)
+
+	m, err := readManifest(dir, prefix)
+	if err == nil {
+		return m, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return manifest{}, errors.Wrap(err, "failed to read manifest")
+	}
+
+	id, err := newID()
+	if err != nil {
+		return manifest{}, err
+	}
+
+	for _, n := range segmentNumbers {
+		if h, err := readSegmentHeaderFromFile(path.Join(dir, segmentName(prefix, n))); err == nil {
+			id = h.id
+			break
+		}
+	}
+
+	m = manifest{ID: formatID(id), CreatedAt: time.Now().UTC(), Codec: codec}
+	if err := writeManifest(manifestPath, m, mode); err != nil {
+		return manifest{}, err
+	}
+
+	return m, nil
+}
+
+// writeManifest atomically replaces manifest file with the given one.
+func writeManifest(manifestPath string, m manifest, mode os.FileMode) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+
+	return errors.Wrap(writeFileAtomic(manifestPath, data, mode), "failed to write manifest")
+}
+
+// writeFileAtomic replaces the file with the given content by writing (with the given mode) and syncing
+// a temporary file and renaming it then.
+func writeFileAtomic(name string, data []byte, mode os.FileMode) error {
+	tmp := name + ".tmp"
+	if err := writeFileSync(tmp, data, mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// newID generates UUID v7: 48 bits of unix time in milliseconds followed by random bits,
+// so IDs of WALs created later are always greater than IDs of the older ones.
+func newID() ([16]byte, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		return uuid, errors.Wrap(err, "failed to generate WAL ID")
+	}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(uuid[:6], ts[2:])
+
+	uuid[6] = uuid[6]&0x0f | 0x70 // version 7
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return uuid, nil
+}
+
+// formatID formats WAL ID in the canonical UUID form.
+func formatID(uuid [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// parseID parses WAL ID from the canonical UUID form.
+func parseID(s string) ([16]byte, error) {
+	var uuid [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != len(uuid) {
+		return uuid, errors.Errorf("malformed WAL ID %q", s)
+	}
+	copy(uuid[:], b)
+
+	return uuid, nil
+}
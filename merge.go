@@ -0,0 +1,140 @@
+package gowal
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// MergeSegments coalesces runs of adjacent sealed segments into segments no larger than maxOutputBytes,
+// reducing the number of open files and the startup scan overhead of logs with many small segments
+// (e.g. produced by a low SegmentThreshold or frequent Rotate calls). Every run is merged into its first
+// segment and the rest of the run is removed. Msgs that are not in the index anymore are dropped.
+func (c *Wal) MergeSegments(maxOutputBytes int64) error {
+	if maxOutputBytes <= 0 {
+		return errors.New("maxOutputBytes must be positive")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	return c.mergeSegments(maxOutputBytes)
+}
+
+// mergeSegments merges runs of adjacent sealed segments into segments no larger than maxOutputBytes.
+// The caller must hold the lock.
+func (c *Wal) mergeSegments(maxOutputBytes int64) error {
+	var (
+		run     []int
+		runSize int64
+	)
+	flush := func() error {
+		defer func() { run, runSize = nil, 0 }()
+		if len(run) < 2 {
+			return nil
+		}
+
+		return errors.Wrapf(c.mergeRun(run), "failed to merge segments %d-%d", run[0], run[len(run)-1])
+	}
+
+	for _, seg := range c.sealedSegments() {
+		stat, err := os.Stat(c.segmentPath(seg))
+		if err != nil {
+			return errors.Wrap(err, "failed to stat segment")
+		}
+
+		// headers of merged segments are dropped
+		size := stat.Size()
+		if len(run) > 0 {
+			size -= segmentHeaderSize
+		}
+
+		if len(run) > 0 && runSize+size > maxOutputBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+			size = stat.Size()
+		}
+
+		run = append(run, seg)
+		runSize += size
+	}
+
+	return flush()
+}
+
+// mergeRun merges frames of the indexed msgs of the segments into the first segment and removes the rest.
+// The caller must hold the lock.
+func (c *Wal) mergeRun(run []int) error {
+	first := run[0]
+
+	var (
+		out   []byte
+		moved []Msg
+		keys  []string
+	)
+	for _, seg := range run {
+		data, headerSize, frames, err := c.segmentFrames(seg)
+		if err != nil {
+			return err
+		}
+
+		if out == nil {
+			out = bytes.Clone(data[:headerSize])
+		}
+
+		for _, f := range frames {
+			cur, ok := c.index.Get(f.Idx)
+			if !ok || cur.seg != seg || cur.off != f.off {
+				continue
+			}
+
+			cur.seg, cur.off = first, int64(len(out))
+			moved = append(moved, cur)
+			keys = append(keys, f.Key)
+			out = append(out, data[f.off:f.off+int64(f.size)]...)
+		}
+	}
+
+	meta := segmentMeta{}
+	for _, m := range moved {
+		meta.add(m)
+	}
+
+	if len(moved) > 0 {
+		out = append(out, newFooter(out, meta).encode()...)
+		if err := c.replaceSegmentFile(first, out); err != nil {
+			return err
+		}
+	}
+
+	// msgs are moved to the first segment before the rest is removed, so they are not dropped from the index
+	filter := newKeyFilter(len(keys))
+	for i, m := range moved {
+		c.index.Put(m)
+		filter.add(keys[i])
+	}
+
+	for _, seg := range run[1:] {
+		if err := c.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+
+	if len(moved) == 0 {
+		return c.removeSegment(first)
+	}
+
+	c.filters[first] = filter
+	c.metas[first] = meta
+	if c.cache != nil {
+		c.cache.evictSegment(first)
+	}
+	c.reindex()
+
+	return c.writeSidecar(first)
+}
@@ -0,0 +1,64 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestMergeSegments(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 5)
+
+	// pairs of segments fit into the limit
+	require.NoError(t, log.MergeSegments(2*segments[0].Size-segmentHeaderSize))
+	segments, err = log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	require.Equal(t, []int{0, 2, 4}, []int{segments[0].Number, segments[1].Number, segments[2].Number})
+	require.Equal(t, []int{4, 4, 2}, []int{segments[0].Records, segments[1].Records, segments[2].Records})
+
+	require.NoError(t, log.MergeSegments(1<<20))
+	segments, err = log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, 8, segments[0].Records)
+
+	for i := 0; i < 10; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	m, err := log.GetByKey("key3")
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), m.Idx)
+	require.NoError(t, log.Close())
+
+	report, err := RebuildIndex(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Empty(t, report)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 10, log.Len())
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform, segments are read with ReadAt.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
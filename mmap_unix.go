@@ -0,0 +1,17 @@
+//go:build unix
+
+package gowal
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of the file into memory for reading.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
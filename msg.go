@@ -1,11 +1,55 @@
 package gowal
 
-type msg struct {
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+)
+
+// Msg is a record of the log.
+type Msg struct {
 	Idx   uint64
 	Key   string
 	Value []byte
+
+	// Timestamp is the wall-clock time the msg was written at.
+	Timestamp time.Time
+
+	// position of the msg frame on disk, not serialized
+	seg  int
+	off  int64
+	size int
+
+	// checksum of the in-memory copy of the msg, not serialized
+	sum uint32
+
+	// unchecked is set if checksum of the in-memory copy was not computed on write
+	unchecked bool
+
+	// onDisk is set if only position of the msg is kept in memory, key and value must be read from disk
+	onDisk bool
 }
 
-func (m msg) Index() uint64 {
+func (m Msg) Index() uint64 {
 	return m.Idx
 }
+
+// position returns msg stripped down to its position on disk.
+func (m Msg) position() Msg {
+	return Msg{Idx: m.Idx, seg: m.seg, off: m.off, size: m.size, onDisk: true}
+}
+
+// checksum calculates checksum of msg fields, used to detect corruption of msgs kept in memory.
+func (m Msg) checksum() uint32 {
+	var idx [8]byte
+	binary.LittleEndian.PutUint64(idx[:], m.Idx)
+
+	var ts [8]byte
+	binary.LittleEndian.PutUint64(ts[:], uint64(m.Timestamp.UnixNano()))
+
+	sum := crc32.Update(0, crc32.IEEETable, idx[:])
+	sum = crc32.Update(sum, crc32.IEEETable, ts[:])
+	sum = crc32.Update(sum, crc32.IEEETable, []byte(m.Key))
+
+	return crc32.Update(sum, crc32.IEEETable, m.Value)
+}
@@ -0,0 +1,64 @@
+package gowal
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"syscall"
+	"time"
+)
+
+// defaultOpenRetryMaxBackoff is used when Config.OpenRetryMaxBackoff is not set.
+const defaultOpenRetryMaxBackoff = 5 * time.Second
+
+// transientErrnos are errors of I/O operations that may go away on retry.
+var transientErrnos = []syscall.Errno{syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE, syscall.EIO}
+
+// openWAL opens the WAL in NewWALWithContext, tests replace it to simulate failures.
+var openWAL = NewWAL
+
+// NewWALWithContext creates a new WAL with the given configuration like NewWAL does,
+// but retries with exponential backoff if the WAL can't be opened due to transient I/O errors
+// (file locked by a dying process, NFS hiccup, etc.). Retries are enabled by Config.OpenRetryBackoff
+// and stop when ctx is done.
+//
+// Only errors like EAGAIN, EBUSY, EINTR, ETIMEDOUT, ESTALE and EIO are retried, others (missing permissions,
+// corrupted or foreign segments, etc.) can't be fixed by retrying and are returned immediately.
+func NewWALWithContext(ctx context.Context, config Config) (*Wal, error) {
+	backoff := config.OpenRetryBackoff
+	maxBackoff := config.OpenRetryMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultOpenRetryMaxBackoff
+	}
+
+	for {
+		w, err := openWAL(config)
+		if err == nil {
+			return w, nil
+		}
+
+		if backoff <= 0 || !isTransient(err) {
+			return nil, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Wrapf(err, "gave up retrying to open WAL: %v", ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// isTransient reports whether err is caused by an I/O failure that may go away on retry.
+func isTransient(err error) bool {
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
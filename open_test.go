@@ -0,0 +1,77 @@
+package gowal
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewWALWithContext_Retry(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		OpenRetryBackoff: 5 * time.Millisecond,
+	}
+
+	// open fails with the given error until the failure is cleared or the deadline is passed
+	var failure error
+	var until time.Time
+	var attempts int
+	openWAL = func(config Config) (*Wal, error) {
+		attempts++
+		if failure != nil && (until.IsZero() || time.Now().Before(until)) {
+			return nil, errors.Wrap(failure, "failed to open log segment file")
+		}
+		return NewWAL(config)
+	}
+	defer func() { openWAL = NewWAL }()
+
+	t.Run("gives up when context is done", func(t *testing.T) {
+		failure = &fs.PathError{Op: "open", Path: "./testlogdata", Err: syscall.EBUSY}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := NewWALWithContext(ctx, cfg)
+		require.ErrorIs(t, err, syscall.EBUSY)
+	})
+
+	t.Run("opens as soon as the problem is gone", func(t *testing.T) {
+		failure = &fs.PathError{Op: "open", Path: "./testlogdata", Err: syscall.EIO}
+		until = time.Now().Add(30 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		log, err := NewWALWithContext(ctx, cfg)
+		require.NoError(t, err)
+		require.NoError(t, log.Close())
+	})
+
+	t.Run("permission error fails immediately", func(t *testing.T) {
+		failure, until, attempts = &fs.PathError{Op: "open", Path: "./testlogdata", Err: syscall.EACCES}, time.Time{}, 0
+
+		_, err := NewWALWithContext(context.Background(), cfg)
+		require.ErrorIs(t, err, fs.ErrPermission)
+		require.Equal(t, 1, attempts)
+	})
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+
+	// a file in place of the log directory can't be fixed by retrying
+	failure, attempts = nil, 0
+	require.NoError(t, os.WriteFile("./testlogdata", []byte("not a dir"), 0644))
+
+	_, err := NewWALWithContext(context.Background(), cfg)
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
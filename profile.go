@@ -0,0 +1,82 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// Profile is a preset of configuration options with documented guarantees, see NewWALWithProfile.
+// All profiles write msgs with checksums and keep them in memory.
+type Profile int
+
+const (
+	// ProfileDurable syncs every write to disk before Write returns, so acknowledged msgs survive
+	// a crash of the process or of the machine. Writes are limited by the fsync latency of the disk.
+	ProfileDurable Profile = iota
+
+	// ProfileBalanced syncs the log to disk as soon as 1 MiB was written since the last sync,
+	// so a crash of the machine loses at most the last 1 MiB of acknowledged msgs.
+	// Msgs always survive a crash of the process.
+	ProfileBalanced
+
+	// ProfileThroughput never syncs the log explicitly and leaves it to the OS, so a crash of the machine
+	// may lose any msgs not yet flushed by the OS. Msgs always survive a crash of the process.
+	ProfileThroughput
+)
+
+const (
+	// profilePrefix is the prefix of segment files of WALs created from profiles.
+	profilePrefix = "segment_"
+
+	// balancedMaxUnflushedBytes is MaxUnflushedBytes of ProfileBalanced.
+	balancedMaxUnflushedBytes = 1 << 20
+)
+
+func (p Profile) String() string {
+	switch p {
+	case ProfileDurable:
+		return "durable"
+	case ProfileBalanced:
+		return "balanced"
+	case ProfileThroughput:
+		return "throughput"
+	default:
+		return fmt.Sprintf("Profile(%d)", int(p))
+	}
+}
+
+// Config returns configuration of the profile for the WAL stored in dir,
+// use it to start from the profile and adjust some options.
+func (p Profile) Config(dir string) (Config, error) {
+	cfg := Config{
+		Dir:              dir,
+		Prefix:           profilePrefix,
+		SegmentThreshold: 1000,
+		MaxSegments:      5,
+	}
+
+	switch p {
+	case ProfileDurable:
+		cfg.IsInSyncDiskMode = true
+	case ProfileBalanced:
+		cfg.MaxUnflushedBytes = balancedMaxUnflushedBytes
+	case ProfileThroughput:
+	default:
+		return Config{}, errors.Errorf("unknown profile %s", p)
+	}
+
+	return cfg, nil
+}
+
+// NewWALWithProfile creates a new WAL stored in dir with the configuration of the given profile.
+// Segment files are named with the "segment_" prefix.
+//
+//	wal, err := gowal.NewWALWithProfile("./wal", gowal.ProfileDurable)
+func NewWALWithProfile(dir string, p Profile) (*Wal, error) {
+	cfg, err := p.Config(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWAL(cfg)
+}
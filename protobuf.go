@@ -0,0 +1,76 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+	"time"
+)
+
+// field numbers of the Record message, see record.proto.
+const (
+	recordIdxField       protowire.Number = 1
+	recordKeyField       protowire.Number = 2
+	recordValueField     protowire.Number = 3
+	recordTimestampField protowire.Number = 4
+)
+
+// ProtobufCodec encodes msgs as protobuf Record messages defined in record.proto, so segments
+// can be parsed by non-Go tools and services with code generated from record.proto.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(m Msg) ([]byte, error) {
+	buf := make([]byte, 0, len(m.Key)+len(m.Value)+32)
+	if m.Idx != 0 {
+		buf = protowire.AppendTag(buf, recordIdxField, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, m.Idx)
+	}
+	if m.Key != "" {
+		buf = protowire.AppendTag(buf, recordKeyField, protowire.BytesType)
+		buf = protowire.AppendString(buf, m.Key)
+	}
+	if len(m.Value) > 0 {
+		buf = protowire.AppendTag(buf, recordValueField, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, m.Value)
+	}
+	if !m.Timestamp.IsZero() {
+		buf = protowire.AppendTag(buf, recordTimestampField, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(m.Timestamp.UnixNano()))
+	}
+
+	return buf, nil
+}
+
+func (ProtobufCodec) Unmarshal(payload []byte) (Msg, error) {
+	var m Msg
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return Msg{}, errors.Wrap(protowire.ParseError(n), "failed to decode record tag")
+		}
+		payload = payload[n:]
+
+		switch {
+		case num == recordIdxField && typ == protowire.VarintType:
+			m.Idx, n = protowire.ConsumeVarint(payload)
+		case num == recordKeyField && typ == protowire.BytesType:
+			m.Key, n = protowire.ConsumeString(payload)
+		case num == recordValueField && typ == protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(payload)
+			m.Value = append([]byte(nil), value...)
+		case num == recordTimestampField && typ == protowire.VarintType:
+			var ts uint64
+			ts, n = protowire.ConsumeVarint(payload)
+			m.Timestamp = time.Unix(0, int64(ts))
+		default:
+			// fields added by newer versions of record.proto are skipped
+			n = protowire.ConsumeFieldValue(num, typ, payload)
+		}
+		if n < 0 {
+			return Msg{}, errors.Wrapf(protowire.ParseError(n), "failed to decode record field %d", num)
+		}
+		payload = payload[n:]
+	}
+
+	return m, nil
+}
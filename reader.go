@@ -0,0 +1,274 @@
+package gowal
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"io"
+	"os"
+)
+
+// segmentHandle is a file of the sealed segment opened for reading, its uncompressed content and memory mapping.
+type segmentHandle struct {
+	f       *os.File
+	content segmentContent
+	mapping []byte
+
+	// number of readers using the handle, it is closed when the last one releases it
+	refs int
+
+	// set when the handle is dropped from the cache while in use
+	dropped bool
+
+	// position in the list of cached handles
+	elem *list.Element
+}
+
+func (h *segmentHandle) close() {
+	if h.mapping != nil {
+		munmap(h.mapping)
+	}
+	h.f.Close()
+}
+
+// segmentReader returns the uncompressed content of the segment to read frames from and memory mapping
+// of the file, if sealed segments are memory-mapped (nil if mapping failed or the segment is compressed,
+// the content is read with ReadAt then).
+// Files of sealed segments are opened on demand and cached, at most Config.MaxOpenSegments of them
+// are kept open, least recently used ones are closed first. The file and the mapping may be used
+// until release is called. The caller must hold the lock.
+func (c *Wal) segmentReader(seg int) (io.ReaderAt, []byte, func(), error) {
+	if seg == c.activeSegment {
+		return c.log, nil, func() {}, nil
+	}
+
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	h, ok := c.readers[seg]
+	if ok {
+		c.openReaders.MoveToFront(h.elem)
+	} else {
+		f, err := os.Open(c.segmentPath(seg))
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to open log segment file")
+		}
+
+		content, err := openSegmentContent(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+
+		h = &segmentHandle{f: f, content: content}
+		if _, raw := content.(rawSegment); raw && c.mmapSegments && content.Size() > 0 {
+			if data, err := mmapFile(f, int(content.Size())); err == nil {
+				h.mapping = data
+			}
+		}
+
+		h.elem = c.openReaders.PushFront(seg)
+		c.readers[seg] = h
+		c.dropIdleReaders()
+	}
+	h.refs++
+
+	return h.content, h.mapping, func() { c.releaseReader(h) }, nil
+}
+
+// releaseReader releases the handle returned by segmentReader.
+func (c *Wal) releaseReader(h *segmentHandle) {
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	h.refs--
+	if h.refs == 0 && h.dropped {
+		h.close()
+	}
+}
+
+// dropIdleReaders closes least recently used files of sealed segments while there are more of them
+// than Config.MaxOpenSegments. The caller must hold readersMu.
+func (c *Wal) dropIdleReaders() {
+	for c.maxOpenSegments > 0 && len(c.readers) > c.maxOpenSegments {
+		c.dropReader(c.openReaders.Back().Value.(int))
+	}
+}
+
+// dropReader removes the handle of the segment from the cache and closes it, or marks it to be closed
+// when it is released if it is in use. The caller must hold readersMu.
+func (c *Wal) dropReader(seg int) {
+	h, ok := c.readers[seg]
+	if !ok {
+		return
+	}
+
+	c.openReaders.Remove(h.elem)
+	delete(c.readers, seg)
+
+	h.dropped = true
+	if h.refs == 0 {
+		h.close()
+	}
+}
+
+// closeSegmentFile closes cached file of the segment.
+func (c *Wal) closeSegmentFile(seg int) {
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	c.dropReader(seg)
+}
+
+// closeSegmentFiles closes all cached files of sealed segments.
+func (c *Wal) closeSegmentFiles() {
+	c.readersMu.Lock()
+	defer c.readersMu.Unlock()
+
+	for seg := range c.readers {
+		c.dropReader(seg)
+	}
+}
+
+// frameView returns raw frame of the msg from its segment and reports whether it points into memory mapping
+// of the segment, such frame must not be modified or used after release is called. The caller must hold the lock.
+func (c *Wal) frameView(m Msg) ([]byte, bool, func(), error) {
+	f, mapping, release, err := c.segmentReader(m.seg)
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	if mapping != nil {
+		if m.off < 0 || m.off+int64(m.size) > int64(len(mapping)) {
+			release()
+			return nil, false, nil, errors.Errorf("frame of msg %d is out of segment %d", m.Idx, m.seg)
+		}
+		return mapping[m.off : m.off+int64(m.size)], true, release, nil
+	}
+	defer release()
+
+	data := make([]byte, m.size)
+	if _, err := f.ReadAt(data, m.off); err != nil {
+		return nil, false, nil, errors.Wrapf(err, "failed to read frame of msg %d", m.Idx)
+	}
+
+	return data, false, func() {}, nil
+}
+
+// readFrameBytes reads raw frame of the msg from its segment. The caller must hold the lock.
+func (c *Wal) readFrameBytes(m Msg) ([]byte, error) {
+	data, mapped, release, err := c.frameView(m)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if !mapped {
+		return data, nil
+	}
+
+	return bytes.Clone(data), nil
+}
+
+// readMsg reads msg from its segment, verifying frame checksum. The caller must hold the lock.
+func (c *Wal) readMsg(m Msg) (Msg, error) {
+	data, _, release, err := c.frameView(m)
+	if err != nil {
+		return Msg{}, err
+	}
+	defer release()
+
+	payload, _, err := frame.Decode(data)
+	if err != nil {
+		return Msg{}, errors.Wrapf(err, "failed to read msg %d from disk", m.Idx)
+	}
+
+	read, err := decodePayload(payload, c.codec)
+	if err != nil {
+		return Msg{}, err
+	}
+
+	if read.Idx != m.Idx {
+		return Msg{}, errors.Wrapf(ErrCorruptedFrame, "expected msg %d at offset %d, got %d", m.Idx, m.off, read.Idx)
+	}
+
+	read.seg, read.off, read.size = m.seg, m.off, m.size
+	read.sum = read.checksum()
+
+	return read, nil
+}
+
+// materialize returns msg with its key and value, reading it from disk (or the read cache) if only position
+// of the msg is kept in memory. The caller must hold the lock.
+func (c *Wal) materialize(m Msg) (Msg, error) {
+	c.access.touch(m.seg)
+	if !m.onDisk {
+		return m, nil
+	}
+	c.requestPromotion(m.seg)
+
+	if c.cache != nil && !c.verifyReads {
+		if cached, ok := c.cache.get(m); ok {
+			return cached, nil
+		}
+	}
+
+	read, err := c.readMsg(m)
+	if err != nil {
+		if errors.Is(err, ErrCorruptedFrame) {
+			c.diskCorruption(m, err)
+		}
+		return Msg{}, err
+	}
+
+	if c.cache != nil {
+		c.cache.put(read)
+	}
+
+	return read, nil
+}
+
+// Warm reads msgs with indexes in [from, to] from disk in the background, so they are in the page cache
+// before they are needed, and returns channel that receives the result once the range is warmed.
+// Only msgs kept on disk are read (see OffsetOnlyIndex, HotSegments and MaxIndexMemoryBytes), msgs kept
+// in memory are skipped. Warming stops on the first msg that can't be read or when ctx is done.
+//
+// Should be used like this:
+//
+//	last, _ := wal.LastIndex()
+//	if err := <-wal.Warm(ctx, snapshotIndex+1, last); err != nil {
+//		...
+func (c *Wal) Warm(ctx context.Context, from, to uint64) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+
+		for from <= to && ctx.Err() == nil {
+			c.mu.RLock()
+			m, ok := c.seek(from)
+			var err error
+			if ok && m.Idx <= to && m.onDisk {
+				_, err = c.materialize(m)
+			}
+			c.mu.RUnlock()
+
+			if err != nil {
+				done <- errors.Wrap(err, "failed to warm msgs")
+				return
+			}
+
+			// stop at to without overflowing if it's the greatest possible index
+			if !ok || m.Idx >= to {
+				break
+			}
+			from = m.Idx + 1
+		}
+
+		done <- ctx.Err()
+	}()
+
+	return done
+}
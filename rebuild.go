@@ -0,0 +1,179 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"slices"
+)
+
+// RebuildIndex rescans all segments of the WAL in dir, rewrites index sidecars of sealed segments
+// and returns descriptions of inconsistencies found between segments and their sidecars.
+// Use it after manual changes in the WAL directory or if sidecars are corrupted. The WAL must not be open.
+func RebuildIndex(dir, prefix string) ([]string, error) {
+	segmentsNumbers, err := findSegmentNumber(dir, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	segments, report, err := scanSegments(path.Join(dir, prefix), segmentsNumbers, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last segment is the active one, it has no sidecar
+	for _, n := range segmentsNumbers[:len(segmentsNumbers)-1] {
+		msgs, ok := segments[n]
+		if !ok {
+			continue
+		}
+
+		filter := newKeyFilter(len(msgs))
+		for _, m := range msgs {
+			filter.add(m.Key)
+		}
+
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		stat, err := os.Stat(segmentPath)
+		if err != nil {
+			return report, errors.Wrap(err, "failed to stat log segment file")
+		}
+
+		// sidecar is as accessible as the segment itself
+		report = append(report, checkSidecar(segmentPath, msgs)...)
+		if err := writeSidecarFile(segmentPath, msgs, filter, stat.Mode().Perm()); err != nil {
+			return report, errors.Wrapf(err, "failed to rewrite sidecar of segment %s", segmentPath)
+		}
+	}
+
+	return report, nil
+}
+
+// RebuildIndex rescans all segments of the WAL, replaces the in-memory index with the one read from disk,
+// rewrites index sidecars of sealed segments and returns descriptions of inconsistencies found between
+// the in-memory index, segments and their sidecars. Only segments the WAL was opened with are scanned,
+// so segments left on disk by SkipCorrupted stay out of the index.
+func (c *Wal) RebuildIndex() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	segmentsNumbers := slices.Clone(c.segments)
+	segments, report, err := scanSegments(path.Join(c.pathToLogsDir, c.prefix), segmentsNumbers, c.codec)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint64]Msg)
+	for _, n := range segmentsNumbers {
+		for _, m := range segments[n] {
+			index[m.Idx] = m
+		}
+	}
+
+	c.index.Range(0, func(m Msg) bool {
+		read, ok := index[m.Idx]
+		switch {
+		case !ok:
+			report = append(report, fmt.Sprintf("msg %d is in the index, but not on disk", m.Idx))
+		case read.seg != m.seg || read.off != m.off || read.size != m.size:
+			report = append(report, fmt.Sprintf("msg %d is indexed at segment %d offset %d, but is stored at segment %d offset %d",
+				m.Idx, m.seg, m.off, read.seg, read.off))
+		}
+
+		return true
+	})
+	for idx := range index {
+		if _, ok := c.index.Get(idx); !ok {
+			report = append(report, fmt.Sprintf("msg %d is on disk, but not in the index", idx))
+		}
+	}
+
+	c.setIndex(index, nil)
+
+	for _, n := range segmentsNumbers {
+		if _, ok := segments[n]; !ok || n == c.activeSegment {
+			continue
+		}
+
+		report = append(report, checkSidecar(c.segmentPath(n), segments[n])...)
+		if err := c.writeSidecar(n); err != nil {
+			return report, errors.Wrapf(err, "failed to rewrite sidecar of segment %d", n)
+		}
+	}
+
+	return report, nil
+}
+
+// scanSegments decodes all msgs of the segments, returning them by segment number.
+// Msgs with the same index found in several segments are reported, the one from the newest segment wins.
+func scanSegments(basePath string, segmentsNumbers []int, codec Codec) (map[int][]Msg, []string, error) {
+	var report []string
+	segments := make(map[int][]Msg, len(segmentsNumbers))
+	seen := make(map[uint64]int)
+	for _, n := range segmentsNumbers {
+		segmentPath := segmentName(basePath, n)
+		f, err := os.Open(segmentPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, errors.Wrap(err, "failed to open log segment file")
+		}
+
+		index, err := loadIndexes(f, codec)
+		f.Close()
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to scan segment %s", segmentPath)
+		}
+
+		msgs := make([]Msg, 0, len(index))
+		for _, m := range index {
+			if prev, ok := seen[m.Idx]; ok {
+				report = append(report, fmt.Sprintf("msg %d is stored in segments %d and %d", m.Idx, prev, n))
+			}
+			seen[m.Idx] = n
+
+			m.seg = n
+			msgs = append(msgs, m)
+		}
+		segments[n] = msgs
+	}
+
+	return segments, report, nil
+}
+
+// checkSidecar compares index sidecar of the segment with msgs read from the segment.
+func checkSidecar(segmentPath string, msgs []Msg) []string {
+	sum, err := os.ReadFile(segmentPath + checkSumPostfix)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: failed to read checksum: %v", segmentPath, err)}
+	}
+
+	index, _, err := readSidecar(segmentPath, sum)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{fmt.Sprintf("%s: sidecar is missing", segmentPath)}
+		}
+		return []string{fmt.Sprintf("%s: %v", segmentPath, err)}
+	}
+
+	var report []string
+	for _, m := range msgs {
+		e, ok := index[m.Idx]
+		if !ok || e.off != m.off || e.size != m.size {
+			report = append(report, fmt.Sprintf("%s: sidecar entry of msg %d doesn't match the segment", segmentPath, m.Idx))
+		}
+	}
+	if len(index) != len(msgs) {
+		report = append(report, fmt.Sprintf("%s: sidecar has %d entries, segment has %d msgs", segmentPath, len(index), len(msgs)))
+	}
+	slices.Sort(report)
+
+	return report
+}
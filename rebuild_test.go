@@ -0,0 +1,61 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.Remove(log.segmentPath(0)+sidecarPostfix))
+	require.NoError(t, os.WriteFile(log.segmentPath(1)+sidecarPostfix, []byte("garbage"), 0755))
+
+	report, err := RebuildIndex("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Len(t, report, 2)
+
+	report, err = RebuildIndex("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Empty(t, report)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	report, err = log.RebuildIndex()
+	require.NoError(t, err)
+	require.Empty(t, report)
+
+	log.index.Delete(4)
+	report, err = log.RebuildIndex()
+	require.NoError(t, err)
+	require.Equal(t, []string{"msg 4 is on disk, but not in the index"}, report)
+
+	_, value, ok := log.Get(4)
+	require.True(t, ok)
+	require.Equal(t, "value4", string(value))
+	require.Equal(t, 10, log.Len())
+
+	m, err := log.GetByKey("key4")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), m.Idx)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
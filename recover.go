@@ -0,0 +1,318 @@
+package gowal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+)
+
+// Report describes segments repaired by SafeRecover.
+type Report struct {
+	// Segments describes every segment that didn't match its checksum, from the oldest to the newest.
+	Segments []SegmentReport
+}
+
+// SegmentReport describes what SafeRecover kept and dropped from the segment.
+type SegmentReport struct {
+	// Number is the number of the segment, Path is the path to the segment file.
+	Number int
+	Path   string
+
+	// Records is the number of records kept, FirstIndex and LastIndex are the lowest and the highest indexes of them.
+	Records    int
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// KeptBytes is the size of the segment content kept, DroppedBytes is the size of the content dropped
+	// after the last valid record.
+	KeptBytes    int64
+	DroppedBytes int64
+
+	// LostRecords is the number of records dropped that could still be read after the first corrupted one
+	// (see Salvage), records damaged beyond recognition are not counted.
+	LostRecords int
+
+	// Removed is set if the segment was removed (along with its checksum file and index sidecar),
+	// because even its header was not valid. Otherwise the segment was truncated to KeptBytes.
+	Removed bool
+}
+
+// SafeRecover repairs segments of the WAL in dir that don't match their checksums. Unlike UnsafeRecover,
+// which removes such segments, every corrupted segment is truncated to its last valid record, so only records
+// starting from the first corrupted one are lost. Sealed segments that match their footers (see VerifySegment)
+// are kept as is. Compressed segments are stored uncompressed after the repair. The WAL must not be open.
+func SafeRecover(dir, prefix string) (Report, error) {
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		return Report{}, err
+	}
+
+	segmentsNumbers, err := findSegmentNumber(dir, prefix)
+	if err != nil {
+		return Report{}, errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	var report Report
+	for _, n := range segmentsNumbers {
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		segment, repaired, err := recoverSegment(segmentPath, false, codec)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to recover segment %s", segmentPath)
+		}
+
+		if repaired {
+			segment.Number = n
+			report.Segments = append(report.Segments, segment)
+		}
+	}
+
+	// the active segment may be removed, so the current file points to the newest segment left
+	if segmentsNumbers, err = findSegmentNumber(dir, prefix); err != nil {
+		return report, errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	return report, writeCurrentSegment(dir, prefix, segmentsNumbers[len(segmentsNumbers)-1], defaultFileMode)
+}
+
+// SafeRecoverDryRun reports what SafeRecover would do with the WAL in dir without modifying anything on disk:
+// which segments would be truncated or removed and how many records would be lost.
+func SafeRecoverDryRun(dir, prefix string) (Report, error) {
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		return Report{}, err
+	}
+
+	segments, err := listSegments(dir, prefix)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	for _, n := range segments {
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		segment, repaired, err := recoverSegment(segmentPath, true, codec)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to check segment %s", segmentPath)
+		}
+
+		if repaired {
+			segment.Number = n
+			report.Segments = append(report.Segments, segment)
+		}
+	}
+
+	return report, nil
+}
+
+// UnsafeRecoverDryRun reports what UnsafeRecover would do with the WAL in dir without modifying anything on disk:
+// every segment in the report would be removed, LostRecords of it are the records that could still be read.
+func UnsafeRecoverDryRun(dir, prefix string) (Report, error) {
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		return Report{}, err
+	}
+
+	segments, err := listSegments(dir, prefix)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	for _, n := range segments {
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		stat, err := os.Stat(segmentPath)
+		if err != nil {
+			return report, errors.Wrap(err, "failed to stat segment")
+		}
+
+		intact, err := matchesChecksum(segmentPath)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to check segment %s", segmentPath)
+		}
+		if stat.Size() == 0 || intact {
+			continue
+		}
+
+		// the checksum file is stale, UnsafeRecover rewrites it
+		if _, err := VerifySegment(segmentPath); err == nil {
+			continue
+		}
+
+		msgs, _ := salvageSegment(segmentPath, codec)
+		report.Segments = append(report.Segments, SegmentReport{Number: n, Path: segmentPath,
+			DroppedBytes: stat.Size(), LostRecords: len(msgs), Removed: true})
+	}
+
+	return report, nil
+}
+
+// listSegments returns numbers of segments of the WAL in dir from the oldest to the newest. Unlike findSegmentNumber
+// it doesn't modify anything on disk.
+func listSegments(dir, prefix string) ([]int, error) {
+	segments, _, err := listSegmentFiles(dir, prefix)
+	return segments, err
+}
+
+// listSegmentFiles works like listSegments, but also returns paths of segments by number. Segments written
+// by older versions are not zero-padded, so paths are the names found in dir.
+func listSegmentFiles(dir, prefix string) ([]int, map[int]string, error) {
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read dir for wal")
+	}
+
+	var segments []int
+	paths := make(map[int]string)
+	for _, d := range de {
+		if n, ok := parseSegmentName(d.Name(), prefix); ok && !d.IsDir() {
+			segments = append(segments, n)
+			paths[n] = path.Join(dir, d.Name())
+		}
+	}
+	slices.Sort(segments)
+
+	return segments, paths, nil
+}
+
+// matchesChecksum reports whether the segment matches its checksum file, false if there is no checksum file.
+func matchesChecksum(segmentPath string) (bool, error) {
+	expected, err := os.ReadFile(segmentPath + checkSumPostfix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to read checksum file")
+	}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, errors.Wrap(err, "failed to read log segment file")
+	}
+
+	return bytes.Equal(h.Sum(nil), expected), nil
+}
+
+// recoverSegment truncates the segment to its last valid record if it doesn't match its checksum
+// and reports whether the segment was repaired. If dryRun is set, the segment is only checked
+// and the report describes what would be done.
+func recoverSegment(segmentPath string, dryRun bool, codec Codec) (SegmentReport, bool, error) {
+	report := SegmentReport{Path: segmentPath}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return report, false, nil
+		}
+		return report, false, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return report, false, errors.Wrap(err, "failed to stat segment")
+	}
+
+	if stat.Size() == 0 {
+		return report, false, nil
+	}
+
+	intact, err := matchesChecksum(segmentPath)
+	if err != nil || intact {
+		return report, false, err
+	}
+
+	// legacy segments have no frames, so they'd be removed as corrupted
+	if isLegacySegment(segmentPath) {
+		return report, false, errors.Wrapf(ErrLegacySegment, "segment %s must be migrated by NewWAL first", segmentPath)
+	}
+
+	// the checksum file is stale, but the segment is intact
+	if footer, err := VerifySegment(segmentPath); err == nil {
+		report.Records, report.FirstIndex, report.LastIndex, report.KeptBytes = footer.Records, footer.FirstIndex, footer.LastIndex, stat.Size()
+		if dryRun {
+			return report, true, nil
+		}
+		return report, true, rewriteChecksum(f, stat.Mode().Perm())
+	}
+
+	var (
+		data []byte
+		end  int64
+		meta segmentMeta
+		size = stat.Size()
+	)
+	if content, err := openSegmentContent(f); err == nil {
+		size = content.Size()
+		end, meta = validPrefix(content, size, codec)
+		if end > 0 && isCompressed(f) {
+			data = make([]byte, end)
+			if _, err := content.ReadAt(data, 0); err != nil {
+				return report, false, errors.Wrap(err, "failed to decompress segment")
+			}
+		}
+	}
+	report.Records, report.FirstIndex, report.LastIndex = meta.records, meta.first, meta.last
+	report.KeptBytes, report.DroppedBytes = end, size-end
+
+	msgs, _ := salvageSegment(segmentPath, codec)
+	for _, m := range msgs {
+		if m.off >= end {
+			report.LostRecords++
+		}
+	}
+
+	if dryRun {
+		report.Removed = end == 0
+		return report, true, nil
+	}
+
+	// the sidecar doesn't match the segment anymore
+	if err := os.Remove(segmentPath + sidecarPostfix); err != nil && !os.IsNotExist(err) {
+		return report, false, errors.Wrap(err, "failed to remove segment index sidecar")
+	}
+
+	switch {
+	case end == 0:
+		report.Removed = true
+		if err := os.Remove(segmentPath); err != nil {
+			return report, false, errors.Wrap(err, "failed to remove segment")
+		}
+		if err := os.Remove(segmentPath + checkSumPostfix); err != nil && !os.IsNotExist(err) {
+			return report, false, errors.Wrap(err, "failed to remove segment checksum file")
+		}
+	case data != nil:
+		if err := replaceSegmentData(segmentPath, data, stat.Mode().Perm()); err != nil {
+			return report, false, err
+		}
+	default:
+		if err := os.Truncate(segmentPath, end); err != nil {
+			return report, false, errors.Wrap(err, "failed to truncate segment")
+		}
+		if err := rewriteChecksum(f, stat.Mode().Perm()); err != nil {
+			return report, false, err
+		}
+	}
+
+	return report, true, nil
+}
+
+// rewriteChecksum writes the checksum of the segment to its checksum file, creating it if needed.
+func rewriteChecksum(f *os.File, mode os.FileMode) error {
+	chk, err := os.OpenFile(f.Name()+checkSumPostfix, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return errors.Wrap(err, "failed to open checksum file")
+	}
+	defer chk.Close()
+
+	return errors.Wrap(writeChecksum(f, chk), "failed to write checksum")
+}
@@ -0,0 +1,119 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSafeRecover(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 11; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// corrupt the last msg of the second segment and the header of the third one
+	pos, err := log.Position(5)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	f, err := os.OpenFile(log.segmentPath(1), os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = os.OpenFile(log.segmentPath(2), os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = NewWAL(cfg)
+	require.Error(t, err)
+
+	report, err := SafeRecover(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Len(t, report.Segments, 2)
+
+	s := report.Segments[0]
+	require.Equal(t, 1, s.Number)
+	require.False(t, s.Removed)
+	require.Equal(t, 2, s.Records)
+	require.Equal(t, uint64(3), s.FirstIndex)
+	require.Equal(t, uint64(4), s.LastIndex)
+	require.Equal(t, pos.Offset, s.KeptBytes)
+	require.Equal(t, int64(pos.Size+footerSize), s.DroppedBytes)
+
+	require.Equal(t, 2, report.Segments[1].Number)
+	require.True(t, report.Segments[1].Removed)
+	_, err = os.Stat(log.segmentPath(2))
+	require.True(t, os.IsNotExist(err))
+
+	// repaired WAL opens and nothing is left to repair
+	report, err = SafeRecover(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Empty(t, report.Segments)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 11; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.Equal(t, i != 5 && (i < 6 || i > 8), ok, i)
+		if ok {
+			require.Equal(t, "value"+strconv.Itoa(i), string(value))
+		}
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRecoverDryRun(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// corrupt msg 4 in the middle of the segment 1, msg 5 after it is still readable
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	require.Equal(t, 1, pos.Segment)
+	require.NoError(t, log.Close())
+
+	segmentPath := log.segmentPath(1)
+	data, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	data[pos.Offset+int64(pos.Size)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(segmentPath, data, 0755))
+
+	report, err := UnsafeRecoverDryRun(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, []SegmentReport{{Number: 1, Path: segmentPath, DroppedBytes: int64(len(data)), LostRecords: 2, Removed: true}}, report.Segments)
+
+	dryRun, err := SafeRecoverDryRun(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Len(t, dryRun.Segments, 1)
+	require.Equal(t, 1, dryRun.Segments[0].Records)
+	require.Equal(t, 1, dryRun.Segments[0].LostRecords)
+	require.False(t, dryRun.Segments[0].Removed)
+
+	// nothing is changed on disk
+	after, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	require.Equal(t, data, after)
+
+	// the dry run reports exactly what is done
+	report, err = SafeRecover(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, dryRun, report)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,163 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+)
+
+// RecoveryPolicy defines what NewWAL does with sealed segments that don't match their checksums,
+// see Config.RecoveryPolicy. A torn write at the end of the active segment is truncated with any policy.
+type RecoveryPolicy int
+
+const (
+	// Fail makes NewWAL fail, use Verify, SafeRecover or UnsafeRecover to deal with the corruption.
+	Fail RecoveryPolicy = iota
+
+	// TruncateTail truncates corrupted segments to their last valid record, like SafeRecover.
+	TruncateTail
+
+	// SkipCorrupted opens the WAL without corrupted segments, their files are left untouched for inspection.
+	// Readable records and unreadable byte ranges of every skipped segment are reported to the file
+	// named by the segment file followed by ".badrecords", see BadRecords.
+	SkipCorrupted
+
+	// RemoveSegment removes corrupted segments, like UnsafeRecover.
+	RemoveSegment
+)
+
+func (p RecoveryPolicy) String() string {
+	switch p {
+	case Fail:
+		return "fail"
+	case TruncateTail:
+		return "truncate tail"
+	case SkipCorrupted:
+		return "skip corrupted"
+	case RemoveSegment:
+		return "remove segment"
+	default:
+		return fmt.Sprintf("RecoveryPolicy(%d)", int(p))
+	}
+}
+
+// applyRecoveryPolicy checks sealed segments with the given numbers (all but the last one, which is active)
+// and handles corrupted ones according to the policy, reporting them to onCorruption if it is set.
+// It returns numbers of segments the WAL is opened with and numbers of corrupted segments found.
+// Segments are not checked if the policy is Fail and onCorruption is not set, loading them fails anyway.
+func applyRecoveryPolicy(policy RecoveryPolicy, onCorruption func(string, int64, uint64, error), dir, prefix string, segmentsNumbers []int, codec Codec) ([]int, []int, error) {
+	if policy == Fail && onCorruption == nil {
+		return segmentsNumbers, nil, nil
+	}
+
+	var kept, corrupted []int
+	for _, n := range segmentsNumbers[:len(segmentsNumbers)-1] {
+		segmentPath := path.Join(dir, segmentName(prefix, n))
+		ok, err := checkSealedSegment(segmentPath)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to check segment %s", segmentPath)
+		}
+
+		if ok {
+			kept = append(kept, n)
+			continue
+		}
+		corrupted = append(corrupted, n)
+
+		if onCorruption != nil {
+			offset, err := corruptionOffset(segmentPath, codec)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to find corrupted record of segment %s", segmentPath)
+			}
+			onCorruption(segmentPath, offset, 0, errors.Wrapf(ErrCorruptedFrame, "segment doesn't match its checksum, first invalid record at offset %d", offset))
+		}
+
+		switch policy {
+		case Fail:
+			kept = append(kept, n)
+		case SkipCorrupted:
+			if err := writeBadRecords(segmentPath, defaultFileMode, codec); err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to report bad records of segment %s", segmentPath)
+			}
+		case TruncateTail:
+			report, _, err := recoverSegment(segmentPath, false, codec)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to truncate segment %s", segmentPath)
+			}
+			if !report.Removed {
+				kept = append(kept, n)
+			}
+		case RemoveSegment:
+			for _, name := range []string{segmentPath, segmentPath + checkSumPostfix, segmentPath + sidecarPostfix} {
+				if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+					return nil, nil, errors.Wrap(err, "failed to remove corrupted segment")
+				}
+			}
+		}
+	}
+
+	return append(kept, segmentsNumbers[len(segmentsNumbers)-1]), corrupted, nil
+}
+
+// checkSealedSegment reports whether the sealed segment matches its checksum. The stale checksum
+// of the segment matching its footer (e.g. the process crashed while sealing the segment) is rewritten.
+func checkSealedSegment(segmentPath string) (bool, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	chk, err := os.OpenFile(segmentPath+checkSumPostfix, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, errors.Wrap(err, "failed to open checksum file")
+	}
+	defer chk.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to stat segment")
+	}
+
+	statChk, err := chk.Stat()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to stat checksum file")
+	}
+
+	if stat.Size() == 0 || statChk.Size() == 0 || compareChecksums(f, chk) == nil {
+		return true, nil
+	}
+
+	if _, err := VerifySegment(segmentPath); err != nil {
+		return false, nil
+	}
+
+	if err := writeChecksum(f, chk); err != nil {
+		return false, errors.Wrap(err, "failed to write checksum")
+	}
+
+	return true, nil
+}
+
+// corruptionOffset returns the offset of the first invalid frame of the segment (0 if even its header is not valid,
+// the size of the segment if all frames are valid).
+func corruptionOffset(segmentPath string, codec Codec) (int64, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return 0, nil
+	}
+
+	end, _ := validPrefix(content, content.Size(), codec)
+
+	return end, nil
+}
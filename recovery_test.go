@@ -0,0 +1,175 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestRecoveryPolicy(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100}
+
+	// corrupt writes msgs to three segments and corrupts msg 4 in the middle of the sealed segment 1
+	corrupt := func(t *testing.T) (string, []byte) {
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+		for i := 0; i < 9; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+		pos, err := log.Position(4)
+		require.NoError(t, err)
+		require.Equal(t, 1, pos.Segment)
+		segmentPath := log.segmentPath(1)
+		require.NoError(t, log.Close())
+
+		data, err := os.ReadFile(segmentPath)
+		require.NoError(t, err)
+		data[pos.Offset+int64(pos.Size)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(segmentPath, data, 0755))
+
+		return segmentPath, data
+	}
+
+	check := func(t *testing.T, policy RecoveryPolicy, want []int) *Wal {
+		cfg := cfg
+		cfg.RecoveryPolicy = policy
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+		require.Equal(t, []int{1}, log.Stats().CorruptedSegments)
+
+		var got []int
+		for i := 0; i < 9; i++ {
+			if _, _, ok := log.Get(uint64(i)); ok {
+				got = append(got, i)
+			}
+		}
+		require.Equal(t, want, got)
+
+		return log
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		corrupt(t)
+		_, err := NewWAL(cfg)
+		require.Error(t, err)
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("TruncateTail", func(t *testing.T) {
+		segmentPath, data := corrupt(t)
+		log := check(t, TruncateTail, []int{0, 1, 2, 3, 6, 7, 8})
+		require.NoError(t, log.Close())
+
+		stat, err := os.Stat(segmentPath)
+		require.NoError(t, err)
+		require.Less(t, stat.Size(), int64(len(data)))
+
+		// the segment is repaired, so it's not reported again
+		log, err = NewWAL(cfg)
+		require.NoError(t, err)
+		require.Empty(t, log.Stats().CorruptedSegments)
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("SkipCorrupted", func(t *testing.T) {
+		segmentPath, data := corrupt(t)
+		log := check(t, SkipCorrupted, []int{0, 1, 2, 6, 7, 8})
+		require.NotContains(t, log.segments, 1)
+		require.NoError(t, log.Write(9, "key9", []byte("value9")))
+
+		// the skipped segment stays out of the rebuilt index, its sidecar is not rewritten
+		sidecar, err := os.ReadFile(segmentPath + sidecarPostfix)
+		require.NoError(t, err)
+		_, err = log.RebuildIndex()
+		require.NoError(t, err)
+		_, _, ok := log.Get(3)
+		require.False(t, ok)
+		stored, err := os.ReadFile(segmentPath + sidecarPostfix)
+		require.NoError(t, err)
+		require.Equal(t, sidecar, stored)
+		require.NoError(t, log.Close())
+
+		// the segment is left untouched for inspection
+		stored, err = os.ReadFile(segmentPath)
+		require.NoError(t, err)
+		require.Equal(t, data, stored)
+
+		// msg 4 is reported between readable msgs 3 and 5
+		bad, err := ReadBadRecords(segmentPath)
+		require.NoError(t, err)
+		require.Equal(t, segmentPath, bad.Segment)
+		require.Len(t, bad.Records, 2)
+		require.Equal(t, []uint64{3, 5}, []uint64{bad.Records[0].Index, bad.Records[1].Index})
+		require.Len(t, bad.Skipped, 1)
+		require.Equal(t, bad.Records[0].Offset+int64(bad.Records[0].Size), bad.Skipped[0].Offset)
+		require.Equal(t, bad.Records[1].Offset, bad.Skipped[0].Offset+bad.Skipped[0].Size)
+		require.Equal(t, &IndexRange{From: 4, To: 4}, bad.Skipped[0].Indexes)
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("RemoveSegment", func(t *testing.T) {
+		segmentPath, _ := corrupt(t)
+		log := check(t, RemoveSegment, []int{0, 1, 2, 6, 7, 8})
+		require.NoError(t, log.Close())
+
+		_, err := os.Stat(segmentPath)
+		require.True(t, os.IsNotExist(err))
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	_, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100, RecoveryPolicy: 10})
+	require.Error(t, err)
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestOnCorruption(t *testing.T) {
+	type corruption struct {
+		segment string
+		offset  int64
+		idx     uint64
+	}
+	var reported []corruption
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100, OffsetOnlyIndex: true,
+		OnCorruption: func(segment string, offset int64, idx uint64, err error) {
+			require.ErrorIs(t, err, ErrCorruptedFrame)
+			reported = append(reported, corruption{segment, offset, idx})
+		}}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	segmentPath, activePath := log.segmentPath(pos.Segment), log.segmentPath(log.activeSegment)
+	f, err := os.OpenFile(segmentPath, os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// corrupted record is reported when read
+	_, err = log.GetMsg(4)
+	require.ErrorIs(t, err, ErrCorruptedFrame)
+	require.Equal(t, []corruption{{segmentPath, pos.Offset, 4}}, reported)
+	require.NoError(t, log.Close())
+
+	// and on startup, along with the torn write at the end of the active segment
+	stat, err := os.Stat(activePath)
+	require.NoError(t, err)
+	f, err = os.OpenFile(activePath, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("torn"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reported = nil
+	_, err = NewWAL(cfg)
+	require.Error(t, err)
+	require.Equal(t, []corruption{{activePath, stat.Size(), 0}, {segmentPath, pos.Offset, 0}}, reported)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
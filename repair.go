@@ -0,0 +1,115 @@
+package gowal
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+)
+
+// lookup returns msg with the given index, reading it from disk if only its position is kept in memory.
+// Msg kept in memory is verified against its checksum and repaired from disk if corrupted.
+func (c *Wal) lookup(index uint64) (Msg, error) {
+	c.mu.RLock()
+	if !c.mayContainIndex(index) {
+		c.mu.RUnlock()
+		return Msg{}, ErrNotFound
+	}
+
+	m, ok := c.index.Get(index)
+	if ok && m.onDisk {
+		m, err := c.materialize(m)
+		c.mu.RUnlock()
+
+		return m, err
+	}
+
+	// the frame is read under the same lock as its position, so it can't be moved by compaction in between
+	var (
+		read    Msg
+		readErr error
+	)
+	if ok && c.verifyReads {
+		if read, readErr = c.readMsg(m); errors.Is(readErr, ErrCorruptedFrame) {
+			c.diskCorruption(m, readErr)
+		}
+	}
+	c.mu.RUnlock()
+
+	if !ok {
+		return Msg{}, ErrNotFound
+	}
+	c.access.touch(m.seg)
+
+	if c.verifyReads {
+		if readErr != nil && !errors.Is(readErr, ErrCorruptedFrame) {
+			return Msg{}, errors.Wrapf(readErr, "failed to verify msg %d against disk", index)
+		}
+		if readErr == nil {
+			return c.verifyAgainstDisk(m, read)
+		}
+	}
+
+	if m.unchecked || m.checksum() == m.sum {
+		return m, nil
+	}
+
+	return c.repair(index)
+}
+
+// verifyAgainstDisk compares the in-memory copy of the msg with the msg read from its frame on disk
+// (see Config.VerifyReads). The frame is verified by its checksum, so the in-memory copy is replaced
+// if they differ.
+func (c *Wal) verifyAgainstDisk(m, read Msg) (Msg, error) {
+	if m.Key == read.Key && bytes.Equal(m.Value, read.Value) && m.Timestamp.Equal(read.Timestamp) {
+		return m, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the msg could be removed or moved while the lock was not held
+	if cur, ok := c.index.Get(m.Idx); !ok || cur.onDisk || cur.seg != m.seg || cur.off != m.off {
+		return read, nil
+	}
+
+	c.memoryCorruptions.Add(1)
+	c.emit(Event{Type: EventCorruptionDetected, Segment: m.seg, Path: c.segmentPath(m.seg), Index: m.Idx,
+		Err: errors.Errorf("in-memory copy of msg %d differs from disk, repaired from disk", m.Idx)})
+
+	c.addToIndex(read)
+
+	return read, nil
+}
+
+// repair re-reads msg with corrupted in-memory copy from its segment and replaces the in-memory copy.
+func (c *Wal) repair(index uint64) (Msg, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m, ok := c.index.Get(index)
+	if !ok {
+		return Msg{}, ErrNotFound
+	}
+
+	// repaired by another reader
+	if m.checksum() == m.sum {
+		return m, nil
+	}
+
+	repaired, err := c.readMsg(m)
+	if err != nil {
+		if errors.Is(err, ErrCorruptedFrame) {
+			err = errors.Wrapf(err, "msg %d is corrupted both in memory and on disk", index)
+			c.diskCorruption(m, err)
+			return Msg{}, err
+		}
+		return Msg{}, errors.Wrapf(err, "failed to repair corrupted msg %d from disk", index)
+	}
+
+	c.memoryCorruptions.Add(1)
+	c.emit(Event{Type: EventCorruptionDetected, Segment: m.seg, Path: c.segmentPath(m.seg), Index: index,
+		Err: errors.Errorf("in-memory copy of msg %d is corrupted, repaired from disk", index)})
+
+	c.addToIndex(repaired)
+
+	return repaired, nil
+}
@@ -0,0 +1,114 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestReadRepair(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                 "./testlogdata",
+		Prefix:              "log_",
+		SegmentThreshold:    10,
+		MaxSegments:         5,
+		IsInSyncDiskMode:    false,
+		ValueArenaChunkSize: 1024,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	t.Run("memory corruption", func(t *testing.T) {
+		// flip a bit of the in-memory copy
+		indexed(log, 1).Value[0] ^= 0x01
+		memory, arena := log.Stats().IndexMemoryBytes, len(log.arenas[indexed(log, 1).seg].chunk)
+
+		_, value, ok := log.Get(1)
+		require.True(t, ok)
+		require.Equal(t, "value1", string(value))
+		require.Equal(t, uint64(1), log.Stats().MemoryCorruptions)
+		require.Zero(t, log.Stats().DiskCorruptions)
+
+		// the repaired copy is kept like other msgs
+		require.Equal(t, memory, log.Stats().IndexMemoryBytes)
+		require.Equal(t, arena+len("value1"), len(log.arenas[indexed(log, 1).seg].chunk))
+	})
+
+	t.Run("disk corruption", func(t *testing.T) {
+		pos, err := log.Position(2)
+		require.NoError(t, err)
+
+		f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+		require.NoError(t, err)
+		_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		indexed(log, 2).Value[0] ^= 0x01
+
+		_, _, ok := log.Get(2)
+		require.False(t, ok)
+
+		_, err = log.GetMsg(2)
+		require.ErrorIs(t, err, ErrCorruptedFrame)
+		require.Equal(t, uint64(2), log.Stats().DiskCorruptions)
+	})
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestVerifyReads(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                 "./testlogdata",
+		Prefix:              "log_",
+		SegmentThreshold:    10,
+		MaxSegments:         5,
+		VerifyReads:         true,
+		ValueArenaChunkSize: 1024,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// the in-memory copy is changed along with its checksum, so the checksum doesn't catch it
+	m, ok := log.index.Get(1)
+	require.True(t, ok)
+	m.Value = []byte("forged")
+	m.sum = m.checksum()
+	log.index.Put(m)
+	memory, arena := log.Stats().IndexMemoryBytes, len(log.arenas[m.seg].chunk)
+
+	_, value, ok := log.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "value1", string(value))
+	require.Equal(t, uint64(1), log.Stats().MemoryCorruptions)
+
+	// the in-memory copy is repaired and kept like other msgs
+	m, _ = log.index.Get(1)
+	require.Equal(t, "value1", string(m.Value))
+	require.Equal(t, memory, log.Stats().IndexMemoryBytes)
+	require.Equal(t, arena+len("value1"), len(log.arenas[m.seg].chunk))
+
+	// the intact in-memory copy is used if the msg is corrupted on disk
+	pos, err := log.Position(2)
+	require.NoError(t, err)
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, value, ok = log.Get(2)
+	require.True(t, ok)
+	require.Equal(t, "value2", string(value))
+	require.Equal(t, uint64(1), log.Stats().DiskCorruptions)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
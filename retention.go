@@ -0,0 +1,140 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"time"
+)
+
+// segmentNewest returns the time the newest msg of the segment was written at. If timestamps of some msgs
+// are unknown, modification time of the segment file is used, which is never earlier. The caller must hold the lock.
+func (c *Wal) segmentNewest(seg int) (time.Time, error) {
+	if meta := c.metas[seg]; meta.records > 0 && !meta.untimed {
+		return meta.newest, nil
+	}
+
+	stat, err := os.Stat(c.segmentPath(seg))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to stat segment")
+	}
+
+	return stat.ModTime(), nil
+}
+
+// enforceRetentionAge evicts the oldest segments while their newest msgs are older than Config.RetentionAge.
+// The active segment is sealed first if its newest msg is that old. The caller must hold the lock.
+func (c *Wal) enforceRetentionAge() error {
+	cutoff := time.Now().Add(-c.retentionAge)
+
+	if c.metas[c.activeSegment].records > 0 {
+		newest, err := c.segmentNewest(c.activeSegment)
+		if err != nil {
+			return err
+		}
+
+		// if there are no free segments, expired sealed segments are evicted first and rotation is retried later
+		if newest.Before(cutoff) {
+			if err := c.rotate(); err != nil && err != ErrFull {
+				return err
+			}
+		}
+	}
+
+	for c.segments[0] != c.activeSegment {
+		newest, err := c.segmentNewest(c.segments[0])
+		if err != nil {
+			return err
+		}
+
+		if !newest.Before(cutoff) {
+			return nil
+		}
+
+		evicted, err := c.evictOldestSegment()
+		if err != nil || !evicted {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retainByAge evicts segments older than RetentionAge in the background until the WAL is closed,
+// waking up when the oldest segment expires. Failed eviction is retried later.
+func (c *Wal) retainByAge() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+
+		next := c.retentionAge
+		if err := c.enforceRetentionAge(); err == nil && c.metas[c.segments[0]].records > 0 {
+			if newest, err := c.segmentNewest(c.segments[0]); err == nil {
+				next = time.Until(newest.Add(c.retentionAge))
+			}
+		}
+		c.mu.Unlock()
+
+		timer.Reset(max(next, time.Millisecond))
+	}
+}
+
+// enforceMaxTotalBytes evicts the oldest segments while files of segments take more than Config.MaxTotalBytes.
+// The caller must hold the lock.
+func (c *Wal) enforceMaxTotalBytes() error {
+	if c.maxTotalBytes <= 0 {
+		return nil
+	}
+
+	sizes := make(map[int]int64, len(c.segments))
+	var total int64
+	for _, seg := range c.segments {
+		size, err := c.segmentDiskSize(seg)
+		if err != nil {
+			return err
+		}
+		sizes[seg] = size
+		total += size
+	}
+
+	for total > c.maxTotalBytes && c.segments[0] != c.activeSegment {
+		seg := c.segments[0]
+		evicted, err := c.evictOldestSegment()
+		if err != nil || !evicted {
+			return err
+		}
+		total -= sizes[seg]
+	}
+
+	return nil
+}
+
+// segmentDiskSize returns the size of files of the segment: the segment itself, its checksum file and index sidecar.
+func (c *Wal) segmentDiskSize(seg int) (int64, error) {
+	segmentPath := c.segmentPath(seg)
+
+	var size int64
+	for _, name := range []string{segmentPath, segmentPath + checkSumPostfix, segmentPath + sidecarPostfix} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			if os.IsNotExist(err) && name != segmentPath {
+				continue
+			}
+			return 0, errors.Wrap(err, "failed to stat segment file")
+		}
+		size += stat.Size()
+	}
+
+	return size, nil
+}
@@ -2,36 +2,163 @@ package gowal
 
 import (
 	"github.com/pkg/errors"
+	"time"
 )
 
-// rotateIfNeeded rotates the log if needed.
-//
-// It opens a new segment if the number of records in the log exceeds the threshold
-// and closes oldest segment if the number of segments exceeds the limit.
-func (c *Wal) rotateIfNeeded(index uint64, key string, value []byte) error {
-	if len(c.index) < c.segmentsThreshold {
+// rotateIfNeeded rotates the log if the active segment is full, that is it holds SegmentThreshold records,
+// its file reached SegmentMaxBytes or it is older than SegmentMaxAge.
+func (c *Wal) rotateIfNeeded() error {
+	if !c.needsRotation() {
 		return nil
 	}
 
-	if len(c.index) >= c.segmentsNumber*c.segmentsThreshold {
-		// remove oldest segment if the number of segments exceeds the limit
-		if c.segmentsNumber >= c.maxSegments {
-			if err := c.removeOldestSegment(); err != nil {
-				return err
-			}
+	return c.rotate()
+}
+
+// needsRotation reports whether the active segment is full. The caller must hold the lock.
+func (c *Wal) needsRotation() bool {
+	records := c.metas[c.activeSegment].records
+	byCount := c.segmentsThreshold > 0 && records >= c.segmentsThreshold
+	bySize := c.segmentMaxBytes > 0 && records > 0 && c.lastOffset >= c.segmentMaxBytes
+	byAge := c.segmentMaxAge > 0 && records > 0 && time.Since(c.activeSince) >= c.segmentMaxAge
+
+	return byCount || bySize || byAge
+}
+
+// rotate seals the active segment, opens a new one and removes the oldest segments
+// if the number of segments exceeds the limit. It fails with ErrFull if the oldest segments
+// may not be removed (see Config.EvictionPolicy).
+func (c *Wal) rotate() error {
+	if c.segmentsFull() {
+		return ErrFull
+	}
+
+	// don't leave unsynced data behind in the sealed segment
+	if c.unflushedBytes > 0 && c.maxUnflushedBytes > 0 {
+		if err := c.sync(); err != nil {
+			return err
 		}
+	}
+
+	if err := c.writeFooter(); err != nil {
+		return err
+	}
 
-		// close current segment and open new one
-		if err := c.log.Close(); err != nil {
-			return errors.Wrap(err, "failed to close log file")
+	if err := c.sealFilter(c.activeSegment); err != nil {
+		return err
+	}
+
+	if err := c.writeSidecar(c.activeSegment); err != nil {
+		return err
+	}
+
+	// close current segment and open new one
+	if err := c.log.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+
+	if err := c.checksum.Close(); err != nil {
+		return errors.Wrap(err, "failed to close checksum file")
+	}
+
+	sealed := c.activeSegment
+	if err := c.openNewSegment(); err != nil {
+		return err
+	}
+	c.makeHot(c.activeSegment)
+
+	if err := writeCurrentSegment(c.pathToLogsDir, c.prefix, c.activeSegment, c.fileMode); err != nil {
+		return err
+	}
+
+	if c.compressSegments {
+		if err := c.compressSegment(sealed); err != nil {
+			return errors.Wrapf(err, "failed to compress segment %d", sealed)
 		}
+	}
 
-		if err := c.openNewSegment(); err != nil {
+	c.emit(Event{Type: EventSegmentSealed, Segment: sealed, Path: c.segmentPath(sealed)})
+	c.emit(Event{Type: EventSegmentCreated, Segment: c.activeSegment, Path: c.segmentPath(c.activeSegment)})
+
+	// remove oldest segments if the number of segments exceeds the limit
+	for len(c.segments) > max(c.maxSegments, 1) {
+		evicted, err := c.evictOldestSegment()
+		if err != nil {
 			return err
 		}
+		if !evicted {
+			break
+		}
+	}
+
+	return c.enforceMaxTotalBytes()
+}
+
+// rotateByAge rotates the active segment in the background once it gets older than SegmentMaxAge,
+// until the WAL is closed. Failed rotation is retried by the next write, which returns the error.
+func (c *Wal) rotateByAge() {
+	timer := time.NewTimer(c.segmentMaxAge)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+
+		next := c.segmentMaxAge
+		if err := c.rotateIfNeeded(); err == nil && c.metas[c.activeSegment].records > 0 {
+			next = time.Until(c.activeSince.Add(c.segmentMaxAge))
+		}
+		c.mu.Unlock()
+
+		timer.Reset(max(next, time.Millisecond))
+	}
+}
+
+// activeSegmentAge returns time the first record of the active segment was written at,
+// the current time if it's unknown.
+func activeSegmentAge(index map[uint64]Msg, activeSegment int) time.Time {
+	var first time.Time
+	for _, m := range index {
+		if m.seg == activeSegment && !m.onDisk && (first.IsZero() || m.Timestamp.Before(first)) {
+			first = m.Timestamp
+		}
 	}
 
-	c.tmpIndex[index] = msg{Key: key, Value: value, Idx: index}
+	if first.IsZero() {
+		return time.Now()
+	}
+
+	return first
+}
+
+// Rotate seals the active segment and starts a new one, e.g. before taking a backup or shipping sealed segments
+// to external storage. The sealed segment is synced to disk. It does nothing if the active segment is empty.
+func (c *Wal) Rotate() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	if c.metas[c.activeSegment].records == 0 {
+		return nil
+	}
+
+	if c.unflushedBytes > 0 {
+		if err := c.sync(); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	return c.rotate()
 }
@@ -0,0 +1,142 @@
+package gowal
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Salvage reads whatever msgs can be read from the (possibly damaged) WAL directory without opening the WAL
+// and without modifying anything on disk. Unlike NewWAL it doesn't fail on damaged data: segments that can't
+// be opened or have bad headers are skipped, and corrupted frames are skipped (see salvageSegment).
+// Checksum files and WAL ID are ignored, the manifest is read only to get the codec of the WAL.
+//
+// It returns msgs ordered by index (if msgs with the same index are found, the first one read is kept)
+// and descriptions of everything that was skipped.
+// Use it in disaster scenarios, when partial data now is better than complete data later.
+func Salvage(dir, prefix string) ([]Msg, []string, error) {
+	de, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read dir for wal")
+	}
+
+	var (
+		segmentsNumbers []int
+		skipped         []string
+	)
+	// segments written by older versions are not zero-padded, so names are kept as found
+	names := make(map[int]string)
+	for _, d := range de {
+		name := d.Name()
+		if d.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasPrefix(name, prefix+manifestPostfix) || name == prefix+annotationsPostfix || name == prefix+currentPostfix ||
+			name == prefix+checkpointsPostfix ||
+			strings.HasPrefix(name, prefix+snapshotPostfix) ||
+			strings.HasSuffix(name, checkSumPostfix) || strings.HasSuffix(name, badRecordsPostfix) || strings.Contains(name, sidecarPostfix) {
+			continue
+		}
+
+		n, ok := parseSegmentName(name, prefix)
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("%s: not a segment of the WAL", name))
+			continue
+		}
+		segmentsNumbers = append(segmentsNumbers, n)
+		names[n] = name
+	}
+	slices.Sort(segmentsNumbers)
+
+	// the manifest may be damaged as well, msgs are decoded with the default codec then
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		codec = defaultCodec
+		skipped = append(skipped, fmt.Sprintf("%s: %v, msgs are decoded with the default codec", prefix+manifestPostfix, err))
+	}
+
+	seen := make(map[uint64]struct{})
+	var msgs []Msg
+	for _, n := range segmentsNumbers {
+		segmentPath := path.Join(dir, names[n])
+		read, errs := salvageSegment(segmentPath, codec)
+		for _, err := range errs {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", segmentPath, err))
+		}
+
+		for _, m := range read {
+			if _, ok := seen[m.Idx]; ok {
+				skipped = append(skipped, fmt.Sprintf("%s: duplicate msg %d at offset %d", segmentPath, m.Idx, m.off))
+				continue
+			}
+			seen[m.Idx] = struct{}{}
+
+			m.seg = n
+			msgs = append(msgs, m)
+		}
+	}
+
+	slices.SortFunc(msgs, func(a, b Msg) int { return cmp.Compare(a.Idx, b.Idx) })
+
+	return msgs, skipped, nil
+}
+
+// salvageSegment reads msgs of the segment, skipping frames that can't be read. The length of the corrupted frame
+// can't be trusted, so reading continues from the next valid frame found by its header checksum (see frame.Resync),
+// frames written by older versions have no header checksum, so the rest of the segment is skipped then.
+// It returns msgs read along with errors describing skipped parts of the segment.
+func salvageSegment(segmentPath string, codec Codec) ([]Msg, []error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, []error{errors.Wrap(err, "failed to open log segment file")}
+	}
+	defer f.Close()
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(content, 0, content.Size()))
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var (
+		msgs    []Msg
+		skipped []error
+	)
+	offset := int64(header.size)
+	for {
+		m, size, err := readFrame(r, codec)
+		if err == errFooter {
+			offset += int64(size)
+			continue
+		}
+		if err == io.EOF {
+			return msgs, skipped
+		}
+		if err != nil {
+			next, ok, rerr := resync(content, offset, content.Size())
+			if rerr != nil {
+				return msgs, append(skipped, rerr)
+			}
+			if !ok {
+				return msgs, append(skipped, errors.Wrapf(err, "failed to read frame at offset %d, rest of the segment is skipped", offset))
+			}
+
+			skipped = append(skipped, errors.Wrapf(err, "failed to read frame at offset %d, %d bytes are skipped", offset, next-offset))
+			offset = next
+			r = bufio.NewReader(io.NewSectionReader(content, offset, content.Size()-offset))
+			continue
+		}
+
+		m.off, m.size = offset, size
+		msgs = append(msgs, m)
+		offset += int64(size)
+	}
+}
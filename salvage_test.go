@@ -0,0 +1,53 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSalvage(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// corrupt the second msg of the second segment
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, os.WriteFile("./testlogdata/log_garbage", []byte("garbage"), 0755))
+
+	_, err = NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 5})
+	require.Error(t, err)
+
+	msgs, skipped, err := Salvage("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Len(t, skipped, 2)
+
+	var indexes []uint64
+	for _, m := range msgs {
+		require.Equal(t, "value"+strconv.Itoa(int(m.Idx)), string(m.Value))
+		indexes = append(indexes, m.Idx)
+	}
+	// reading continues after the corrupted msg
+	require.Equal(t, []uint64{0, 1, 2, 3, 5, 6, 7, 8}, indexes)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,177 @@
+package gowal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// scrubChunkSize is the size of chunks segments are read by while scrubbing, the rate limit is applied between them.
+const scrubChunkSize = 64 << 10
+
+// errScrubStopped is returned by scrubSegment if the WAL was closed while the segment was read.
+var errScrubStopped = errors.New("scrubbing stopped")
+
+// ScrubStats describes the background scrubber, see Config.ScrubInterval.
+type ScrubStats struct {
+	// Segments is the number of sealed segments verified, Bytes is the number of bytes read to verify them.
+	Segments uint64
+	Bytes    int64
+
+	// Corruptions is the number of sealed segments found not matching their checksums.
+	Corruptions uint64
+
+	// LastRun is the time the last segment was verified at, zero if no segments were verified.
+	LastRun time.Time
+}
+
+// scrubInBackground verifies checksums of sealed segments one by one, one segment every ScrubInterval,
+// cycling through all sealed segments until the WAL is closed, see Config.ScrubInterval.
+// Segments are read without the lock, so reads and writes are not blocked.
+func (c *Wal) scrubInBackground() {
+	ticker := time.NewTicker(c.scrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.RLock()
+		if c.closed {
+			c.mu.RUnlock()
+			return
+		}
+		seg, ok := c.nextSegmentToScrub()
+		segmentPath := c.segmentPath(seg)
+		c.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		n, err := scrubSegment(segmentPath, c.scrubBytesPerSecond, c.stop)
+		if errors.Is(err, errScrubStopped) {
+			return
+		}
+
+		c.mu.Lock()
+		c.scrubNext = seg + 1
+		c.scrub.Segments++
+		c.scrub.Bytes += n
+		c.scrub.LastRun = time.Now()
+		if err != nil {
+			c.confirmScrubCorruption(seg)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// nextSegmentToScrub returns the oldest sealed segment starting from the one after the last scrubbed,
+// wrapping around to the oldest sealed segment. Segments already reported as corrupted are skipped.
+// The caller must hold the lock.
+func (c *Wal) nextSegmentToScrub() (int, bool) {
+	var first int
+	found := false
+	for _, seg := range c.sealedSegments() {
+		if _, reported := c.scrubCorrupted[seg]; reported {
+			continue
+		}
+		if seg >= c.scrubNext {
+			return seg, true
+		}
+		if !found {
+			first, found = seg, true
+		}
+	}
+
+	return first, found
+}
+
+// confirmScrubCorruption verifies the segment found corrupted by the scrubber again, this time with the lock held,
+// because the segment may have been rewritten by compaction while it was read. The corruption is reported
+// if the segment still doesn't match its checksum. The caller must hold the lock.
+func (c *Wal) confirmScrubCorruption(seg int) {
+	if !slices.Contains(c.sealedSegments(), seg) {
+		return
+	}
+
+	segmentPath := c.segmentPath(seg)
+	if _, err := scrubSegment(segmentPath, 0, nil); err == nil {
+		return
+	}
+
+	offset, err := corruptionOffset(segmentPath, c.codec)
+	if err != nil {
+		offset = 0
+	}
+
+	c.scrub.Corruptions++
+	c.scrubCorrupted[seg] = struct{}{}
+	c.diskCorruption(Msg{seg: seg, off: offset}, errors.Wrapf(ErrCorruptedFrame, "segment doesn't match its checksum, first invalid record at offset %d", offset))
+}
+
+// scrubSegment compares the segment with its checksum file reading at most bytesPerSecond bytes
+// per second (no limit if 0), it returns the number of bytes read. Segments without checksum files are
+// not verified. errScrubStopped is returned if stop is closed while the segment is read.
+func scrubSegment(segmentPath string, bytesPerSecond int64, stop <-chan struct{}) (int64, error) {
+	expected, err := os.ReadFile(segmentPath + checkSumPostfix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "failed to read checksum file")
+	}
+	if len(expected) == 0 {
+		return 0, nil
+	}
+
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	var (
+		h     = sha256.New()
+		buf   = make([]byte, scrubChunkSize)
+		read  int64
+		start = time.Now()
+	)
+	for {
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		read += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return read, errors.Wrap(err, "failed to read log segment file")
+		}
+
+		if bytesPerSecond > 0 {
+			wait := time.Duration(float64(read)/float64(bytesPerSecond)*float64(time.Second)) - time.Since(start)
+			if wait > 0 {
+				select {
+				case <-stop:
+					return read, errScrubStopped
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return read, errors.Errorf("segment %s doesn't match its checksum", segmentPath)
+	}
+
+	return read, nil
+}
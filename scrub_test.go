@@ -0,0 +1,91 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScrub(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		reported []string
+	)
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      100,
+		ScrubInterval:    time.Millisecond,
+		OnCorruption: func(segment string, offset int64, idx uint64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, segment)
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// all sealed segments are verified, nothing is reported
+	require.Eventually(t, func() bool { return log.Stats().Scrub.Segments >= 4 }, time.Second, time.Millisecond)
+	require.Zero(t, log.Stats().Scrub.Corruptions)
+
+	// flip a bit of msg 4 in the sealed segment 1 behind the WAL's back
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	segmentPath := log.segmentPath(pos.Segment)
+	data, err := os.ReadFile(segmentPath)
+	require.NoError(t, err)
+	data[pos.Offset+int64(pos.Size)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(segmentPath, data, 0755))
+
+	require.Eventually(t, func() bool { return log.Stats().Scrub.Corruptions == 1 }, time.Second, time.Millisecond)
+
+	// the corrupted segment is reported once
+	scrubbed := log.Stats().Scrub.Segments
+	require.Eventually(t, func() bool { return log.Stats().Scrub.Segments >= scrubbed+4 }, time.Second, time.Millisecond)
+	mu.Lock()
+	require.Equal(t, []string{segmentPath}, reported)
+	mu.Unlock()
+
+	stats := log.Stats()
+	require.Equal(t, uint64(1), stats.Scrub.Corruptions)
+	require.Equal(t, uint64(1), stats.DiskCorruptions)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestScrubSegmentRateLimit(t *testing.T) {
+	log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 100})
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), make([]byte, scrubChunkSize)))
+	}
+	segmentPath := log.segmentPath(0)
+	require.NoError(t, log.Close())
+
+	stat, err := os.Stat(segmentPath)
+	require.NoError(t, err)
+
+	// 3 chunks at 30 chunks per second take at least 100ms
+	start := time.Now()
+	n, err := scrubSegment(segmentPath, 30*scrubChunkSize, nil)
+	require.NoError(t, err)
+	require.Equal(t, stat.Size(), n)
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// closing stop interrupts scrubbing
+	stop := make(chan struct{})
+	close(stop)
+	_, err = scrubSegment(segmentPath, 1, stop)
+	require.ErrorIs(t, err, errScrubStopped)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -1,100 +1,197 @@
 package gowal
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/pkg/errors"
-	msgpack "github.com/vmihailenco/msgpack/v5"
 	"io"
-	"maps"
 	"os"
 	"path"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-// removeOldestSegment deletes the oldest segment.
+// removeOldestSegment deletes the oldest segment and drops its msgs from the index.
 func (c *Wal) removeOldestSegment() error {
-	oldestSegment := c.oldestSegmentName()
-	if err := os.Remove(oldestSegment); err != nil {
-		return errors.Wrap(err, "failed to remove oldest segment")
+	return c.removeSegment(c.segments[0])
+}
+
+// evictOldestSegment deletes the oldest segment unless Config.OnEvict fails for it,
+// in which case the segment is kept and false is returned.
+func (c *Wal) evictOldestSegment() (bool, error) {
+	if c.onEvict != nil {
+		if err := c.onEvict(c.segmentPath(c.segments[0])); err != nil {
+			return false, nil
+		}
 	}
 
-	if err := os.Remove(oldestSegment + checkSumPostfix); err != nil {
-		return errors.Wrap(err, "failed to remove oldest segment checksum file")
+	seg := c.segments[0]
+	var err error
+	if c.archiveDir != "" {
+		err = c.archiveSegment(seg)
+	} else {
+		err = c.removeOldestSegment()
 	}
+	if err != nil {
+		return true, err
+	}
+	c.emit(Event{Type: EventSegmentEvicted, Segment: seg, Path: c.segmentPath(seg)})
 
-	c.index = c.tmpIndex
-	c.tmpIndex = make(map[uint64]msg)
+	return true, nil
+}
+
+// removeSegment deletes the sealed segment and drops its msgs from the index.
+func (c *Wal) removeSegment(seg int) error {
+	c.closeSegmentFile(seg)
+
+	segmentPath := c.segmentPath(seg)
+	if err := os.Remove(segmentPath); err != nil {
+		return errors.Wrap(err, "failed to remove segment")
+	}
+
+	if err := os.Remove(segmentPath + checkSumPostfix); err != nil {
+		return errors.Wrap(err, "failed to remove segment checksum file")
+	}
+
+	if err := os.Remove(segmentPath + sidecarPostfix); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove segment index sidecar")
+	}
+
+	c.forgetSegment(seg)
 
 	return nil
 }
 
+// forgetSegment drops the segment which files were removed from the WAL directory and its msgs from the index.
+func (c *Wal) forgetSegment(seg int) {
+	c.access.forget(seg)
+	delete(c.filters, seg)
+	delete(c.offsetOnlySegments, seg)
+	if c.cache != nil {
+		c.cache.evictSegment(seg)
+	}
+
+	c.segments = slices.DeleteFunc(c.segments, func(s int) bool { return s == seg })
+	delete(c.metas, seg)
+	delete(c.arenas, seg)
+	c.forgetTier(seg)
+
+	var removed []uint64
+	c.index.Range(0, func(m Msg) bool {
+		if m.seg == seg {
+			removed = append(removed, m.Idx)
+		}
+		return true
+	})
+	for _, idx := range removed {
+		c.index.Delete(idx)
+	}
+	c.reindex()
+	c.segmentRemoved.Broadcast()
+}
+
 // openNewSegment creates new segment.
 func (c *Wal) openNewSegment() error {
-	newSegmentName := path.Join(c.pathToLogsDir, c.prefix+strconv.Itoa(c.segmentsNumber))
-	logFile, err := os.OpenFile(newSegmentName, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0755)
+	number := c.activeSegment + 1
+	newSegmentName := c.segmentPath(number)
+	logFile, err := os.OpenFile(newSegmentName, os.O_APPEND|os.O_RDWR|os.O_CREATE, c.fileMode)
 	if err != nil {
 		return errors.Wrap(err, "failed to create new log file")
 	}
 
-	checksumFile, err := os.OpenFile(newSegmentName+checkSumPostfix, os.O_RDWR|os.O_CREATE, 0755)
+	checksumFile, err := os.OpenFile(newSegmentName+checkSumPostfix, os.O_RDWR|os.O_CREATE, c.fileMode)
 	if err != nil {
 		return errors.Wrap(err, "failed to create new log file")
 	}
 
-	c.segmentsNumber++
+	stat, err := logFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat new log file")
+	}
+
+	lastOffset := stat.Size()
+	if lastOffset == 0 {
+		if lastOffset, err = writeSegmentHeader(logFile, checksumFile, c.id); err != nil {
+			return err
+		}
+	}
+
+	crc, err := segmentFileCRC(newSegmentName)
+	if err != nil {
+		return err
+	}
+
+	c.activeSegment = number
+	c.segments = append(c.segments, number)
 
 	c.log = logFile
 	c.checksum = checksumFile
-	c.lastOffset = 0
+	c.lastOffset = lastOffset
+	c.segmentCRC = crc
 
 	return nil
 }
 
-// oldestSegmentName returns name of the oldest segment.
-func (c *Wal) oldestSegmentName() string {
-	oldestSegmentNumber := c.segmentsNumber - c.maxSegments
-	if oldestSegmentNumber < 0 {
-		oldestSegmentNumber = 0
-	}
-	return path.Join(c.pathToLogsDir, c.prefix+strconv.Itoa(oldestSegmentNumber))
+// segmentName returns name of the segment file with the given number. Numbers are zero-padded,
+// so lexical order of segment files matches their numeric order.
+func segmentName(prefix string, number int) string {
+	return fmt.Sprintf("%s%09d", prefix, number)
+}
+
+// segmentPath returns path to the segment file with the given number.
+func (c *Wal) segmentPath(number int) string {
+	return path.Join(c.pathToLogsDir, segmentName(c.prefix, number))
 }
 
 // segmentInfoAndIndex loads segment info (file descriptor, name, size, etc) and index from segment files.
-// Works like loadSegment, but for multiple segments.
-func segmentInfoAndIndex(segNumbers []int, path string) (*os.File, *os.File, int64, map[uint64]msg, error) {
-	index := make(map[uint64]msg)
+// Works like loadSegment, but for multiple segments, key filters loaded from sidecars are returned by segment number.
+// Msgs of newer segments win over msgs with the same index of older segments, such indexes are recorded to dups.
+func segmentInfoAndIndex(segNumbers []int, path string, id [16]byte, useSidecars bool, mode os.FileMode, dups duplicates, codec Codec) (*os.File, *os.File, int64, map[uint64]Msg, map[int]*keyFilter, error) {
+	index := make(map[uint64]Msg)
+	filters := make(map[int]*keyFilter)
 	var (
 		logFileFD      *os.File
 		checksumFd     *os.File
 		lastOffset     int64
-		idxFromSegment map[uint64]msg
+		idxFromSegment map[uint64]Msg
+		filter         *keyFilter
 		err            error
 	)
 	for _, segindex := range segNumbers {
 		if logFileFD != nil {
 			logFileFD.Close()
+			checksumFd.Close()
 		}
 
-		logFileFD, checksumFd, lastOffset, idxFromSegment, err = loadSegment(path + strconv.Itoa(segindex))
+		logFileFD, checksumFd, lastOffset, idxFromSegment, filter, err = loadSegment(segmentName(path, segindex), id, useSidecars, mode, codec)
 		if err != nil {
-			return nil, nil, 0, nil, errors.Wrap(err, "failed to load indexes from msg log file")
+			return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to load indexes from msg log file")
 		}
 
-		maps.Copy(index, idxFromSegment)
+		if filter != nil {
+			filters[segindex] = filter
+		}
+
+		for idx, m := range idxFromSegment {
+			m.seg = segindex
+			idxFromSegment[idx] = m
+		}
+		dups.merge(index, idxFromSegment)
 	}
 
-	return logFileFD, checksumFd, lastOffset, index, nil
+	return logFileFD, checksumFd, lastOffset, index, filters, nil
 }
 
-// removeCorruptedSegments removes corrupted segments and their checksums.
-func removeCorruptedSegments(segmentNumbers []int, basePath string) ([]string, error) {
+// removeCorruptedSegments removes corrupted segments and their checksums, or moves them to quarantine
+// directory if it is set.
+func removeCorruptedSegments(segmentNumbers []int, basePath, quarantine string) ([]string, error) {
 	var removedFiles []string
 
 	for _, segmentNumber := range segmentNumbers {
-		segmentPath := basePath + strconv.Itoa(segmentNumber)
-		removed, err := handleCorruptedSegment(segmentPath)
+		segmentPath := segmentName(basePath, segmentNumber)
+		removed, err := handleCorruptedSegment(segmentPath, quarantine)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to process segment %s", segmentPath)
 		}
@@ -107,44 +204,86 @@ func removeCorruptedSegments(segmentNumbers []int, basePath string) ([]string, e
 }
 
 // loadSegment loads segment info (file descriptor, name, size, etc) and index from segment file.
-func loadSegment(path string) (fd *os.File, checksumFd *os.File, lastOffset int64, index map[uint64]msg, err error) {
-	fd, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0755)
+// It fails with ErrForeignSegment if the segment was written by a WAL with another ID.
+// If useSidecar is set, only positions of msgs and key filter are loaded from the index sidecar of the segment
+// if it's valid, otherwise filter is nil.
+func loadSegment(path string, id [16]byte, useSidecar bool, mode os.FileMode, codec Codec) (fd *os.File, checksumFd *os.File, lastOffset int64, index map[uint64]Msg, filter *keyFilter, err error) {
+	fd, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, mode)
 	if err != nil {
-		return nil, nil, 0, nil, errors.Wrap(err, "failed to open log segment file")
+		return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to open log segment file")
 	}
 
-	chk, err := os.OpenFile(path+checkSumPostfix, os.O_RDWR|os.O_CREATE, 0755)
+	chk, err := os.OpenFile(path+checkSumPostfix, os.O_RDWR|os.O_CREATE, mode)
 	if err != nil {
-		return nil, nil, 0, nil, errors.Wrap(err, "failed to cheksum file")
+		fd.Close()
+		return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to cheksum file")
 	}
 
+	defer func() {
+		if err != nil {
+			fd.Close()
+			chk.Close()
+		}
+	}()
+
 	statFd, err := fd.Stat()
 	if err != nil {
-		return nil, nil, 0, nil, err
+		return nil, nil, 0, nil, nil, err
 	}
 
 	statChk, err := chk.Stat()
 	if err != nil {
-		return nil, nil, 0, nil, err
+		return nil, nil, 0, nil, nil, err
 	}
 
 	if statFd.Size() != 0 && statChk.Size() != 0 {
 		if err = compareChecksums(fd, chk); err != nil {
-			return nil, nil, 0, nil, errors.Wrap(err, "failed to compare checksums")
+			return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to compare checksums")
+		}
+	}
+
+	if statFd.Size() == 0 {
+		if _, err = writeSegmentHeader(fd, chk, id); err != nil {
+			return nil, nil, 0, nil, nil, err
+		}
+	} else {
+		header, err := readSegmentHeaderFromFile(path)
+		if err != nil {
+			return nil, nil, 0, nil, nil, errors.Wrapf(err, "failed to read header of segment %s", path)
+		}
+
+		if header.id != id {
+			return nil, nil, 0, nil, nil, errors.Wrapf(ErrForeignSegment, "segment %s was written by WAL %s", path, formatID(header.id))
+		}
+
+		if err := checkSegmentHeader(header, path); err != nil {
+			return nil, nil, 0, nil, nil, err
 		}
 	}
 
 	lastOffset, err = calculateLastOffset(fd)
 	if err != nil {
-		return nil, nil, 0, nil, errors.Wrap(err, "failed to calculate last offset")
+		return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to calculate last offset")
 	}
 
-	index, err = loadIndexes(fd)
+	if useSidecar && statChk.Size() != 0 {
+		sum, err := os.ReadFile(chk.Name())
+		if err != nil {
+			return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to read segment checksum")
+		}
+
+		// fall back to decoding the segment if sidecar can't be used
+		if sidecarIndex, filter, err := readSidecar(path, sum); err == nil {
+			return fd, chk, lastOffset, sidecarIndex, filter, nil
+		}
+	}
+
+	index, err = loadIndexes(fd, codec)
 	if err != nil {
-		return nil, nil, 0, nil, errors.Wrap(err, "failed to build index from log segment")
+		return nil, nil, 0, nil, nil, errors.Wrap(err, "failed to build index from log segment")
 	}
 
-	return fd, chk, lastOffset, index, nil
+	return fd, chk, lastOffset, index, nil, nil
 }
 
 func calculateLastOffset(fd *os.File) (int64, error) {
@@ -157,18 +296,19 @@ func calculateLastOffset(fd *os.File) (int64, error) {
 		return 0, nil
 	}
 
-	return fileInfo.Size() + 1, nil
+	return fileInfo.Size(), nil
 }
 
-// handleCorruptedSegment checks the checksum and removes the segment and checksum files if corrupted.
-func handleCorruptedSegment(segmentPath string) (bool, error) {
-	file, err := os.OpenFile(segmentPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+// handleCorruptedSegment checks the checksum and removes the segment and checksum files if corrupted,
+// or moves them with the index sidecar to quarantine directory if it is set.
+func handleCorruptedSegment(segmentPath, quarantine string) (bool, error) {
+	file, err := os.OpenFile(segmentPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultFileMode)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to open segment file")
 	}
 	defer file.Close()
 
-	checksumFile, err := os.OpenFile(segmentPath+checkSumPostfix, os.O_RDWR|os.O_CREATE, 0755)
+	checksumFile, err := os.OpenFile(segmentPath+checkSumPostfix, os.O_RDWR|os.O_CREATE, defaultFileMode)
 	if err != nil {
 		return false, errors.Wrap(err, "failed to open checksum file")
 	}
@@ -192,6 +332,18 @@ func handleCorruptedSegment(segmentPath string) (bool, error) {
 		return false, nil // Checksums match; no need to erase.
 	}
 
+	// the checksum file is stale (e.g. the process crashed while sealing the segment), but the segment is intact
+	if _, err := VerifySegment(segmentPath); err == nil {
+		if err := writeChecksum(file, checksumFile); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if quarantine != "" {
+		return true, quarantineSegment(segmentPath, quarantine)
+	}
+
 	if err := os.Remove(segmentPath); err != nil {
 		return false, errors.Wrap(err, "failed to remove corrupted segment")
 	}
@@ -203,11 +355,28 @@ func handleCorruptedSegment(segmentPath string) (bool, error) {
 	return true, nil
 }
 
-// findSegmentNumbers finds all segment numbers in the directory.
+// quarantineSegment moves the corrupted segment with its checksum file and index sidecar to quarantine directory.
+func quarantineSegment(segmentPath, quarantine string) error {
+	if err := os.MkdirAll(quarantine, defaultDirMode); err != nil {
+		return errors.Wrap(err, "failed to create quarantine dir")
+	}
+
+	for _, name := range []string{segmentPath + sidecarPostfix, segmentPath + checkSumPostfix, segmentPath} {
+		if err := moveFile(name, path.Join(quarantine, path.Base(name)), defaultFileMode); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to quarantine %s", name)
+		}
+	}
+
+	return nil
+}
+
+// findSegmentNumbers finds all segment numbers in the directory. Segments written by older versions
+// are renamed to zero-padded names once all of them are checked, it fails with ErrLegacySegment
+// without renaming anything if some of them have the legacy format.
 func findSegmentNumber(dir string, prefix string) (segmentsNumbers []int, err error) {
 	_, err = os.Stat(dir)
 	if os.IsNotExist(err) {
-		if err := os.Mkdir(dir, 0755); err != nil {
+		if err := os.Mkdir(dir, defaultDirMode); err != nil {
 			return nil, errors.Wrap(err, "failed to create dir for wal")
 		}
 	}
@@ -217,22 +386,33 @@ func findSegmentNumber(dir string, prefix string) (segmentsNumbers []int, err er
 	}
 
 	segmentsNumbers = make([]int, 0)
+	unpadded := make(map[string]string)
 	for _, d := range de {
 		if d.IsDir() {
 			continue
 		}
 
-		if strings.HasSuffix(d.Name(), checkSumPostfix) {
+		// files of other WALs sharing the directory, checksums, sidecars and other files of this WAL are skipped
+		i, ok := parseSegmentName(d.Name(), prefix)
+		if !ok {
 			continue
 		}
 
-		if strings.HasPrefix(d.Name(), prefix) {
-			i, err := extractSegmentNum(d.Name())
-			if err != nil {
-				return nil, errors.Wrap(err, "initialization failed: failed to extract segment number from wal file name")
-			}
+		if isLegacySegment(path.Join(dir, d.Name())) {
+			return nil, errors.Wrapf(ErrLegacySegment, "segment %s must be migrated by NewWAL first", d.Name())
+		}
 
-			segmentsNumbers = append(segmentsNumbers, i)
+		// segments written by older versions are not zero-padded
+		if name := segmentName(prefix, i); d.Name() != name {
+			unpadded[d.Name()] = name
+		}
+
+		segmentsNumbers = append(segmentsNumbers, i)
+	}
+
+	for from, to := range unpadded {
+		if err := renameSegment(dir, from, to); err != nil {
+			return nil, errors.Wrapf(err, "failed to rename segment %s", from)
 		}
 	}
 
@@ -247,36 +427,82 @@ func findSegmentNumber(dir string, prefix string) (segmentsNumbers []int, err er
 	return segmentsNumbers, nil
 }
 
-// loadIndexes loads index from log file.
-func loadIndexes(file *os.File) (map[uint64]msg, error) {
-	file.Seek(0, io.SeekStart)
+// renameSegment renames the segment file along with its checksum file and index sidecar.
+// The segment is renamed last, so an interrupted rename is completed on the next call.
+func renameSegment(dir, from, to string) error {
+	for _, postfix := range []string{checkSumPostfix, sidecarPostfix} {
+		if err := os.Rename(path.Join(dir, from+postfix), path.Join(dir, to+postfix)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(path.Join(dir, from), path.Join(dir, to))
+}
+
+// loadIndexes loads index from log file, decompressing it if the segment is compressed.
+func loadIndexes(file *os.File, codec Codec) (map[uint64]Msg, error) {
+	content, err := openSegmentContent(file)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[uint64]Msg)
+	r := bufio.NewReader(io.NewSectionReader(content, 0, content.Size()))
 
-	index := make(map[uint64]msg)
-	dec := msgpack.NewDecoder(file)
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		return nil, err
+	}
 
+	offset := int64(header.size)
 	for {
-		var msgIndexed msg
-		if err := dec.Decode(&msgIndexed); err != nil {
+		msgIndexed, size, err := readFrame(r, codec)
+		if err == errFooter {
+			offset += int64(size)
+			continue
+		}
+		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return nil, errors.Wrap(err, "failed to decode indexed msg from log")
 		}
+
+		msgIndexed.off = offset
+		msgIndexed.size = size
+		msgIndexed.sum = msgIndexed.checksum()
 		index[msgIndexed.Idx] = msgIndexed
+		offset += int64(size)
 	}
 
 	return index, nil
 }
 
-func extractSegmentNum(segmentName string) (int, error) {
-	_, suffix, ok := strings.Cut(segmentName, "_")
-	if !ok {
-		return 0, fmt.Errorf("failed to cut suffix from log file name %s", segmentName)
+// parseSegmentName returns the number of the segment with the given file name and reports whether the file
+// is a segment of the WAL with the given prefix, that is its name is the prefix followed by digits only.
+func parseSegmentName(name, prefix string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, prefix)
+	if !ok || digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
 	}
-	i, err := strconv.Atoi(suffix)
+
+	n, err := strconv.Atoi(digits)
 	if err != nil {
-		return 0, fmt.Errorf("failed to convert suffix %s to int", suffix)
+		return 0, false
+	}
+
+	return n, true
+}
+
+// checkPrefix checks that segment names with the prefix can't be confused with segment names of other prefixes.
+func checkPrefix(prefix string) error {
+	if prefix != "" && strings.ContainsAny(prefix[len(prefix)-1:], "0123456789") {
+		return errors.Errorf("prefix %q must not end with a digit", prefix)
 	}
 
-	return i, nil
+	if strings.ContainsAny(prefix, `/\`) {
+		return errors.Errorf("prefix %q must not contain path separators", prefix)
+	}
+
+	return nil
 }
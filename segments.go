@@ -0,0 +1,246 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SegmentInfo describes a segment of the log.
+type SegmentInfo struct {
+	// Number is the number of the segment file.
+	Number int
+
+	// Path is the path to the segment file.
+	Path string
+
+	// Size is the size of the segment file in bytes.
+	Size int64
+
+	// Records is the number of records stored in the segment.
+	Records int
+
+	// FirstIndex and LastIndex are the lowest and the highest indexes of records stored in the segment.
+	FirstIndex uint64
+	LastIndex  uint64
+
+	// Active is set for the segment the log is currently written to.
+	Active bool
+
+	// CreatedAt is the time the segment was created at, zero for segments created by older versions.
+	CreatedAt time.Time
+
+	// Reads is the number of reads of records of the segment since the WAL was opened.
+	Reads uint64
+
+	// LastAccess is the time of the last read of a record of the segment since the WAL was opened,
+	// zero if there were no reads.
+	LastAccess time.Time
+}
+
+// segmentMeta holds the number of msgs of a segment and the range of their indexes,
+// so segments can be skipped without consulting the index.
+type segmentMeta struct {
+	records int
+	first   uint64
+	last    uint64
+
+	// the newest timestamp of msgs, untimed is set if timestamps of some msgs are unknown
+	// because only their positions are kept in memory
+	newest  time.Time
+	untimed bool
+
+	// resident is the memory taken by keys and values of msgs of the segment kept in memory, see msgMemory
+	resident int64
+}
+
+// add accounts the msg.
+func (s *segmentMeta) add(m Msg) {
+	if s.records == 0 || m.Idx < s.first {
+		s.first = m.Idx
+	}
+	if s.records == 0 || m.Idx > s.last {
+		s.last = m.Idx
+	}
+	s.records++
+
+	if m.onDisk {
+		s.untimed = true
+	} else if m.Timestamp.After(s.newest) {
+		s.newest = m.Timestamp
+	}
+}
+
+// contains reports whether the given index is within the range of indexes of the segment.
+func (s segmentMeta) contains(idx uint64) bool {
+	return s.records > 0 && s.first <= idx && idx <= s.last
+}
+
+// addToMeta accounts msg in the metadata of its segment. The caller must hold the lock.
+func (c *Wal) addToMeta(m Msg) {
+	meta := c.metas[m.seg]
+	meta.add(m)
+	c.metas[m.seg] = meta
+}
+
+// mayContainIndex reports whether the index is within the range of indexes of any segment,
+// msgs with other indexes are definitely absent. The caller must hold the lock.
+func (c *Wal) mayContainIndex(idx uint64) bool {
+	for _, meta := range c.metas {
+		if meta.contains(idx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentAccess holds access statistics of a segment.
+type segmentAccess struct {
+	reads      atomic.Uint64
+	lastAccess atomic.Int64
+}
+
+// accessStats holds access statistics of segments, it is safe for concurrent use.
+type accessStats struct {
+	mu       sync.Mutex
+	segments map[int]*segmentAccess
+}
+
+// touch records read of a record of the segment.
+func (a *accessStats) touch(seg int) {
+	a.mu.Lock()
+	if a.segments == nil {
+		a.segments = make(map[int]*segmentAccess)
+	}
+	s, ok := a.segments[seg]
+	if !ok {
+		s = &segmentAccess{}
+		a.segments[seg] = s
+	}
+	a.mu.Unlock()
+
+	s.reads.Add(1)
+	s.lastAccess.Store(time.Now().UnixNano())
+}
+
+// get returns access statistics of the segment.
+func (a *accessStats) get(seg int) (uint64, time.Time) {
+	a.mu.Lock()
+	s, ok := a.segments[seg]
+	a.mu.Unlock()
+
+	if !ok {
+		return 0, time.Time{}
+	}
+
+	return s.reads.Load(), time.Unix(0, s.lastAccess.Load())
+}
+
+// forget removes access statistics of the removed segment.
+func (a *accessStats) forget(seg int) {
+	a.mu.Lock()
+	delete(a.segments, seg)
+	a.mu.Unlock()
+}
+
+// Segments returns info about all segments of the log, ordered by segment number.
+// Access statistics are kept in memory only, so they help to find segments that are rarely read
+// (candidates for archiving) and to pick retention settings that match actual read patterns.
+func (c *Wal) Segments() ([]SegmentInfo, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	segments := map[int]*SegmentInfo{c.activeSegment: {Number: c.activeSegment}}
+	for seg, meta := range c.metas {
+		segments[seg] = &SegmentInfo{Number: seg, Records: meta.records, FirstIndex: meta.first, LastIndex: meta.last}
+	}
+
+	infos := make([]SegmentInfo, 0, len(segments))
+	for _, s := range segments {
+		s.Path = c.segmentPath(s.Number)
+		s.Active = s.Number == c.activeSegment
+		s.Reads, s.LastAccess = c.access.get(s.Number)
+
+		stat, err := os.Stat(s.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat segment %s", s.Path)
+		}
+		s.Size = stat.Size()
+
+		header, err := readSegmentHeaderFromFile(s.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read header of segment %s", s.Path)
+		}
+		s.CreatedAt = header.created
+
+		infos = append(infos, *s)
+	}
+
+	slices.SortFunc(infos, func(a, b SegmentInfo) int { return a.Number - b.Number })
+
+	return infos, nil
+}
+
+// ListSegments returns info about all segments of the WAL stored in dir without opening the WAL,
+// ordered by segment number, e.g. for admin tooling. Sealed segments are described by their footers,
+// segments without footers (the active one and ones sealed by older versions) are decoded.
+// Access statistics are not available and are left zero. Nothing is changed on disk.
+func ListSegments(dir, prefix string) ([]SegmentInfo, error) {
+	segmentsNumbers, paths, err := listSegmentFiles(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the codec is needed only for segments without footers
+	codec, codecErr := walCodec(dir, prefix)
+
+	infos := make([]SegmentInfo, 0, len(segmentsNumbers))
+	for i, n := range segmentsNumbers {
+		s := SegmentInfo{Number: n, Path: paths[n], Active: i == len(segmentsNumbers)-1}
+
+		stat, err := os.Stat(s.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat segment %s", s.Path)
+		}
+		s.Size = stat.Size()
+
+		header, err := readSegmentHeaderFromFile(s.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read header of segment %s", s.Path)
+		}
+		s.CreatedAt = header.created
+
+		if footer, err := ReadSegmentFooter(s.Path); err == nil {
+			s.Records, s.FirstIndex, s.LastIndex = footer.Records, footer.FirstIndex, footer.LastIndex
+			infos = append(infos, s)
+			continue
+		}
+
+		if codecErr != nil {
+			return nil, codecErr
+		}
+
+		f, err := os.Open(s.Path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open log segment file")
+		}
+		index, err := loadIndexes(f, codec)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode segment %s", s.Path)
+		}
+
+		var meta segmentMeta
+		for _, m := range index {
+			meta.add(m)
+		}
+		s.Records, s.FirstIndex, s.LastIndex = meta.records, meta.first, meta.last
+		infos = append(infos, s)
+	}
+
+	return infos, nil
+}
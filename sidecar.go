@@ -0,0 +1,141 @@
+package gowal
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"os"
+	"slices"
+)
+
+const (
+	// sidecarPostfix is appended to the segment name to get the name of its index sidecar file.
+	sidecarPostfix = ".idx"
+
+	// sidecarEntrySize is the size of one entry of the sidecar.
+	sidecarEntrySize = 20
+)
+
+var errBadSidecar = errors.New("bad segment index sidecar")
+
+// writeSidecar writes index sidecar of the sealed segment, so positions of its msgs and filter of its keys
+// can be loaded on startup without decoding the segment.
+//
+// Sidecar layout (little endian):
+//
+//	+-------------------+-------+----------------------------------+-------------+------------+-------+
+//	| segment checksum  | count | entries: idx, offset, frame size | filter size | key filter | crc32 |
+//	| 32                | 4     | count * (8 + 8 + 4)              | 4           | size       | 4     |
+//	+-------------------+-------+----------------------------------+-------------+------------+-------+
+//
+// Segment checksum is the content of the segment checksum file at the moment of sealing,
+// sidecar is ignored if the segment was changed after that.
+func (c *Wal) writeSidecar(seg int) error {
+	var msgs []Msg
+	c.index.Range(0, func(m Msg) bool {
+		if m.seg == seg {
+			msgs = append(msgs, m)
+		}
+		return true
+	})
+
+	return writeSidecarFile(c.segmentPath(seg), msgs, c.filters[seg], c.fileMode)
+}
+
+// writeSidecarFile writes index sidecar of the segment with the given msgs and key filter (may be nil).
+func writeSidecarFile(segmentPath string, msgs []Msg, filter *keyFilter, mode os.FileMode) error {
+	sum, err := os.ReadFile(segmentPath + checkSumPostfix)
+	if err != nil {
+		return errors.Wrap(err, "failed to read segment checksum")
+	}
+
+	msgs = slices.Clone(msgs)
+	slices.SortFunc(msgs, func(a, b Msg) int { return cmp.Compare(a.off, b.off) })
+
+	buf := make([]byte, 0, len(sum)+4+len(msgs)*sidecarEntrySize+4)
+	buf = append(buf, sum...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(msgs)))
+	for _, m := range msgs {
+		buf = binary.LittleEndian.AppendUint64(buf, m.Idx)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(m.off))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(m.size))
+	}
+
+	var filterData []byte
+	if filter != nil {
+		filterData = filter.encode()
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(filterData)))
+	buf = append(buf, filterData...)
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	sidecarPath := segmentPath + sidecarPostfix
+	tmp := sidecarPath + ".tmp"
+	if err := os.WriteFile(tmp, buf, mode); err != nil {
+		return errors.Wrap(err, "failed to write segment index sidecar")
+	}
+
+	if err := os.Rename(tmp, sidecarPath); err != nil {
+		return errors.Wrap(err, "failed to replace segment index sidecar")
+	}
+
+	return nil
+}
+
+// readSidecar loads positions of msgs of the segment and filter of its keys (nil if the sidecar has no filter)
+// from the index sidecar. It fails if the sidecar is missing, corrupted or doesn't match the segment checksum.
+func readSidecar(segmentPath string, segmentChecksum []byte) (map[uint64]Msg, *keyFilter, error) {
+	data, err := os.ReadFile(segmentPath + sidecarPostfix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(data) < len(segmentChecksum)+12 {
+		return nil, nil, errors.Wrap(errBadSidecar, "sidecar is too short")
+	}
+
+	body, crc := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != crc {
+		return nil, nil, errors.Wrap(errBadSidecar, "checksums do not match")
+	}
+
+	if !bytes.Equal(body[:len(segmentChecksum)], segmentChecksum) {
+		return nil, nil, errors.Wrap(errBadSidecar, "segment was changed after sidecar was written")
+	}
+	body = body[len(segmentChecksum):]
+
+	count := int(binary.LittleEndian.Uint32(body[0:4]))
+	body = body[4:]
+	if len(body) < count*sidecarEntrySize+4 {
+		return nil, nil, errors.Wrapf(errBadSidecar, "expected %d entries", count)
+	}
+
+	filterData := body[count*sidecarEntrySize+4:]
+	if len(filterData) != int(binary.LittleEndian.Uint32(body[count*sidecarEntrySize:])) {
+		return nil, nil, errors.Wrap(errBadSidecar, "bad key filter size")
+	}
+
+	var filter *keyFilter
+	if len(filterData) > 0 {
+		if filter, err = decodeKeyFilter(filterData); err != nil {
+			return nil, nil, errors.Wrap(errBadSidecar, err.Error())
+		}
+	}
+
+	index := make(map[uint64]Msg, count)
+	for i := 0; i < count; i++ {
+		e := body[i*sidecarEntrySize:]
+		m := Msg{
+			Idx:    binary.LittleEndian.Uint64(e[0:8]),
+			off:    int64(binary.LittleEndian.Uint64(e[8:16])),
+			size:   int(binary.LittleEndian.Uint32(e[16:20])),
+			onDisk: true,
+		}
+		index[m.Idx] = m
+	}
+
+	return index, filter, nil
+}
@@ -0,0 +1,108 @@
+// Package simple is a stable facade over gowal that keeps the original minimal API:
+// Write, Get, Iterator, PullIterator, CurrentIndex and Close, configured by the original set of options.
+//
+// New features of gowal never change this package, so code written against it keeps compiling
+// on upgrades. WAL directories written by early versions without segment headers are migrated
+// to the current format on open (see gowal.MigrateLegacySegments). Use Unwrap to get to the full
+// gowal API when needed.
+package simple
+
+import (
+	"github.com/vadiminshakov/gowal"
+	"iter"
+)
+
+// Msg is a record of the log.
+type Msg = gowal.Msg
+
+// Config represents the configuration for the WAL (Write-Ahead Log).
+type Config struct {
+	// Dir is the directory where the log files will be stored.
+	Dir string
+
+	// Prefix is the prefix for the segment files.
+	Prefix string
+
+	// SegmentThreshold is the number of records after which a new segment is created.
+	SegmentThreshold int
+
+	// MaxSegments is the maximum number of segments allowed before the oldest segment is deleted.
+	MaxSegments int
+
+	// IsInSyncDiskMode indicates whether the log should be synced to disk after each write.
+	IsInSyncDiskMode bool
+}
+
+// Wal is a write-ahead log that stores key-value pairs.
+type Wal struct {
+	w *gowal.Wal
+}
+
+// NewWAL creates a new WAL with the given configuration.
+func NewWAL(config Config) (*Wal, error) {
+	w, err := gowal.NewWAL(gowal.Config{
+		Dir:              config.Dir,
+		Prefix:           config.Prefix,
+		SegmentThreshold: config.SegmentThreshold,
+		MaxSegments:      config.MaxSegments,
+		IsInSyncDiskMode: config.IsInSyncDiskMode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wal{w: w}, nil
+}
+
+// UnsafeRecover recovers the WAL from the given directory, see gowal.UnsafeRecover.
+func UnsafeRecover(dir, segmentPrefix string) ([]string, error) {
+	return gowal.UnsafeRecover(dir, segmentPrefix)
+}
+
+// Get queries value at specific index in the log.
+func (c *Wal) Get(index uint64) (string, []byte, bool) {
+	return c.w.Get(index)
+}
+
+// CurrentIndex returns current index of the log.
+func (c *Wal) CurrentIndex() uint64 {
+	return c.w.CurrentIndex()
+}
+
+// Write writes key-value pair to the log.
+func (c *Wal) Write(index uint64, key string, value []byte) error {
+	return c.w.Write(index, key, value)
+}
+
+// Iterator returns push-based iterator for the WAL messages.
+// Messages are returned from the oldest to the newest.
+//
+// Should be used like this:
+//
+//	for msg := range wal.Iterator() {
+//		...
+func (c *Wal) Iterator() iter.Seq[Msg] {
+	return c.w.Iterator()
+}
+
+// PullIterator returns pull-based iterator for the WAL messages.
+// Messages are returned from the oldest to the newest.
+//
+// Should be used like this:
+//
+//	next, stop := wal.PullIterator()
+//	defer stop()
+//	...
+func (c *Wal) PullIterator() (next func() (Msg, bool), stop func()) {
+	return c.w.PullIterator()
+}
+
+// Close closes log and checksum files.
+func (c *Wal) Close() error {
+	return c.w.Close()
+}
+
+// Unwrap returns the underlying gowal.Wal with the full API.
+func (c *Wal) Unwrap() *gowal.Wal {
+	return c.w
+}
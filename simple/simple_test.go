@@ -0,0 +1,85 @@
+package simple
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSimple(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	key, value, ok := log.Get(3)
+	require.True(t, ok)
+	require.Equal(t, "key3", key)
+	require.Equal(t, "value3", string(value))
+	require.Equal(t, uint64(4), log.CurrentIndex())
+
+	i := 0
+	for msg := range log.Iterator() {
+		require.Equal(t, uint64(i), msg.Idx)
+		i++
+	}
+	require.Equal(t, 5, i)
+
+	require.Equal(t, 5, log.Unwrap().Len())
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSimpleOpensLegacyWAL(t *testing.T) {
+	// the WAL written by the version without segment headers, 10 msgs in segments log_0, log_1 and log_2
+	require.NoError(t, os.MkdirAll("./testlogdata", 0755))
+	entries, err := os.ReadDir("../testdata/legacy")
+	require.NoError(t, err)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join("../testdata/legacy", e.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join("./testlogdata", e.Name()), data, 0755))
+	}
+
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 4, MaxSegments: 10}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), log.CurrentIndex())
+
+	i := 0
+	for msg := range log.Iterator() {
+		require.Equal(t, uint64(i), msg.Idx)
+		require.Equal(t, "key"+strconv.Itoa(i), msg.Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(msg.Value))
+		i++
+	}
+	require.Equal(t, 10, i)
+
+	require.NoError(t, log.Write(10, "key10", []byte("value10")))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	_, value, ok := log.Get(10)
+	require.True(t, ok)
+	require.Equal(t, "value10", string(value))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
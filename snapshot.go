@@ -0,0 +1,284 @@
+package gowal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"hash/crc32"
+	"os"
+	"path"
+)
+
+// snapshotPostfix is appended to the segment prefix to get the name of the index snapshot file.
+const snapshotPostfix = "snapshot"
+
+var errBadSnapshot = errors.New("bad index snapshot")
+
+// snapshotSegment describes a segment at the moment the snapshot was written.
+type snapshotSegment struct {
+	number   int
+	size     int64
+	checksum []byte
+}
+
+// writeSnapshot writes the index to the snapshot file, so it can be loaded on startup without decoding segments.
+// Msgs kept in memory are written as is, only positions are written for other msgs. The caller must hold the lock.
+//
+// Snapshot layout (little endian), every list is prefixed with the number of its elements (4 bytes):
+//
+//	WAL ID       16
+//	segments     number 4, size 8, checksum 32
+//	entries      idx 8, segment 4, offset 8, frame size 4, msg size 4, msg
+//	key filters  segment 4, filter size 4, filter
+//	keys         key size 4, key, idx 8
+//	crc32        4
+//
+// Msg is msgpack encoded, its size is 0 if the msg is not kept in memory.
+// The snapshot is ignored if any segment was changed, added or removed after it was written.
+func (c *Wal) writeSnapshot() error {
+	segmentsNumbers, err := findSegmentNumber(c.pathToLogsDir, c.prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	segments, err := describeSegments(path.Join(c.pathToLogsDir, c.prefix), segmentsNumbers)
+	if err != nil {
+		return err
+	}
+
+	buf := append([]byte(nil), c.id[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(segments)))
+	for _, s := range segments {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(s.number))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(s.size))
+		buf = append(buf, s.checksum...)
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(c.index.Len()))
+	c.index.Range(0, func(m Msg) bool {
+		buf = binary.LittleEndian.AppendUint64(buf, m.Idx)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(m.seg))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(m.off))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(m.size))
+
+		var payload []byte
+		if !m.onDisk {
+			if payload, err = msgpack.Marshal(m); err != nil {
+				return false
+			}
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+		buf = append(buf, payload...)
+
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode msg")
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.filters)))
+	for seg, filter := range c.filters {
+		data := filter.encode()
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(seg))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+		buf = append(buf, data...)
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.keys)))
+	for key, idx := range c.keys {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+		buf = append(buf, key...)
+		buf = binary.LittleEndian.AppendUint64(buf, idx)
+	}
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	snapshotPath := path.Join(c.pathToLogsDir, c.prefix+snapshotPostfix)
+	tmp := snapshotPath + ".tmp"
+	if err := os.WriteFile(tmp, buf, c.fileMode); err != nil {
+		return errors.Wrap(err, "failed to write index snapshot")
+	}
+
+	if err := os.Rename(tmp, snapshotPath); err != nil {
+		return errors.Wrap(err, "failed to replace index snapshot")
+	}
+
+	return nil
+}
+
+// describeSegments returns sizes and checksums of the segments.
+func describeSegments(basePath string, segmentsNumbers []int) ([]snapshotSegment, error) {
+	segments := make([]snapshotSegment, 0, len(segmentsNumbers))
+	for _, n := range segmentsNumbers {
+		segmentPath := segmentName(basePath, n)
+		stat, err := os.Stat(segmentPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to stat log segment file")
+		}
+
+		sum, err := os.ReadFile(segmentPath + checkSumPostfix)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read segment checksum")
+		}
+
+		if len(sum) != sha256.Size {
+			return nil, errors.Errorf("bad checksum of segment %s", segmentPath)
+		}
+
+		segments = append(segments, snapshotSegment{number: n, size: stat.Size(), checksum: sum})
+	}
+
+	return segments, nil
+}
+
+// snapshotReader reads fields of the snapshot, remembering the first error.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || len(r.data) < n {
+		r.err = errors.Wrap(errBadSnapshot, "snapshot is too short")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+
+	return b
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// readSnapshot loads the index, key filters and key index from the snapshot file.
+// It fails if the snapshot is missing, corrupted, written by a WAL with another ID or doesn't match the segments.
+func readSnapshot(dir, prefix string, id [16]byte, segmentsNumbers []int) (map[uint64]Msg, map[int]*keyFilter, map[string]uint64, error) {
+	data, err := os.ReadFile(path.Join(dir, prefix+snapshotPostfix))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(data) < len(id)+4 {
+		return nil, nil, nil, errors.Wrap(errBadSnapshot, "snapshot is too short")
+	}
+
+	body, crc := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != crc {
+		return nil, nil, nil, errors.Wrap(errBadSnapshot, "checksums do not match")
+	}
+
+	r := &snapshotReader{data: body}
+	if !bytes.Equal(r.next(len(id)), id[:]) {
+		return nil, nil, nil, errors.Wrap(errBadSnapshot, "snapshot was written by another WAL")
+	}
+
+	segments, err := describeSegments(path.Join(dir, prefix), segmentsNumbers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if int(r.uint32()) != len(segments) {
+		return nil, nil, nil, errors.Wrap(errBadSnapshot, "segments were changed after snapshot was written")
+	}
+	for _, s := range segments {
+		if int(r.uint32()) != s.number || int64(r.uint64()) != s.size || !bytes.Equal(r.next(sha256.Size), s.checksum) {
+			return nil, nil, nil, errors.Wrap(errBadSnapshot, "segments were changed after snapshot was written")
+		}
+	}
+
+	count := int(r.uint32())
+	index := make(map[uint64]Msg, min(count, len(r.data)))
+	for i := 0; i < count && r.err == nil; i++ {
+		pos := Msg{Idx: r.uint64(), seg: int(r.uint32()), off: int64(r.uint64()), size: int(r.uint32()), onDisk: true}
+
+		payload := r.next(int(r.uint32()))
+		if len(payload) == 0 {
+			index[pos.Idx] = pos
+			continue
+		}
+
+		var m Msg
+		if err := msgpack.Unmarshal(payload, &m); err != nil {
+			return nil, nil, nil, errors.Wrap(errBadSnapshot, err.Error())
+		}
+		m.seg, m.off, m.size = pos.seg, pos.off, pos.size
+		m.sum = m.checksum()
+		index[m.Idx] = m
+	}
+
+	count = int(r.uint32())
+	filters := make(map[int]*keyFilter, min(count, len(r.data)))
+	for i := 0; i < count && r.err == nil; i++ {
+		seg := int(r.uint32())
+		filterData := r.next(int(r.uint32()))
+		if r.err != nil {
+			break
+		}
+
+		filter, err := decodeKeyFilter(filterData)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(errBadSnapshot, err.Error())
+		}
+		filters[seg] = filter
+	}
+
+	count = int(r.uint32())
+	keys := make(map[string]uint64, min(count, len(r.data)))
+	for i := 0; i < count && r.err == nil; i++ {
+		key := string(r.next(int(r.uint32())))
+		keys[key] = r.uint64()
+	}
+
+	if r.err != nil {
+		return nil, nil, nil, r.err
+	}
+
+	if len(r.data) != 0 {
+		return nil, nil, nil, errors.Wrap(errBadSnapshot, "unexpected data at the end of snapshot")
+	}
+
+	return index, filters, keys, nil
+}
+
+// openActiveSegment opens the newest segment and its checksum file (creating them with the given mode if needed)
+// for writing without decoding the segment.
+func openActiveSegment(segmentPath string, mode os.FileMode) (*os.File, *os.File, int64, error) {
+	fd, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, "failed to open log segment file")
+	}
+
+	chk, err := os.OpenFile(segmentPath+checkSumPostfix, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		fd.Close()
+		return nil, nil, 0, errors.Wrap(err, "failed to cheksum file")
+	}
+
+	lastOffset, err := calculateLastOffset(fd)
+	if err != nil {
+		fd.Close()
+		chk.Close()
+		return nil, nil, 0, errors.Wrap(err, "failed to calculate last offset")
+	}
+
+	return fd, chk, lastOffset, nil
+}
@@ -0,0 +1,254 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"sync"
+)
+
+// defaultSubscriberBuffer is used when Config.SubscriberBuffer is not set.
+const defaultSubscriberBuffer = 1 << 16
+
+// ErrSlowSubscriber is returned by cancel func of the subscription closed because the subscriber
+// didn't keep up with writes, see Config.SubscriberOverflowPolicy.
+var ErrSlowSubscriber = errors.New("subscriber fell behind the log")
+
+// SubscriberOverflowPolicy defines what happens when a subscriber doesn't keep up with writes
+// and its queue of undelivered msgs is full, see Config.SubscriberBuffer.
+type SubscriberOverflowPolicy int
+
+const (
+	// CloseSubscriber closes the channel of the subscriber, its cancel func returns ErrSlowSubscriber.
+	CloseSubscriber SubscriberOverflowPolicy = iota
+
+	// DropMsgs drops new msgs until the subscriber catches up, so it sees gaps in indexes.
+	DropMsgs
+)
+
+func (p SubscriberOverflowPolicy) String() string {
+	switch p {
+	case CloseSubscriber:
+		return "close subscriber"
+	case DropMsgs:
+		return "drop msgs"
+	default:
+		return fmt.Sprintf("SubscriberOverflowPolicy(%d)", int(p))
+	}
+}
+
+// subscription delivers messages to a single subscriber.
+//
+// Write never blocks on slow subscribers: new messages are queued in pending (up to limit msgs)
+// and delivered to the subscriber channel by a dedicated goroutine.
+type subscription struct {
+	from     uint64
+	limit    int
+	overflow SubscriberOverflowPolicy
+
+	mu      sync.Mutex
+	pending []Msg
+	err     error
+
+	// indexes of msgs written while existing msgs are replayed, so they are not delivered twice
+	replaying bool
+	late      map[uint64]struct{}
+
+	// notify signals that pending is not empty
+	notify chan struct{}
+
+	// done is closed when subscription is cancelled
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe returns channel with all messages starting from fromIndex: existing messages are replayed first
+// (from the oldest to the newest), then every new message written to the log is pushed to the channel.
+// Writes don't wait for subscribers, up to Config.SubscriberBuffer msgs are queued for every subscriber,
+// Config.SubscriberOverflowPolicy defines what happens to subscribers falling further behind.
+//
+// Call cancel func to unsubscribe, the channel is closed after that. Channels of all subscriptions
+// are also closed by Wal.Close. Cancel func returns ErrSlowSubscriber if the subscription was closed
+// because the subscriber fell behind.
+//
+// Should be used like this:
+//
+//	ch, cancel := wal.Subscribe(0)
+//	defer cancel()
+//	for msg := range ch {
+//		...
+func (c *Wal) Subscribe(fromIndex uint64) (<-chan Msg, func() error) {
+	s := &subscription{from: fromIndex, limit: c.subscriberBuffer, overflow: c.subscriberOverflow,
+		replaying: true, late: make(map[uint64]struct{}), notify: make(chan struct{}, 1), done: make(chan struct{})}
+
+	out := make(chan Msg)
+
+	// subscriptions were already closed by Close, so the channel is closed right away
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		close(out)
+		return out, func() error { return nil }
+	}
+	c.subscriptions[s] = struct{}{}
+	c.mu.Unlock()
+
+	go s.serve(c, out)
+
+	cancel := func() error {
+		c.mu.Lock()
+		delete(c.subscriptions, s)
+		c.mu.Unlock()
+
+		s.close()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.err
+	}
+
+	return out, cancel
+}
+
+// publish pushes msg to all subscriptions. The caller must hold the lock.
+func (c *Wal) publish(m Msg) {
+	for s := range c.subscriptions {
+		if !s.push(m) {
+			delete(c.subscriptions, s)
+		}
+	}
+}
+
+// closeSubscriptions cancels all subscriptions.
+func (c *Wal) closeSubscriptions() {
+	for s := range c.subscriptions {
+		s.close()
+		delete(c.subscriptions, s)
+	}
+}
+
+// push queues msg for delivery, it returns false if the subscription is closed due to the overflow.
+func (s *subscription) push(m Msg) bool {
+	if m.Idx < s.from {
+		return true
+	}
+
+	s.mu.Lock()
+	if len(s.pending) >= s.limit {
+		if s.overflow == DropMsgs {
+			s.mu.Unlock()
+			return true
+		}
+
+		s.err = ErrSlowSubscriber
+		s.mu.Unlock()
+		s.close()
+
+		return false
+	}
+
+	s.pending = append(s.pending, m)
+	if s.replaying {
+		s.late[m.Idx] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func (s *subscription) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// serve replays existing messages and then sends all pending messages to out until subscription is closed.
+func (s *subscription) serve(c *Wal, out chan<- Msg) {
+	defer close(out)
+
+	if !s.replay(c, out) {
+		return
+	}
+
+	for {
+		s.mu.Lock()
+		batch := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+
+		for _, m := range batch {
+			select {
+			case out <- m:
+			case <-s.done:
+				return
+			}
+		}
+
+		if len(batch) > 0 {
+			continue
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// replay sends messages existing at the moment of subscription to out, reading them one by one
+// without holding the lock for the whole backlog. Messages written after the subscription are left
+// to pending. It returns false if the subscription or the WAL was closed.
+func (s *subscription) replay(c *Wal, out chan<- Msg) bool {
+	defer func() {
+		s.mu.Lock()
+		s.replaying, s.late = false, nil
+		s.mu.Unlock()
+	}()
+
+	for from := s.from; ; {
+		c.mu.RLock()
+		// segment files are not reopened after Close
+		if c.closed {
+			c.mu.RUnlock()
+			return false
+		}
+		indexed, ok := c.seek(from)
+		var (
+			m   Msg
+			err error
+		)
+		if ok {
+			m, err = c.materialize(indexed)
+		}
+		c.mu.RUnlock()
+
+		if !ok {
+			return true
+		}
+
+		s.mu.Lock()
+		_, late := s.late[indexed.Idx]
+		s.mu.Unlock()
+
+		// msgs that can't be read from disk are counted in Stats and skipped
+		if err == nil && !late {
+			select {
+			case out <- m:
+			case <-s.done:
+				return false
+			}
+		}
+
+		if indexed.Idx == math.MaxUint64 {
+			return true
+		}
+		from = indexed.Idx + 1
+	}
+}
@@ -0,0 +1,136 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	ch, cancel := log.Subscribe(2)
+
+	errCh := make(chan error, 1)
+	go func() {
+		for i := 5; i < 10; i++ {
+			if err := log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+
+	for i := 2; i < 10; i++ {
+		m := <-ch
+		require.Equal(t, uint64(i), m.Idx)
+		require.Equal(t, "key"+strconv.Itoa(i), m.Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+	}
+
+	require.NoError(t, <-errCh)
+
+	cancel()
+	_, ok := <-ch
+	require.False(t, ok)
+
+	// subscriptions are closed along with the log
+	ch, _ = log.Subscribe(100)
+	require.NoError(t, log.Close())
+	_, ok = <-ch
+	require.False(t, ok)
+
+	// and channels of subscriptions made after Close are closed right away
+	ch, cancel = log.Subscribe(0)
+	_, ok = <-ch
+	require.False(t, ok)
+	require.NoError(t, cancel())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+// waitReplayed writes msg 0 and waits for it, so existing msgs are replayed and new ones are queued.
+func waitReplayed(t *testing.T, log *Wal, ch <-chan Msg) {
+	require.NoError(t, log.Write(0, "key0", []byte("value0")))
+	require.Equal(t, uint64(0), (<-ch).Idx)
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 100,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		SubscriberBuffer: 2,
+	}
+
+	t.Run("slow subscriber is closed", func(t *testing.T) {
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+
+		ch, cancel := log.Subscribe(0)
+		waitReplayed(t, log, ch)
+		for i := 1; i < 11; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+
+		var received int
+		for range ch {
+			received++
+		}
+		require.Less(t, received, 10)
+		require.ErrorIs(t, cancel(), ErrSlowSubscriber)
+
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("msgs are dropped", func(t *testing.T) {
+		cfg := cfg
+		cfg.SubscriberOverflowPolicy = DropMsgs
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+
+		ch, cancel := log.Subscribe(0)
+		waitReplayed(t, log, ch)
+		for i := 1; i < 11; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+
+		// the subscriber gets msgs queued before the overflow
+		var received int
+		for drained := false; !drained; {
+			select {
+			case <-ch:
+				received++
+			case <-time.After(50 * time.Millisecond):
+				drained = true
+			}
+		}
+		require.Less(t, received, 10)
+
+		// and new msgs once it caught up
+		require.NoError(t, log.Write(100, "key100", []byte("value100")))
+		m := <-ch
+		require.Equal(t, uint64(100), m.Idx)
+		require.NoError(t, cancel())
+
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+}
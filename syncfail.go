@@ -0,0 +1,57 @@
+package gowal
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// ErrWALFailed is returned by writes after syncing to disk failed and SyncFailurePolicy is FailStop.
+// The WAL must be closed and opened again with NewWAL, which reads back what actually reached the disk.
+var ErrWALFailed = errors.New("wal failed after sync error, reopen it")
+
+// SyncFailurePolicy defines what happens when syncing segments to disk fails, see Config.SyncFailurePolicy.
+//
+// After a failed fsync the kernel may drop the dirty pages and report success for the next fsync,
+// so msgs written before the failure may be silently lost if writes continue.
+type SyncFailurePolicy int
+
+const (
+	// ReturnSyncError returns the error to the caller, the WAL keeps accepting writes.
+	// The msg the failed write returns the error for is written and indexed, but may be lost on crash,
+	// writing it again fails with ErrExists.
+	ReturnSyncError SyncFailurePolicy = iota
+
+	// FailStop makes all subsequent writes fail with ErrWALFailed until the WAL is reopened.
+	// Reads keep working.
+	FailStop
+
+	// PanicOnSyncError panics, so the process restarts and recovers from what is on disk.
+	PanicOnSyncError
+)
+
+func (p SyncFailurePolicy) String() string {
+	switch p {
+	case ReturnSyncError:
+		return "return sync error"
+	case FailStop:
+		return "fail stop"
+	case PanicOnSyncError:
+		return "panic on sync error"
+	default:
+		return fmt.Sprintf("SyncFailurePolicy(%d)", int(p))
+	}
+}
+
+// syncFailed handles the sync error according to SyncFailurePolicy and returns the error to report.
+// The caller must hold the lock.
+func (c *Wal) syncFailed(err error) error {
+	switch c.syncFailurePolicy {
+	case FailStop:
+		c.loadErr = errors.Wrapf(ErrWALFailed, "%v", err)
+		return c.loadErr
+	case PanicOnSyncError:
+		panic(err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,114 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSyncFailurePolicy(t *testing.T) {
+	// open writes msgs and swaps the active segment file for a closed one, so syncing it fails,
+	// the returned function puts the segment file back
+	open := func(t *testing.T, policy SyncFailurePolicy) (*Wal, func()) {
+		log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, SyncFailurePolicy: policy})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+
+		f, err := os.Open(log.log.Name())
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		active := log.log
+		log.log = f
+
+		return log, func() { log.log = active }
+	}
+
+	t.Run("ReturnSyncError", func(t *testing.T) {
+		log, restore := open(t, ReturnSyncError)
+		err := log.sync()
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrWALFailed)
+
+		restore()
+		require.NoError(t, log.Write(2, "key2", []byte("value2")))
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("ReturnSyncError in Write", func(t *testing.T) {
+		cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 10, MaxSegments: 10,
+			IsInSyncDiskMode: true, OffsetOnlyIndex: true}
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+
+		// the frame is written, but syncing the checksum file fails
+		f, err := os.Open(log.checksum.Name())
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		checksum := log.checksum
+		log.checksum = f
+
+		err = log.Write(2, "key2", []byte("value2"))
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrWALFailed)
+		log.checksum = checksum
+
+		// the msg is indexed, so later msgs are indexed at their own offsets
+		require.ErrorIs(t, log.Write(2, "key2", []byte("value2")), ErrExists)
+		require.NoError(t, log.Write(3, "key3", []byte("value3")))
+		for i := 0; i < 4; i++ {
+			m, err := log.GetMsg(uint64(i))
+			require.NoError(t, err)
+			require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+		}
+		require.Zero(t, log.Stats().DiskCorruptions)
+		require.NoError(t, log.Close())
+
+		log, err = NewWAL(cfg)
+		require.NoError(t, err)
+		require.Equal(t, 4, log.Stats().Records)
+		require.Empty(t, log.Stats().DuplicateIndexes)
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("FailStop", func(t *testing.T) {
+		log, restore := open(t, FailStop)
+		require.ErrorIs(t, log.sync(), ErrWALFailed)
+
+		// writes fail until the WAL is reopened, reads keep working
+		require.ErrorIs(t, log.Write(2, "key2", []byte("value2")), ErrWALFailed)
+		require.ErrorIs(t, log.WriteCheckpoint(nil), ErrWALFailed)
+		_, value, ok := log.Get(1)
+		require.True(t, ok)
+		require.Equal(t, "value1", string(value))
+
+		restore()
+		require.ErrorIs(t, log.Write(2, "key2", []byte("value2")), ErrWALFailed)
+		require.NoError(t, log.Close())
+
+		log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10, SyncFailurePolicy: FailStop})
+		require.NoError(t, err)
+		require.NoError(t, log.Write(2, "key2", []byte("value2")))
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	t.Run("PanicOnSyncError", func(t *testing.T) {
+		log, restore := open(t, PanicOnSyncError)
+		require.Panics(t, func() { log.sync() })
+		restore()
+		require.NoError(t, log.Close())
+		require.NoError(t, os.RemoveAll("./testlogdata"))
+	})
+
+	_, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SyncFailurePolicy: PanicOnSyncError + 1})
+	require.Error(t, err)
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,106 @@
+package gowal
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"github.com/vadiminshakov/gowal/frame"
+	"io"
+	"os"
+)
+
+// truncateTornTail truncates the active segment back to the end of its last valid frame if the segment
+// doesn't match its checksum, e.g. because the process crashed in the middle of a write, and updates the checksum.
+// It returns the number of bytes truncated. Frames after the first invalid one are dropped as well,
+// because the length of the invalid frame can't be trusted.
+func truncateTornTail(segmentPath string, codec Codec) (int64, error) {
+	f, err := os.OpenFile(segmentPath, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	chk, err := os.OpenFile(segmentPath+checkSumPostfix, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "failed to open checksum file")
+	}
+	defer chk.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to stat segment")
+	}
+
+	statChk, err := chk.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to stat checksum file")
+	}
+
+	if stat.Size() == 0 || statChk.Size() == 0 || compareChecksums(f, chk) == nil {
+		return 0, nil
+	}
+
+	if isCompressed(f) {
+		return 0, errors.New("compressed segment can't be truncated")
+	}
+
+	if isLegacySegment(segmentPath) {
+		return 0, errors.Wrap(ErrLegacySegment, "legacy segment can't be truncated")
+	}
+
+	end, _ := validPrefix(f, stat.Size(), codec)
+
+	if end < stat.Size() {
+		if err := f.Truncate(end); err != nil {
+			return 0, errors.Wrap(err, "failed to truncate segment")
+		}
+	}
+
+	if err := writeChecksum(f, chk); err != nil {
+		return 0, errors.Wrap(err, "failed to write checksum")
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, errors.Wrap(err, "failed to sync segment")
+	}
+
+	if err := chk.Sync(); err != nil {
+		return 0, errors.Wrap(err, "failed to sync checksum file")
+	}
+
+	return stat.Size() - end, nil
+}
+
+// validPrefix returns the length of the segment content up to the end of its last valid frame
+// (0 if even the segment header is not valid) along with metadata of msgs stored before it.
+// Frames are validated by their lengths and checksums only, so content written with another codec
+// is never dropped, payloads the codec can't decode are just not accounted in the metadata.
+func validPrefix(content io.ReaderAt, size int64, codec Codec) (int64, segmentMeta) {
+	var meta segmentMeta
+
+	r := bufio.NewReader(io.NewSectionReader(content, 0, size))
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		return 0, meta
+	}
+
+	offset := int64(header.size)
+	for {
+		payload, n, err := frame.Read(r)
+		if err != nil {
+			return offset, meta
+		}
+
+		if !isFooter(payload) {
+			if m, err := decodePayload(payload, codec); err == nil {
+				meta.add(m)
+			}
+		}
+		offset += int64(n)
+	}
+}
@@ -0,0 +1,63 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTornTail(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 10, MaxSegments: 5}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	segmentPath := log.segmentPath(log.activeSegment)
+	require.NoError(t, log.Close())
+
+	appendData := func(data []byte) {
+		f, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	// the frame was written, but the checksum wasn't updated
+	data, err := encodeFrame(Msg{Key: "key5", Value: []byte("value5"), Idx: 5, Timestamp: time.Now().Round(0)}, defaultCodec, CRC32IEEE, false)
+	require.NoError(t, err)
+	appendData(data)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 6, log.Len())
+	require.Zero(t, log.Stats().TornTailBytes)
+	require.NoError(t, log.Close())
+
+	// the frame was written partially
+	data, err = encodeFrame(Msg{Key: "key6", Value: []byte("value6"), Idx: 6, Timestamp: time.Now().Round(0)}, defaultCodec, CRC32IEEE, false)
+	require.NoError(t, err)
+	appendData(data[:len(data)/2])
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 6, log.Len())
+	require.Equal(t, int64(len(data)/2), log.Stats().TornTailBytes)
+
+	require.NoError(t, log.Write(6, "key6", []byte("value6")))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 7, log.Len())
+	_, value, ok := log.Get(6)
+	require.True(t, ok)
+	require.Equal(t, "value6", string(value))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -0,0 +1,113 @@
+package gowal
+
+import (
+	"os"
+	"slices"
+)
+
+// initTiers keeps whole msgs in memory only for the newest segments and switches other segments
+// to offset-only index, see Config.HotSegments. The caller must hold the lock.
+func (c *Wal) initTiers() {
+	if c.hotSegments <= 0 {
+		return
+	}
+
+	n := max(0, len(c.segments)-c.hotSegments)
+	c.hot = slices.Clone(c.segments[n:])
+	for _, seg := range c.segments[:n] {
+		c.demoteSegment(seg)
+	}
+}
+
+// demoteSegment moves the segment to the cold tier, keeping only positions of its msgs in memory.
+// The caller must hold the lock.
+func (c *Wal) demoteSegment(seg int) {
+	c.hot = slices.DeleteFunc(c.hot, func(s int) bool { return s == seg })
+	c.coldSegments[seg] = struct{}{}
+	c.stripSegment(seg)
+}
+
+// makeHot moves the segment to the hot tier and demotes segments that became hot earliest
+// (except the active one) if there are too many hot segments. The caller must hold the lock.
+func (c *Wal) makeHot(seg int) {
+	if c.hotSegments <= 0 {
+		return
+	}
+
+	c.hot = append(slices.DeleteFunc(c.hot, func(s int) bool { return s == seg }), seg)
+	for i := 0; len(c.hot) > c.hotSegments && i < len(c.hot); {
+		if c.hot[i] == c.activeSegment {
+			i++
+			continue
+		}
+		c.demoteSegment(c.hot[i])
+	}
+}
+
+// forgetTier drops the removed segment from tiers. The caller must hold the lock.
+func (c *Wal) forgetTier(seg int) {
+	c.hot = slices.DeleteFunc(c.hot, func(s int) bool { return s == seg })
+	delete(c.coldSegments, seg)
+}
+
+// requestPromotion promotes the cold segment to the hot tier in the background,
+// because msgs of the segment were read. The caller must hold the lock (read lock is enough).
+func (c *Wal) requestPromotion(seg int) {
+	if _, cold := c.coldSegments[seg]; !cold {
+		return
+	}
+
+	c.promotionsMu.Lock()
+	defer c.promotionsMu.Unlock()
+
+	if _, pending := c.promotions[seg]; pending {
+		return
+	}
+	c.promotions[seg] = struct{}{}
+
+	go c.promoteSegment(seg)
+}
+
+// promoteSegment reads whole msgs of the cold segment into memory and moves it to the hot tier.
+// Promotion is an optimization, so the segment stays cold if it can't be read.
+func (c *Wal) promoteSegment(seg int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defer func() {
+		c.promotionsMu.Lock()
+		delete(c.promotions, seg)
+		c.promotionsMu.Unlock()
+	}()
+
+	if _, cold := c.coldSegments[seg]; !cold || c.closed {
+		return
+	}
+
+	f, err := os.Open(c.segmentPath(seg))
+	if err != nil {
+		return
+	}
+	msgs, err := loadIndexes(f, c.codec)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	delete(c.coldSegments, seg)
+	delete(c.offsetOnlySegments, seg)
+	for _, m := range msgs {
+		pos, ok := c.index.Get(m.Idx)
+		if !ok || !pos.onDisk || pos.seg != seg || pos.off != m.off {
+			continue
+		}
+
+		m.seg = seg
+		m = c.compact(m)
+		c.index.Put(m)
+		c.addResident(m, 1)
+	}
+
+	c.makeHot(seg)
+	c.enforceIndexBudget()
+}
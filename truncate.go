@@ -0,0 +1,175 @@
+package gowal
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"slices"
+	"time"
+)
+
+// TruncateBefore removes all msgs with indexes less than index, e.g. after the state they describe
+// was snapshotted. Segments holding only such msgs are removed, segments holding them along with newer msgs
+// are rewritten without them. If the active segment holds such msgs, it is sealed first.
+func (c *Wal) TruncateBefore(index uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	// sealed segments are truncated first, so there is a free segment to seal the active one
+	// if writes wait for segments to be removed (see Config.EvictionPolicy)
+	if err := c.truncateSealedBefore(index); err != nil {
+		return err
+	}
+
+	if meta := c.metas[c.activeSegment]; meta.records > 0 && meta.first < index {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+
+		return c.truncateSealedBefore(index)
+	}
+
+	return nil
+}
+
+// truncateSealedBefore removes msgs with indexes less than index from sealed segments. The caller must hold the lock.
+func (c *Wal) truncateSealedBefore(index uint64) error {
+	for _, seg := range c.sealedSegments() {
+		meta := c.metas[seg]
+		switch {
+		case meta.records == 0 || meta.first >= index:
+		case meta.last < index:
+			if err := c.removeSegment(seg); err != nil {
+				return err
+			}
+		default:
+			if err := c.rewriteSegment(seg, func(m Msg) bool { return m.Idx >= index }); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// TruncateAfter removes all msgs with indexes greater than index, e.g. to roll back uncommitted msgs
+// after a leadership change. Such msgs must be the last msgs written to the log: segments written after
+// the first of them are removed and its segment is truncated right before it and becomes the active segment.
+// Checkpoints covering removed msgs are removed as well.
+func (c *Wal) TruncateAfter(index uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	if index == math.MaxUint64 {
+		return nil
+	}
+
+	var (
+		removed []uint64
+		cut     Msg
+	)
+	c.index.Range(index+1, func(m Msg) bool {
+		if len(removed) == 0 || m.seg < cut.seg || (m.seg == cut.seg && m.off < cut.off) {
+			cut = m
+		}
+		removed = append(removed, m.Idx)
+		return true
+	})
+	if len(removed) == 0 {
+		return nil
+	}
+
+	var kept error
+	c.index.Range(0, func(m Msg) bool {
+		if m.Idx > index {
+			return false
+		}
+		if m.seg > cut.seg || (m.seg == cut.seg && m.off > cut.off) {
+			kept = errors.Errorf("msg %d is written after msg %d, so the log can't be truncated after %d", m.Idx, cut.Idx, index)
+			return false
+		}
+		return true
+	})
+	if kept != nil {
+		return kept
+	}
+
+	if err := c.log.Close(); err != nil {
+		return errors.Wrap(err, "failed to close log file")
+	}
+	if err := c.checksum.Close(); err != nil {
+		return errors.Wrap(err, "failed to close checksum file")
+	}
+
+	for _, seg := range slices.Clone(c.segments) {
+		if seg > cut.seg {
+			if err := c.removeSegment(seg); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, idx := range removed {
+		c.index.Delete(idx)
+	}
+
+	// mapping of the segment must not outlive the truncated part of the file
+	c.closeSegmentFile(cut.seg)
+	segmentPath := c.segmentPath(cut.seg)
+	if err := decompressSegmentFile(segmentPath, c.fileMode); err != nil {
+		return err
+	}
+	if err := os.Truncate(segmentPath, cut.off); err != nil {
+		return errors.Wrap(err, "failed to truncate segment")
+	}
+	if err := os.Remove(segmentPath + sidecarPostfix); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove segment index sidecar")
+	}
+
+	fd, chk, lastOffset, err := openActiveSegment(segmentPath, c.fileMode)
+	if err != nil {
+		return err
+	}
+	c.log, c.checksum, c.lastOffset, c.activeSegment = fd, chk, lastOffset, cut.seg
+	if c.segmentCRC, err = segmentFileCRC(segmentPath); err != nil {
+		return err
+	}
+	if err := writeCurrentSegment(c.pathToLogsDir, c.prefix, c.activeSegment, c.fileMode); err != nil {
+		return err
+	}
+
+	if err := writeChecksum(c.log, c.checksum); err != nil {
+		return errors.Wrap(err, "failed to write checksum")
+	}
+	if err := c.sync(); err != nil {
+		return err
+	}
+
+	meta := segmentMeta{}
+	c.index.Range(0, func(m Msg) bool {
+		if m.seg == cut.seg {
+			meta.add(m)
+		}
+		return true
+	})
+	c.metas[cut.seg] = meta
+	c.activeSince = time.Now()
+	if c.cache != nil {
+		c.cache.evictSegment(cut.seg)
+	}
+	c.makeHot(cut.seg)
+	c.reindex()
+
+	last, _ := c.seekReverse(index)
+	c.lastIndex.Store(last.Idx)
+
+	return c.dropCheckpointsAfter(index)
+}
@@ -0,0 +1,101 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestTruncateBefore(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// segment 0 is removed, segment 1 is rewritten
+	require.NoError(t, log.TruncateBefore(4))
+	first, ok := log.FirstIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(4), first)
+	require.Equal(t, 4, log.Len())
+	require.False(t, log.Exists(3))
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Equal(t, 1, segments[0].Number)
+	require.Equal(t, 2, segments[0].Records)
+
+	// the active segment is sealed first
+	require.NoError(t, log.TruncateBefore(7))
+	require.Equal(t, 1, log.Len())
+	_, value, ok := log.Get(7)
+	require.True(t, ok)
+	require.Equal(t, "value7", string(value))
+
+	require.NoError(t, log.Write(8, "key8", []byte("value8")))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 2, log.Len())
+	first, ok = log.FirstIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(7), first)
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestTruncateAfter(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// segment 2 is removed, segment 1 is truncated and becomes the active one
+	require.NoError(t, log.TruncateAfter(3))
+	require.Equal(t, uint64(3), log.CurrentIndex())
+	require.Equal(t, 4, log.Len())
+	require.False(t, log.Exists(4))
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, 1, segments[1].Number)
+	require.Equal(t, 1, segments[1].Records)
+
+	// rolled back indexes and keys can be written again
+	require.NoError(t, log.Write(4, "key4", []byte("rewritten4")))
+	require.NoError(t, log.TruncateAfter(10))
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 5, log.Len())
+	require.Equal(t, uint64(4), log.CurrentIndex())
+	_, value, ok := log.Get(4)
+	require.True(t, ok)
+	require.Equal(t, "rewritten4", string(value))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
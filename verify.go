@@ -0,0 +1,174 @@
+package gowal
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+)
+
+// VerifyReport describes problems found by Verify.
+type VerifyReport struct {
+	// Segments and Records are the numbers of segments and records checked.
+	Segments int
+	Records  int
+
+	// ChecksumMismatches are numbers of segments which files don't match their checksum files.
+	ChecksumMismatches []int
+
+	// Corrupted are records (or segment headers) that can't be read. The length of the corrupted record
+	// can't be trusted, so checking continues from the next valid record found by its header checksum;
+	// records written by older versions have no header checksum, so the rest of the segment is not checked then.
+	Corrupted []CorruptedRecord
+
+	// Duplicates are indexes stored more than once.
+	Duplicates []DuplicateIndex
+
+	// Gaps are ranges of indexes missing between the lowest and the highest stored indexes, see also Wal.Gaps.
+	Gaps []IndexRange
+}
+
+// OK reports whether no problems were found.
+func (r VerifyReport) OK() bool {
+	return len(r.ChecksumMismatches) == 0 && len(r.Corrupted) == 0 && len(r.Duplicates) == 0 && len(r.Gaps) == 0
+}
+
+// CorruptedRecord is the position of the record (or the segment header, if Offset is 0) that can't be read.
+type CorruptedRecord struct {
+	Segment int
+	Offset  int64
+	Err     error
+}
+
+// DuplicateIndex is the index stored more than once and numbers of segments storing it (once per record).
+type DuplicateIndex struct {
+	Index    uint64
+	Segments []int
+}
+
+// IndexRange is the range of indexes, From and To are included.
+type IndexRange struct {
+	From uint64
+	To   uint64
+}
+
+// Verify checks all segments of the WAL in dir without changing anything, like fsck: segment files are compared
+// with their checksum files, every record is read and its checksum is verified, and indexes are checked to be
+// unique and contiguous. Use SafeRecover or UnsafeRecover to repair the problems found. The WAL may be open.
+func Verify(dir, prefix string) (VerifyReport, error) {
+	// segments written by older versions are not zero-padded, they are checked under the names found
+	segmentsNumbers, names, err := listSegmentFiles(dir, prefix)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	codec, err := walCodec(dir, prefix)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	return verifySegments(segmentsNumbers, func(n int) string { return names[n] }, codec)
+}
+
+// Verify checks all segments of the WAL without changing anything, see Verify. Writes wait until it's done.
+func (c *Wal) Verify() (VerifyReport, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.loadErr != nil {
+		return VerifyReport{}, c.loadErr
+	}
+
+	return verifySegments(c.segments, c.segmentPath, c.codec)
+}
+
+// verifySegments checks the segments with the given numbers, segmentPath returns path to the segment file by number.
+func verifySegments(segmentsNumbers []int, segmentPath func(int) string, codec Codec) (VerifyReport, error) {
+	var report VerifyReport
+	stored := make(map[uint64][]int)
+	for _, n := range segmentsNumbers {
+		msgs, err := verifySegment(n, segmentPath(n), &report, codec)
+		if err != nil {
+			return report, errors.Wrapf(err, "failed to verify segment %s", segmentPath(n))
+		}
+
+		for _, m := range msgs {
+			stored[m.Idx] = append(stored[m.Idx], n)
+		}
+		report.Segments++
+		report.Records += len(msgs)
+	}
+
+	indexes := slices.Sorted(maps.Keys(stored))
+	for i, idx := range indexes {
+		if segments := stored[idx]; len(segments) > 1 {
+			report.Duplicates = append(report.Duplicates, DuplicateIndex{Index: idx, Segments: segments})
+		}
+
+		if i > 0 && idx > indexes[i-1]+1 {
+			report.Gaps = append(report.Gaps, IndexRange{From: indexes[i-1] + 1, To: idx - 1})
+		}
+	}
+
+	return report, nil
+}
+
+// verifySegment reads msgs of the segment skipping corrupted records, adding problems found to the report.
+// It fails only if the segment can't be read at all.
+func verifySegment(n int, segmentPath string, report *VerifyReport, codec Codec) ([]Msg, error) {
+	f, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open log segment file")
+	}
+	defer f.Close()
+
+	chk, err := os.Open(segmentPath + checkSumPostfix)
+	if err == nil {
+		err = compareChecksums(f, chk)
+		chk.Close()
+	}
+	if err != nil {
+		report.ChecksumMismatches = append(report.ChecksumMismatches, n)
+	}
+
+	content, err := openSegmentContent(f)
+	if err != nil {
+		report.Corrupted = append(report.Corrupted, CorruptedRecord{Segment: n, Err: err})
+		return nil, nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(content, 0, content.Size()))
+	header, err := readSegmentHeader(r)
+	if err != nil {
+		report.Corrupted = append(report.Corrupted, CorruptedRecord{Segment: n, Err: err})
+		return nil, nil
+	}
+
+	var msgs []Msg
+	for offset := int64(header.size); ; {
+		m, size, err := readFrame(r, codec)
+		if err == errFooter {
+			offset += int64(size)
+			continue
+		}
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			report.Corrupted = append(report.Corrupted, CorruptedRecord{Segment: n, Offset: offset, Err: err})
+
+			next, ok, err := resync(content, offset, content.Size())
+			if err != nil || !ok {
+				return msgs, err
+			}
+			offset = next
+			r = bufio.NewReader(io.NewSectionReader(content, offset, content.Size()-offset))
+			continue
+		}
+
+		msgs = append(msgs, m)
+		offset += int64(size)
+	}
+}
@@ -0,0 +1,68 @@
+package gowal
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 3, MaxSegments: 10}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 9, 10} {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	report, err := log.Verify()
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Segments)
+	require.Equal(t, 9, report.Records)
+	require.Equal(t, []IndexRange{{From: 7, To: 8}}, report.Gaps)
+	require.False(t, report.OK())
+
+	require.NoError(t, log.Write(7, "key7", []byte("value7")))
+	require.NoError(t, log.Write(8, "key8", []byte("value8")))
+	report, err = log.Verify()
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	// msg dropped from the index is stored again
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	log.mu.Lock()
+	log.index.Delete(1)
+	log.mu.Unlock()
+	require.NoError(t, log.Write(1, "key1", []byte("value1")))
+	require.NoError(t, log.Close())
+
+	// corrupt the second msg of the second segment
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	before, err := os.ReadFile(log.segmentPath(pos.Segment))
+	require.NoError(t, err)
+
+	report, err = Verify(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, 4, report.Segments)
+	require.Equal(t, []int{pos.Segment}, report.ChecksumMismatches)
+	require.Len(t, report.Corrupted, 1)
+	require.Equal(t, CorruptedRecord{Segment: pos.Segment, Offset: pos.Offset, Err: report.Corrupted[0].Err}, report.Corrupted[0])
+	require.Error(t, report.Corrupted[0].Err)
+	require.Equal(t, []DuplicateIndex{{Index: 1, Segments: []int{0, 3}}}, report.Duplicates)
+	// checking continues after the corrupted msg
+	require.Equal(t, []IndexRange{{From: 4, To: 4}}, report.Gaps)
+
+	// nothing is changed
+	after, err := os.ReadFile(log.segmentPath(pos.Segment))
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
@@ -1,18 +1,35 @@
 package gowal
 
 import (
-	"bytes"
-	"encoding/gob"
+	"cmp"
+	"container/list"
+	"context"
 	"github.com/pkg/errors"
-	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vadiminshakov/gowal/frame"
+	"hash/crc32"
 	"iter"
+	"maps"
+	"math"
 	"os"
 	"path"
-	"sort"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
-var ErrExists = errors.New("msg with such index already exists")
+const (
+	// defaultFileMode and defaultDirMode are permissions of created files and directories, see Config.FileMode.
+	defaultFileMode os.FileMode = 0755
+	defaultDirMode  os.FileMode = 0755
+)
+
+var (
+	ErrExists    = errors.New("msg with such index already exists")
+	ErrNotFound  = errors.New("msg with such index not found")
+	ErrKeyExists = errors.New("msg with such key already exists")
+)
 
 // Wal is a write-ahead log that stores key-value pairs.
 //
@@ -27,15 +44,52 @@ type Wal struct {
 	// file with checksum for current segment
 	checksum *os.File
 
-	// index that matches height of msg record with offset in file
-	index    map[uint64]msg
-	tmpIndex map[uint64]msg
+	// index that matches height of msg record with offset in file, msgs are tagged with their segments
+	index Index
+
+	// creates empty index, see Config.NewIndex
+	newIndex func() Index
+
+	// key index that matches key of msg with its index, maintained only if unique keys are enforced
+	keys map[string]uint64
+
+	// if set, index keeps only positions of msgs on disk, msgs are read from disk on demand
+	offsetOnlyIndex bool
+
+	// cache of msgs read from disk, nil if disabled
+	cache *readCache
+
+	// msgs kept in memory are compared with their frames on disk on every access, see Config.VerifyReads
+	verifyReads bool
+
+	// encodes msgs into frame payloads, see Config.Codec
+	codec Codec
+
+	// approximate memory budget of the index, 0 means no limit
+	maxIndexMemoryBytes int64
+
+	// memory taken by keys and values of msgs kept in memory
+	residentBytes int64
+
+	// segments switched to offset-only index to fit into the memory budget
+	offsetOnlySegments map[int]struct{}
+
+	// cached files of sealed segments opened for reading (with their memory mappings),
+	// the most recently used first in openReaders
+	readers         map[int]*segmentHandle
+	openReaders     *list.List
+	maxOpenSegments int
+	mmapSegments    bool
+
+	// sealed segments are compressed, see Config.CompressSegments
+	compressSegments bool
+	readersMu        sync.Mutex
 
-	// gob encoder for proposed messages
-	enc *gob.Encoder
+	// access statistics of segments, see Segments
+	access accessStats
 
-	// buffer for proposed messages
-	buf *bytes.Buffer
+	// key filters of segments by segment number
+	filters map[int]*keyFilter
 
 	// path to directory with logs
 	pathToLogsDir string
@@ -48,17 +102,154 @@ type Wal struct {
 
 	lastIndex atomic.Uint64
 
-	// number of segments for log
-	segmentsNumber int
+	// numbers of segments on disk in ascending order, the last one is the active segment
+	segments []int
+
+	// number of the segment the log is currently written to
+	activeSegment int
+
+	// numbers of msgs and ranges of indexes of segments
+	metas map[int]segmentMeta
+
+	// number of segments keeping whole msgs in memory, see Config.HotSegments
+	hotSegments int
+
+	// hot segments in order they became hot and cold segments keeping only positions of msgs
+	hot          []int
+	coldSegments map[int]struct{}
+
+	// cold segments being promoted to the hot tier
+	promotionsMu sync.Mutex
+	promotions   map[int]struct{}
+
+	// set by Close
+	closed bool
+
+	// intern keys of msgs kept in memory, see Config.InternKeys
+	internKeys bool
+
+	// arenas of values of msgs kept in memory by segment, see Config.ValueArenaChunkSize
+	arenaChunkSize int
+	arenas         map[int]*valueArena
 
 	// prefix for segment files
 	prefix string
 
+	// permissions of created files, see Config.FileMode
+	fileMode os.FileMode
+
 	segmentsThreshold int
 
+	segmentMaxBytes int64
+
+	// time the first record was written to the active segment and the age of segments it is rotated at
+	activeSince   time.Time
+	segmentMaxAge time.Duration
+
+	// closed by Close to stop background work
+	stop chan struct{}
+
 	maxSegments int
 
+	// what happens when MaxSegments is reached and the condition writes wait on, see Config.EvictionPolicy
+	evictionPolicy EvictionPolicy
+	segmentRemoved *sync.Cond
+
+	// what happens when syncing to disk fails, see Config.SyncFailurePolicy
+	syncFailurePolicy SyncFailurePolicy
+
+	// oldest segments are evicted while segment files take more, see Config.MaxTotalBytes
+	maxTotalBytes int64
+
+	// segments with older newest records are evicted, see Config.RetentionAge
+	retentionAge time.Duration
+
+	// background compaction and its outcome, see Config.CompactionInterval
+	compactionInterval   time.Duration
+	compactionIdle       time.Duration
+	compactionMergeBytes int64
+	compactionPaused     bool
+	compaction           CompactionStats
+
+	// background scrubbing of sealed segments and its outcome, see Config.ScrubInterval
+	scrubInterval       time.Duration
+	scrubBytesPerSecond int64
+	scrubNext           int
+	scrubCorrupted      map[int]struct{}
+	scrub               ScrubStats
+
+	// time the last msg was written at
+	lastWrite time.Time
+
+	// bytes truncated from the end of the active segment on startup, see Stats.TornTailBytes
+	tornTailBytes int64
+
+	// numbers of corrupted segments found on startup, see Stats.CorruptedSegments
+	corruptedSegments []int
+
+	// indexes stored in more than one segment found on startup, see Config.DuplicatePolicy
+	duplicatePolicy DuplicatePolicy
+	duplicates      duplicates
+
+	// receives events, see Config.EventHandler
+	eventHandler EventHandler
+
+	// called before the oldest segment is deleted, see Config.OnEvict
+	onEvict func(segmentPath string) error
+
+	// called for records found corrupted on disk, see Config.OnCorruption
+	onCorruption func(segment string, offset int64, idx uint64, err error)
+
+	// directory the oldest segments are moved to and the number of segments kept there, see Config.ArchiveDir
+	archiveDir         string
+	archiveMaxSegments int
+
 	isInSyncDiskMode bool
+
+	// if set, checksums of msgs are not computed on write
+	disableChecksums bool
+
+	// algorithm of checksums of written msgs, see Config.Checksum
+	checksumAlgo Checksum
+
+	// max number of bytes written but not synced to disk yet, 0 means no limit
+	maxUnflushedBytes int64
+
+	// number of bytes written since last sync
+	unflushedBytes int64
+
+	// running crc32 (IEEE) of the active segment, written into its footer when it is sealed
+	segmentCRC uint32
+
+	// unique identifier of the WAL instance, persisted in the manifest and segment headers
+	id [16]byte
+
+	// counters of detected corruptions, see Stats
+	memoryCorruptions atomic.Uint64
+	diskCorruptions   atomic.Uint64
+
+	// write the index to the snapshot file on Close
+	snapshotIndex bool
+
+	// error of loading older segments in the background (see Config.LazyLoad) or of syncing to disk
+	// (see Config.SyncFailurePolicy), writes fail with it
+	loadErr error
+
+	// active subscriptions to new messages
+	subscriptions map[*subscription]struct{}
+	// max number of msgs queued for a subscriber and what happens when it's reached, see Config.SubscriberBuffer
+	subscriberBuffer   int
+	subscriberOverflow SubscriberOverflowPolicy
+
+	// annotations of msgs and the file they are appended to, opened on the first annotation
+	annotations    []Annotation
+	annotationsLog *os.File
+
+	// checkpoints from the oldest to the newest and the file they are appended to, opened on the first checkpoint
+	checkpoints    []Checkpoint
+	checkpointsLog *os.File
+
+	mu sync.RWMutex
 }
 
 // Config represents the configuration for the WAL (Write-Ahead Log).
@@ -66,152 +257,1158 @@ type Config struct {
 	// Dir is the directory where the log files will be stored.
 	Dir string
 
-	// Prefix is the prefix for the segment files.
+	// Prefix is the prefix for the segment files. Segment files are named by the prefix followed by the segment
+	// number, so WALs with different prefixes may share the directory. The prefix must not end with a digit.
 	Prefix string
 
 	// SegmentThreshold is the number of records after which a new segment is created.
+	// Zero disables rotation by the number of records, then SegmentMaxBytes or SegmentMaxAge must be set.
 	SegmentThreshold int
 
+	// SegmentMaxBytes is the size of the segment file after which a new segment is created,
+	// the segment is rotated by whichever of SegmentThreshold and SegmentMaxBytes is reached first.
+	// Default is 0 (segments are rotated by the number of records only).
+	SegmentMaxBytes int64
+
+	// SegmentMaxAge is the time after the first record was written to the active segment after which
+	// a new segment is created, even if SegmentThreshold and SegmentMaxBytes are not reached.
+	// Segments are rotated in the background, so they are time-bounded even if no records are written.
+	// Default is 0 (segments are not rotated by age).
+	SegmentMaxAge time.Duration
+
 	// MaxSegments is the maximum number of segments allowed before the oldest segment is deleted.
 	MaxSegments int
 
+	// CompactionInterval makes sealed segments compacted (see Compact) in the background every interval,
+	// and merged (see MergeSegments) if CompactionMergeBytes is set. Use PauseCompaction and ResumeCompaction
+	// to control it and Stats to track its progress. Default is 0 (no background compaction).
+	CompactionInterval time.Duration
+
+	// CompactionIdle makes background compaction run only if nothing was written for this long,
+	// so it doesn't compete with writes. Default is 0 (compaction runs regardless of writes).
+	CompactionIdle time.Duration
+
+	// CompactionMergeBytes is the maximum size of segments produced by merging small segments in the background.
+	// Default is 0 (segments are not merged).
+	CompactionMergeBytes int64
+
+	// ScrubInterval makes sealed segments verified against their checksums in the background, one segment
+	// every interval, cycling through all sealed segments, to detect silent corruption (bit rot) of segments
+	// that are rarely read. Corrupted segments are reported to OnCorruption and EventHandler once
+	// and counted in Stats. Default is 0 (no background scrubbing).
+	ScrubInterval time.Duration
+
+	// ScrubBytesPerSecond limits the rate segments are read at by the background scrubber,
+	// so it doesn't compete with reads and writes for the disk. Default is 0 (no limit).
+	ScrubBytesPerSecond int64
+
+	// EvictionPolicy defines what happens when the active segment has to be rotated, but there are
+	// MaxSegments segments already: the oldest segments are evicted (DeleteOldest, default), writes wait
+	// until segments are removed (BlockWrites) or fail with ErrFull (Error). Segments are removed
+	// by TruncateBefore, Compact, RetentionAge and MaxTotalBytes.
+	EvictionPolicy EvictionPolicy
+
+	// SubscriberBuffer is the maximum number of msgs queued for a subscriber (see Subscribe) that doesn't
+	// keep up with writes. Default is 65536.
+	SubscriberBuffer int
+
+	// SubscriberOverflowPolicy defines what happens when the queue of a subscriber is full: the subscription
+	// is closed (CloseSubscriber, default) or new msgs are dropped until the subscriber catches up (DropMsgs).
+	SubscriberOverflowPolicy SubscriberOverflowPolicy
+
+	// Codec encodes msgs into payloads of frames, e.g. to avoid a second serialization library in systems
+	// built around another one. The codec is recorded in the manifest when the WAL is created, NewWAL fails
+	// with ErrCodecMismatch if the WAL is opened with another one. Functions working on WAL directories without
+	// opening the WAL (Verify, Salvage, SafeRecover, RebuildIndex and so on) read msgs with the recorded codec,
+	// they fail with ErrCustomCodec for codecs not provided by this package. Default is MsgpackCodec.
+	Codec Codec
+
+	// RecoveryPolicy defines what happens on startup with sealed segments that don't match their checksums:
+	// the WAL fails to open (Fail, default), the segments are truncated to their last valid record (TruncateTail),
+	// the WAL is opened without them (SkipCorrupted) or they are removed (RemoveSegment). Every policy but Fail
+	// reads all segments on startup to find corrupted ones.
+	RecoveryPolicy RecoveryPolicy
+
+	// DuplicatePolicy defines what happens on startup with msgs with the same index stored in more than one segment:
+	// the msg from the newest segment is kept (NewestWins, default) or NewWAL fails with ErrDuplicateIndex
+	// (FailOnDuplicate). Duplicates are reported in Stats.DuplicateIndexes.
+	DuplicatePolicy DuplicatePolicy
+
+	// SyncFailurePolicy defines what happens when syncing segments to disk fails: the error is returned
+	// and the WAL keeps working (ReturnSyncError, default), all subsequent writes fail with ErrWALFailed
+	// until the WAL is reopened (FailStop) or the process panics (PanicOnSyncError). Continuing to write
+	// after a failed sync may silently lose msgs written before it.
+	SyncFailurePolicy SyncFailurePolicy
+
+	// MaxTotalBytes is the maximum size of files of all segments (including checksum files and index sidecars),
+	// the oldest segments are evicted (deleted or moved to ArchiveDir, see OnEvict) when segments are rotated
+	// until the rest fits. The active segment is never evicted, so use SegmentMaxBytes to bound its size.
+	// Default is 0 (no limit).
+	MaxTotalBytes int64
+
+	// RetentionAge is the time after which a segment is evicted (deleted or moved to ArchiveDir, see OnEvict)
+	// once its newest record is older than that, regardless of MaxSegments. The active segment is sealed
+	// if its newest record is that old. Segments are evicted from the oldest one in the background.
+	// Default is 0 (segments are evicted by MaxSegments only).
+	RetentionAge time.Duration
+
+	// EventHandler receives events of the WAL (segments sealed, created and evicted, corruptions detected),
+	// e.g. to log them, alert or trigger downstream work. It is called synchronously, often with the WAL locked,
+	// so it must return quickly and must not call methods of the WAL. Default is nil (events are dropped).
+	EventHandler EventHandler
+
+	// OnEvict is called with the path of the oldest segment before it is deleted (or moved to ArchiveDir)
+	// because of MaxSegments, MaxTotalBytes or RetentionAge, e.g. to upload or copy the sealed segment
+	// to external storage. If OnEvict returns an error, the segment is kept and its eviction is retried later.
+	// It is called with the WAL locked, so it must not call methods of the WAL.
+	OnEvict func(segmentPath string) error
+
+	// OnCorruption is called when a record is found corrupted on disk: on startup (a torn write at the end
+	// of the active segment or a sealed segment that doesn't match its checksum, see RecoveryPolicy) and when
+	// the record is read. It receives the path of the segment, the offset of the corrupted record, its index
+	// (0 on startup, because the index of the corrupted record can't be trusted) and the error, which wraps
+	// ErrCorruptedFrame. On reads it is called with the lock held, so it must not call methods of the WAL.
+	OnCorruption func(segment string, offset int64, idx uint64, err error)
+
+	// ArchiveDir is the directory the oldest segments are moved to (along with their checksum files and
+	// index sidecars) instead of being deleted because of MaxSegments. Archived segments keep their names.
+	// Default is "" (segments are deleted).
+	ArchiveDir string
+
+	// ArchiveMaxSegments is the maximum number of segments kept in ArchiveDir, the oldest archived segments
+	// are deleted when it is exceeded. Default is 0 (archived segments are never deleted).
+	ArchiveMaxSegments int
+
 	// IsInSyncDiskMode indicates whether the log should be synced to disk after each write.
 	IsInSyncDiskMode bool
+
+	// MaxUnflushedBytes bounds the amount of data at risk when IsInSyncDiskMode is false:
+	// the log is synced to disk as soon as this many bytes were written since the last sync.
+	// Zero means no limit (the log is synced only by the OS).
+	MaxUnflushedBytes int64
+
+	// UniqueKeys makes Write reject msgs with a key that is already present in the log with ErrKeyExists.
+	// Keys of msgs removed by segment rotation can be written again.
+	UniqueKeys bool
+
+	// DisableChecksums turns off computation of checksums of msgs on write, for payloads already checksummed
+	// by the upper layer. Frames are written with a flag telling readers not to verify them,
+	// so msgs written this way are not protected from corruption on disk or in memory.
+	DisableChecksums bool
+
+	// Checksum is the algorithm of checksums of msgs written to disk, the default is CRC32IEEE.
+	// CRC32Castagnoli is computed by CPU instructions on modern CPUs and is several times faster.
+	// The algorithm is stored in the header of every msg, so it can be changed between restarts.
+	Checksum Checksum
+
+	// NewIndex creates an empty index of msgs, the default index is a map with an ordered list of indexes.
+	// Set it to keep the index in another structure (e.g. a B-tree or an on-disk index) for very large logs.
+	NewIndex func() Index
+
+	// HotSegments makes only the given number of segments (the newest ones and the ones read recently) keep
+	// whole msgs in memory, older segments keep only positions of msgs (loaded from index sidecars on startup)
+	// and are read from disk. A cold segment is promoted back to memory in the background when its msgs
+	// are read, demoting the segment that became hot earliest, so memory usage is predictable
+	// for WALs with long retention. Default is 0 (all segments are hot).
+	HotSegments int
+
+	// InternKeys makes the in-memory index keep a single copy of every distinct key,
+	// so WALs with many msgs sharing a small set of keys take much less memory.
+	InternKeys bool
+
+	// ValueArenaChunkSize enables allocation of values of msgs kept in memory in chunks of the given size
+	// (one series of chunks per segment) instead of one allocation per value, which reduces GC pressure
+	// for WALs with many small values. Values are copied on write, so the caller may reuse the written slice.
+	// Chunks of a segment are freed when the segment is removed. Default is 0 (disabled).
+	ValueArenaChunkSize int
+
+	// OffsetOnlyIndex makes the in-memory index keep only positions of msgs on disk instead of whole msgs,
+	// so memory usage scales with the number of msgs instead of their size. Msgs are read from disk
+	// (and verified against their checksums) on every access.
+	OffsetOnlyIndex bool
+
+	// ReadCacheSize is the number of msgs read from disk (see OffsetOnlyIndex and MaxIndexMemoryBytes)
+	// kept in LRU cache, so repeated reads of hot msgs don't hit disk. Default is 0 (no cache).
+	ReadCacheSize int
+
+	// VerifyReads makes Get, GetMsg, GetByKey and View read every msg from disk and compare it with the copy
+	// kept in memory, the in-memory copy is replaced if they differ. It guards long-lived processes against
+	// memory corruption the checksums of in-memory copies can miss, at the cost of a disk read per access.
+	// The read cache is bypassed. If the msg is corrupted on disk, the in-memory copy is used.
+	VerifyReads bool
+
+	// MmapSegments makes msgs of sealed segments read from disk (see OffsetOnlyIndex) through memory mappings
+	// of the segment files instead of read syscalls. Segments that can't be mapped (or platforms without mmap)
+	// are read as usual.
+	MmapSegments bool
+
+	// CompressSegments makes sealed segments compressed with zstd when they are sealed, compacted or merged,
+	// cutting their size several times. Segments are compressed in independent blocks, so reading a msg
+	// decompresses only the block holding it. Segments are decompressed transparently on reads,
+	// so segments compressed and not compressed may be mixed. Default is false.
+	CompressSegments bool
+
+	// MaxOpenSegments is the maximum number of files of sealed segments kept open for reading.
+	// Files are opened on demand and the least recently used ones are closed when the limit is exceeded,
+	// so WALs with many segments don't exhaust the limit of open files. Default is 0 (no limit).
+	MaxOpenSegments int
+
+	// MaxIndexMemoryBytes is the approximate memory budget of the in-memory index. When it is exceeded,
+	// sealed segments are switched to offset-only index (see OffsetOnlyIndex) one by one, from the oldest
+	// to the newest, until the index fits into the budget. The active segment is never switched, so the budget
+	// may stay exceeded until it is sealed. Default is 0 (no limit).
+	MaxIndexMemoryBytes int64
+
+	// SnapshotIndex makes Close write the index to a snapshot file and NewWAL load the index from it
+	// instead of decoding segments, so restarts take time proportional to the size of the index
+	// rather than the size of the log. The snapshot is used only if no segment was changed, added or removed
+	// since it was written, otherwise segments are decoded as usual.
+	SnapshotIndex bool
+
+	// LazyLoad makes NewWAL load only the newest segment and return, indexes of older segments are loaded
+	// in the background. Methods of Wal (including Write, which must check indexes for duplicates) wait until
+	// the older segments are loaded, so the startup cost is paid concurrently with the rest of the application
+	// startup instead of blocking it. If older segments can't be loaded, reads see only the newest segment
+	// and Write fails.
+	LazyLoad bool
+
+	// FileMode is the permissions segment files and other files of the WAL are created with, e.g. 0600
+	// for WALs holding sensitive payloads. Default is 0755.
+	FileMode os.FileMode
+
+	// DirMode is the permissions the WAL directory (and ArchiveDir) is created with, e.g. 0700. Default is 0755.
+	DirMode os.FileMode
+
+	// OpenRetryBackoff is the initial delay between attempts to open the WAL in NewWALWithContext.
+	// The delay doubles after every failed attempt. Zero disables retries.
+	OpenRetryBackoff time.Duration
+
+	// OpenRetryMaxBackoff caps the delay between attempts to open the WAL in NewWALWithContext.
+	// Default is 5 seconds.
+	OpenRetryMaxBackoff time.Duration
 }
 
 // NewWAL creates a new WAL with the given configuration.
 func NewWAL(config Config) (*Wal, error) {
-	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+	if err := checkPrefix(config.Prefix); err != nil {
+		return nil, err
+	}
+
+	if config.RecoveryPolicy < Fail || config.RecoveryPolicy > RemoveSegment {
+		return nil, errors.Errorf("unknown recovery policy %s", config.RecoveryPolicy)
+	}
+
+	if config.DuplicatePolicy < NewestWins || config.DuplicatePolicy > FailOnDuplicate {
+		return nil, errors.Errorf("unknown duplicate policy %s", config.DuplicatePolicy)
+	}
+
+	if config.SyncFailurePolicy < ReturnSyncError || config.SyncFailurePolicy > PanicOnSyncError {
+		return nil, errors.Errorf("unknown sync failure policy %s", config.SyncFailurePolicy)
+	}
+
+	if config.SubscriberOverflowPolicy < CloseSubscriber || config.SubscriberOverflowPolicy > DropMsgs {
+		return nil, errors.Errorf("unknown subscriber overflow policy %s", config.SubscriberOverflowPolicy)
+	}
+
+	if !config.Checksum.Valid() {
+		return nil, errors.Errorf("unknown checksum algorithm %s", config.Checksum)
+	}
+
+	if config.SegmentThreshold < 0 || config.SegmentMaxBytes < 0 || config.SegmentMaxAge < 0 {
+		return nil, errors.New("segment rotation limits must not be negative")
+	}
+
+	if config.SegmentThreshold == 0 && config.SegmentMaxBytes == 0 && config.SegmentMaxAge == 0 {
+		return nil, errors.New("one of SegmentThreshold, SegmentMaxBytes and SegmentMaxAge must be set")
+	}
+
+	fileMode, dirMode := config.FileMode, config.DirMode
+	if fileMode == 0 {
+		fileMode = defaultFileMode
+	}
+	if dirMode == 0 {
+		dirMode = defaultDirMode
+	}
+
+	subscriberBuffer := config.SubscriberBuffer
+	if subscriberBuffer <= 0 {
+		subscriberBuffer = defaultSubscriberBuffer
+	}
+
+	codec := config.Codec
+	if codec == nil {
+		codec = defaultCodec
+	}
+
+	if err := os.MkdirAll(config.Dir, dirMode); err != nil {
 		return nil, errors.Wrap(err, "failed to create log directory")
 	}
 
+	if config.ArchiveDir != "" {
+		if err := os.MkdirAll(config.ArchiveDir, dirMode); err != nil {
+			return nil, errors.Wrap(err, "failed to create archive directory")
+		}
+	}
+
+	if err := checkCodec(config.Dir, config.Prefix, codec); err != nil {
+		return nil, err
+	}
+
+	if _, err := migrateLegacySegments(config.Dir, config.Prefix, codec, config.Checksum, fileMode); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate legacy segments")
+	}
+
 	segmentsNumbers, err := findSegmentNumber(config.Dir, config.Prefix)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find segment numbers")
 	}
 
-	// load segments into mem
-	fd, chk, lastOffset, index, err := segmentInfoAndIndex(segmentsNumbers, path.Join(config.Dir, config.Prefix))
+	if err := checkCurrentSegment(config.Dir, config.Prefix, segmentsNumbers[len(segmentsNumbers)-1], fileMode); err != nil {
+		return nil, err
+	}
+
+	// the newest segment is appended to, so it can't stay compressed (e.g. if newer segments were removed)
+	activePath := path.Join(config.Dir, segmentName(config.Prefix, segmentsNumbers[len(segmentsNumbers)-1]))
+	if err := decompressSegmentFile(activePath, fileMode); err != nil {
+		return nil, errors.Wrap(err, "failed to decompress active segment")
+	}
+
+	// a write interrupted by a crash leaves a partial frame at the end of the active segment
+	tornTailBytes, err := truncateTornTail(activePath, codec)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to load log segments")
+		return nil, errors.Wrap(err, "failed to truncate torn write at the end of active segment")
 	}
-	numberOfSegments := len(index) / config.SegmentThreshold
-	if numberOfSegments == 0 {
-		numberOfSegments = 1
+	if tornTailBytes > 0 && config.OnCorruption != nil {
+		stat, err := os.Stat(activePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to stat active segment")
+		}
+		config.OnCorruption(activePath, stat.Size(), 0, errors.Wrapf(ErrCorruptedFrame, "torn write of %d bytes at the end of active segment was truncated", tornTailBytes))
 	}
 
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+	segmentsNumbers, corruptedSegments, err := applyRecoveryPolicy(config.RecoveryPolicy, config.OnCorruption, config.Dir, config.Prefix, segmentsNumbers, codec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to recover corrupted segments")
+	}
 
-	w := &Wal{log: fd, index: index, checksum: chk, tmpIndex: make(map[uint64]msg),
-		buf: &buf, enc: enc, lastOffset: lastOffset, pathToLogsDir: config.Dir,
-		segmentsNumber: numberOfSegments, prefix: config.Prefix, segmentsThreshold: config.SegmentThreshold,
-		maxSegments: config.MaxSegments, isInSyncDiskMode: config.IsInSyncDiskMode}
+	mf, err := loadOrCreateManifest(config.Dir, config.Prefix, segmentsNumbers, codecName(codec), fileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load manifest")
+	}
+
+	id, err := parseID(mf.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load manifest")
+	}
+
+	annotations, err := loadAnnotations(path.Join(config.Dir, config.Prefix+annotationsPostfix))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load annotations")
+	}
+
+	checkpoints, err := loadCheckpoints(path.Join(config.Dir, config.Prefix+checkpointsPostfix))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load checkpoints")
+	}
+
+	// load segments into mem, only the newest one if older segments are loaded lazily
+	useSidecars := config.OffsetOnlyIndex && !config.UniqueKeys
+	eager := segmentsNumbers
+	if config.LazyLoad {
+		eager = segmentsNumbers[len(segmentsNumbers)-1:]
+	}
 
-	lastIndex := uint64(0)
-	for v := range w.Iterator() {
-		if v.Idx > lastIndex {
-			lastIndex = v.Idx
+	var (
+		fd, chk    *os.File
+		lastOffset int64
+		index      map[uint64]Msg
+		filters    map[int]*keyFilter
+		keys       map[string]uint64
+		dups       = make(duplicates)
+	)
+	if config.SnapshotIndex {
+		// fall back to decoding segments if snapshot can't be used
+		if index, filters, keys, err = readSnapshot(config.Dir, config.Prefix, id, segmentsNumbers); err == nil {
+			eager = segmentsNumbers
+			fd, chk, lastOffset, err = openActiveSegment(path.Join(config.Dir, segmentName(config.Prefix, segmentsNumbers[len(segmentsNumbers)-1])), fileMode)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to open active segment")
+			}
 		}
 	}
 
-	w.lastIndex.Store(lastIndex)
+	if fd == nil {
+		// positions of msgs of cold segments are enough, so they are loaded from sidecars
+		hot, cold := eager, []int(nil)
+		if config.HotSegments > 0 && len(eager) > config.HotSegments {
+			hot, cold = eager[len(eager)-config.HotSegments:], eager[:len(eager)-config.HotSegments]
+		}
+
+		fd, chk, lastOffset, index, filters, err = segmentInfoAndIndex(hot, path.Join(config.Dir, config.Prefix), id, useSidecars, fileMode, dups, codec)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load log segments")
+		}
+
+		if len(cold) > 0 {
+			coldIndex, coldFilters, err := loadSegmentIndexes(cold, path.Join(config.Dir, config.Prefix), id, !config.UniqueKeys, fileMode, dups, codec)
+			if err != nil {
+				fd.Close()
+				chk.Close()
+				return nil, errors.Wrap(err, "failed to load log segments")
+			}
+
+			dups.merge(coldIndex, index)
+			maps.Copy(coldFilters, filters)
+			index, filters = coldIndex, coldFilters
+		}
+
+		if err := dups.check(config.DuplicatePolicy); err != nil {
+			fd.Close()
+			chk.Close()
+			return nil, err
+		}
+	}
+
+	segmentCRC, err := segmentFileCRC(fd.Name())
+	if err != nil {
+		fd.Close()
+		chk.Close()
+		return nil, errors.Wrap(err, "failed to compute checksum of active segment")
+	}
+
+	w := &Wal{log: fd, checksum: chk, segmentCRC: segmentCRC,
+		lastOffset: lastOffset, pathToLogsDir: config.Dir, segments: segmentsNumbers,
+		activeSegment: segmentsNumbers[len(segmentsNumbers)-1], prefix: config.Prefix, fileMode: fileMode,
+		segmentsThreshold: config.SegmentThreshold, segmentMaxBytes: config.SegmentMaxBytes, maxSegments: config.MaxSegments,
+		compactionInterval: config.CompactionInterval, compactionIdle: config.CompactionIdle, compactionMergeBytes: config.CompactionMergeBytes,
+		evictionPolicy: config.EvictionPolicy,
+		onEvict:        config.OnEvict, onCorruption: config.OnCorruption, eventHandler: config.EventHandler, archiveDir: config.ArchiveDir, archiveMaxSegments: config.ArchiveMaxSegments,
+		maxTotalBytes: config.MaxTotalBytes, retentionAge: config.RetentionAge, segmentMaxAge: config.SegmentMaxAge, stop: make(chan struct{}),
+		isInSyncDiskMode: config.IsInSyncDiskMode, maxUnflushedBytes: config.MaxUnflushedBytes, syncFailurePolicy: config.SyncFailurePolicy, id: id,
+		subscriptions: make(map[*subscription]struct{}), subscriberBuffer: subscriberBuffer, subscriberOverflow: config.SubscriberOverflowPolicy, readers: make(map[int]*segmentHandle),
+		openReaders: list.New(), maxOpenSegments: config.MaxOpenSegments, mmapSegments: config.MmapSegments,
+		compressSegments: config.CompressSegments, tornTailBytes: tornTailBytes, corruptedSegments: corruptedSegments,
+		duplicatePolicy: config.DuplicatePolicy, duplicates: dups,
+		offsetOnlyIndex: config.OffsetOnlyIndex, disableChecksums: config.DisableChecksums, checksumAlgo: config.Checksum,
+		maxIndexMemoryBytes: config.MaxIndexMemoryBytes, offsetOnlySegments: make(map[int]struct{}),
+		annotations: annotations, checkpoints: checkpoints, snapshotIndex: config.SnapshotIndex, newIndex: config.NewIndex}
+	if w.newIndex == nil {
+		w.newIndex = newMapIndex
+	}
+	w.index = w.newIndex()
+	w.segmentRemoved = sync.NewCond(&w.mu)
+	w.metas = make(map[int]segmentMeta)
+	w.internKeys, w.arenaChunkSize, w.arenas = config.InternKeys, config.ValueArenaChunkSize, make(map[int]*valueArena)
+	w.hotSegments, w.coldSegments, w.promotions = config.HotSegments, make(map[int]struct{}), make(map[int]struct{})
+	w.scrubInterval, w.scrubBytesPerSecond, w.scrubCorrupted = config.ScrubInterval, config.ScrubBytesPerSecond, make(map[int]struct{})
+	if config.SegmentMaxAge > 0 {
+		w.activeSince = activeSegmentAge(index, w.activeSegment)
+		go w.rotateByAge()
+	}
+	if config.UniqueKeys {
+		w.keys = make(map[string]uint64, len(index))
+	}
+	w.verifyReads, w.codec = config.VerifyReads, codec
+	if config.ReadCacheSize > 0 {
+		w.cache = newReadCache(config.ReadCacheSize)
+	}
+
+	if lazy := segmentsNumbers[:len(segmentsNumbers)-len(eager)]; len(lazy) > 0 {
+		// the newest segment holds the last msg, so the current index is known before older segments are loaded
+		for idx := range index {
+			w.lastIndex.Store(max(w.lastIndex.Load(), idx))
+		}
+
+		w.mu.Lock()
+		go w.loadOlderSegments(lazy, index, filters, useSidecars)
+		if config.RetentionAge > 0 {
+			go w.retainByAge()
+		}
+		if config.CompactionInterval > 0 {
+			go w.compactInBackground()
+		}
+		if config.ScrubInterval > 0 {
+			go w.scrubInBackground()
+		}
+
+		return w, nil
+	}
+
+	w.setIndex(index, filters)
+	w.initTiers()
+	for key, idx := range keys {
+		if _, ok := w.index.Get(idx); ok && w.keys != nil {
+			w.keys[key] = idx
+		}
+	}
+
+	if m, ok := w.seekReverse(math.MaxUint64); ok {
+		w.lastIndex.Store(m.Idx)
+	}
+
+	if config.RetentionAge > 0 {
+		go w.retainByAge()
+	}
+	if config.CompactionInterval > 0 {
+		go w.compactInBackground()
+	}
+	if config.ScrubInterval > 0 {
+		go w.scrubInBackground()
+	}
 
 	return w, nil
 }
 
 // UnsafeRecover recovers the WAL from the given directory.
 // It is unsafe because it removes all the segment and checksum files that are corrupted (checksums do not match).
+// Segments of the legacy format (without headers) that are not corrupted are migrated to the current format.
 // It returns the list of segment and checksum files that were removed.
 func UnsafeRecover(dir, segmentPrefix string) ([]string, error) {
+	return UnsafeRecoverWithOptions(dir, segmentPrefix, RecoverOptions{})
+}
+
+// quarantineDir is the subdirectory of the WAL directory corrupted segments are moved to, see RecoverOptions.Quarantine.
+const quarantineDir = "quarantine"
+
+// RecoverOptions configures UnsafeRecoverWithOptions.
+type RecoverOptions struct {
+	// Quarantine makes corrupted segments moved (along with their checksum files and index sidecars)
+	// to the quarantine subdirectory of the WAL directory instead of being removed, so they can be inspected
+	// or salvaged later (see Salvage). Files quarantined earlier with the same names are replaced.
+	Quarantine bool
+}
+
+// UnsafeRecoverWithOptions works like UnsafeRecover configured by opts.
+// It returns the list of segment and checksum files that were removed from the WAL directory.
+func UnsafeRecoverWithOptions(dir, segmentPrefix string, opts RecoverOptions) ([]string, error) {
+	var quarantine string
+	if opts.Quarantine {
+		quarantine = path.Join(dir, quarantineDir)
+	}
+
+	// corrupted segments of the legacy format are removed, the rest is migrated to the current format
+	legacy, err := findLegacySegments(dir, segmentPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, seg := range legacy {
+		segmentPath := path.Join(dir, seg.name)
+		if _, err := readLegacySegment(segmentPath, time.Time{}); !errors.Is(err, ErrCorruptedFrame) {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		ok, err := handleCorruptedSegment(segmentPath, quarantine)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to process segment %s", segmentPath)
+		}
+		if ok {
+			removed = append(removed, segmentPath, segmentPath+checkSumPostfix)
+		}
+	}
+
+	codec, err := walCodec(dir, segmentPrefix)
+	if err != nil {
+		return removed, err
+	}
+
+	if _, err := migrateLegacySegments(dir, segmentPrefix, codec, CRC32IEEE, defaultFileMode); err != nil {
+		return nil, errors.Wrap(err, "failed to migrate legacy segments")
+	}
+
 	segmentsNumbers, err := findSegmentNumber(dir, segmentPrefix)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to find segment numbers")
 	}
 
-	return removeCorruptedSegments(segmentsNumbers, path.Join(dir, segmentPrefix))
+	removedCurrent, err := removeCorruptedSegments(segmentsNumbers, path.Join(dir, segmentPrefix), quarantine)
+	if err != nil {
+		return nil, err
+	}
+	removed = append(removed, removedCurrent...)
+
+	// the active segment may be removed, so the current file points to the newest segment left
+	if segmentsNumbers, err = findSegmentNumber(dir, segmentPrefix); err != nil {
+		return nil, errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	return removed, writeCurrentSegment(dir, segmentPrefix, segmentsNumbers[len(segmentsNumbers)-1], defaultFileMode)
 }
 
 // Get queries value at specific index in the log.
+//
+// If the in-memory copy of the msg is corrupted, it is re-read from disk, see Stats for corruption counters.
+// Msg that can't be repaired is reported as missing, use GetMsg to get the error.
 func (c *Wal) Get(index uint64) (string, []byte, bool) {
-	msg, ok := c.index[index]
-	if !ok {
+	msg, err := c.lookup(index)
+	if err != nil {
 		return "", nil, false
 	}
 
 	return msg.Key, msg.Value, true
 }
 
+// GetMsg returns msg at specific index in the log.
+// Unlike Get, it returns ErrNotFound if there is no msg with such index,
+// so a stored empty value can't be mistaken for a missing one.
+func (c *Wal) GetMsg(index uint64) (Msg, error) {
+	return c.lookup(index)
+}
+
+// GetByKey returns the last written msg with the given key.
+// Segments that definitely don't contain the key (according to their key filters) are skipped,
+// so in OffsetOnlyIndex mode only segments that may contain the key are read from disk.
+// It returns ErrNotFound if there is no msg with such key.
+func (c *Wal) GetByKey(key string) (Msg, error) {
+	c.mu.RLock()
+	if c.keys != nil {
+		idx, ok := c.keys[key]
+		c.mu.RUnlock()
+		if !ok {
+			return Msg{}, ErrNotFound
+		}
+
+		return c.lookup(idx)
+	}
+
+	candidates := c.keyCandidates(key)
+	c.mu.RUnlock()
+
+	for _, idx := range candidates {
+		m, err := c.lookup(idx)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return Msg{}, err
+		}
+
+		if m.Key == key {
+			return m, nil
+		}
+	}
+
+	return Msg{}, ErrNotFound
+}
+
+// keyCandidates returns indexes of msgs that may have the given key, the last written first.
+// The caller must hold the lock.
+func (c *Wal) keyCandidates(key string) []uint64 {
+	maybe := make(map[int]bool)
+	var candidates []Msg
+	c.index.Range(0, func(m Msg) bool {
+		if !m.onDisk && m.Key != key {
+			return true
+		}
+
+		contains, ok := maybe[m.seg]
+		if !ok {
+			f, hasFilter := c.filters[m.seg]
+			contains = !hasFilter || f.mayContain(key)
+			maybe[m.seg] = contains
+		}
+
+		if contains {
+			candidates = append(candidates, m)
+		}
+
+		return true
+	})
+
+	slices.SortFunc(candidates, func(a, b Msg) int {
+		if a.seg != b.seg {
+			return b.seg - a.seg
+		}
+		return cmp.Compare(b.off, a.off)
+	})
+
+	indexes := make([]uint64, len(candidates))
+	for i, m := range candidates {
+		indexes[i] = m.Idx
+	}
+
+	return indexes
+}
+
+// View calls fn with the key and value of msg at specific index without copying them.
+// The value is owned by the WAL: it is valid only for the duration of fn and must not be modified
+// or retained after fn returns (copy it if needed).
+// It returns ErrNotFound if there is no msg with such index, otherwise it returns the error of fn.
+func (c *Wal) View(index uint64, fn func(key string, value []byte) error) error {
+	m, err := c.lookup(index)
+	if err != nil {
+		return err
+	}
+
+	return fn(m.Key, m.Value)
+}
+
+// Exists reports whether msg with the given index is present in the log.
+// Unlike Get it doesn't copy the value, so it is cheap enough for hot-path dedup checks.
+func (c *Wal) Exists(index uint64) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.index.Get(index)
+	return ok
+}
+
 // CurrentIndex returns current index of the log.
 func (c *Wal) CurrentIndex() uint64 {
 	return c.lastIndex.Load()
 }
 
+// Len returns the number of records in the log.
+func (c *Wal) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.index.Len()
+}
+
+// SizeBytes returns total on-disk size of all segments of the log, including their checksum and index sidecar files.
+func (c *Wal) SizeBytes() (int64, error) {
+	segmentsNumbers, err := findSegmentNumber(c.pathToLogsDir, c.prefix)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find segment numbers")
+	}
+
+	var size int64
+	for _, n := range segmentsNumbers {
+		for _, name := range []string{c.segmentPath(n), c.segmentPath(n) + checkSumPostfix, c.segmentPath(n) + sidecarPostfix} {
+			stat, err := os.Stat(name)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return 0, errors.Wrapf(err, "failed to stat %s", name)
+			}
+
+			size += stat.Size()
+		}
+	}
+
+	return size, nil
+}
+
+// Stats represents runtime statistics of the WAL.
+type Stats struct {
+	// ID is unique identifier of the WAL instance, it is generated once and survives restarts.
+	// Use it to make sure that restored or replicated data belongs to the log you expect.
+	ID string
+
+	// Records is the number of records in the log.
+	Records int
+
+	// LastIndex is the current index of the log.
+	LastIndex uint64
+
+	// MemoryCorruptions is the number of msgs found corrupted in memory and repaired from disk.
+	MemoryCorruptions uint64
+
+	// DiskCorruptions is the number of msgs found corrupted on disk when read.
+	DiskCorruptions uint64
+
+	// IndexMemoryBytes is the approximate memory taken by the in-memory index.
+	IndexMemoryBytes int64
+
+	// OffsetOnlySegments is the number of segments switched to offset-only index to fit into MaxIndexMemoryBytes.
+	// Segments of the cold tier are not counted, see ColdSegments.
+	OffsetOnlySegments int
+
+	// ColdSegments is the number of segments of the cold tier keeping only positions of msgs in memory,
+	// see HotSegments.
+	ColdSegments int
+
+	// CacheHits and CacheMisses are the numbers of reads of msgs from disk served and not served
+	// by the read cache, see ReadCacheSize.
+	CacheHits   uint64
+	CacheMisses uint64
+
+	// TornTailBytes is the number of bytes truncated from the end of the active segment on startup,
+	// because they were left by a write interrupted by a crash.
+	TornTailBytes int64
+
+	// CorruptedSegments are numbers of sealed segments found corrupted on startup and handled
+	// according to RecoveryPolicy.
+	CorruptedSegments []int
+
+	// DuplicateIndexes are indexes of msgs stored in more than one segment found on startup,
+	// only msgs from the newest segments are kept, see DuplicatePolicy.
+	DuplicateIndexes []DuplicateIndex
+
+	// Compaction describes background compaction, see CompactionInterval.
+	Compaction CompactionStats
+
+	// Scrub describes background scrubbing of sealed segments, see ScrubInterval.
+	Scrub ScrubStats
+}
+
+// Stats returns runtime statistics of the WAL.
+func (c *Wal) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var hits, misses uint64
+	if c.cache != nil {
+		hits, misses = c.cache.stats()
+	}
+
+	compaction := c.compaction
+	compaction.Paused = c.compactionPaused
+
+	// cold segments are offset-only as well, they are reported separately
+	var offsetOnly int
+	for seg := range c.offsetOnlySegments {
+		if _, cold := c.coldSegments[seg]; !cold {
+			offsetOnly++
+		}
+	}
+
+	return Stats{ID: formatID(c.id), Records: c.index.Len(), LastIndex: c.lastIndex.Load(),
+		MemoryCorruptions: c.memoryCorruptions.Load(), DiskCorruptions: c.diskCorruptions.Load(),
+		IndexMemoryBytes: c.indexMemory(), OffsetOnlySegments: offsetOnly, ColdSegments: len(c.coldSegments),
+		CacheHits: hits, CacheMisses: misses, Compaction: compaction, Scrub: c.scrub, TornTailBytes: c.tornTailBytes,
+		CorruptedSegments: slices.Clone(c.corruptedSegments), DuplicateIndexes: c.duplicates.list()}
+}
+
 // Write writes key-value pair to the log.
 func (c *Wal) Write(index uint64, key string, value []byte) error {
-	if _, exists := c.index[index]; exists {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	if err := c.waitForFreeSegment(); err != nil {
+		return err
+	}
+
+	if _, exists := c.index.Get(index); exists {
 		return ErrExists // Предотвращаем дублирование индексов
 	}
 
-	if err := c.rotateIfNeeded(index, key, value); err != nil {
+	if c.keys != nil {
+		if _, exists := c.keys[key]; exists {
+			return ErrKeyExists
+		}
+	}
+
+	if err := c.rotateIfNeeded(); err != nil {
 		return err
 	}
 
-	data, err := msgpack.Marshal(msg{Key: key, Value: value, Idx: index})
+	// monotonic clock reading is dropped, so in-memory msg is equal to the one stored on disk
+	m := Msg{Key: key, Value: value, Idx: index, Timestamp: time.Now().Round(0)}
+	data, err := encodeFrame(m, c.codec, c.checksumAlgo, c.disableChecksums)
 	if err != nil {
-		return errors.Wrap(err, "failed to encode msg")
+		return err
 	}
 
 	if _, err := c.log.Write(data); err != nil {
 		return errors.Wrap(err, "failed to write msg to log")
 	}
+	c.segmentCRC = crc32.Update(c.segmentCRC, crc32.IEEETable, data)
 
 	if err := writeChecksum(c.log, c.checksum); err != nil {
 		return errors.Wrap(err, "failed to write checksum")
 	}
 
-	if c.isInSyncDiskMode {
-		if err := c.log.Sync(); err != nil {
-			return errors.Wrap(err, "failed to sync log")
-		}
-		if err := c.checksum.Sync(); err != nil {
-			return errors.Wrap(err, "failed to checksum")
-		}
+	// the frame is already in the segment, so the msg is indexed even if syncing fails
+	var syncErr error
+	c.unflushedBytes += int64(len(data))
+	if c.isInSyncDiskMode || (c.maxUnflushedBytes > 0 && c.unflushedBytes >= c.maxUnflushedBytes) {
+		syncErr = c.sync()
+	}
+
+	m.seg, m.off, m.size = c.activeSegment, c.lastOffset, len(data)
+	if c.disableChecksums {
+		m.unchecked = true
+	} else {
+		m.sum = m.checksum()
 	}
 
-	c.lastOffset += int64(c.buf.Len())
+	c.lastOffset += int64(len(data))
 	c.lastIndex.Add(1)
-	c.buf.Reset()
-	c.index[index] = msg{Key: key, Value: value, Idx: index}
+	c.addToIndex(m)
+	c.addToFilter(m.seg, key)
+	if c.metas[m.seg].records == 1 {
+		c.activeSince = m.Timestamp
+	}
+	c.lastWrite = m.Timestamp
+	c.enforceIndexBudget()
+
+	c.publish(m)
+
+	return syncErr
+}
+
+// sync flushes current segment and its checksum to disk.
+func (c *Wal) sync() error {
+	if err := c.log.Sync(); err != nil {
+		return c.syncFailed(errors.Wrap(err, "failed to sync log"))
+	}
+	if err := c.checksum.Sync(); err != nil {
+		return c.syncFailed(errors.Wrap(err, "failed to checksum"))
+	}
+
+	c.unflushedBytes = 0
 
 	return nil
 }
 
+// ReadFrames returns raw frames of the messages starting from the given index, as they are stored on disk.
+// Frames are concatenated into one bundle no larger than maxBytes (but at least one frame is returned
+// even if it exceeds the limit). The bundle starts with the header holding the WAL ID (see BundleHeaderSize)
+// and every frame carries its own checksum, so the bundle can be shipped over the network as is
+// and verified on the other side with DecodeFrames.
+//
+// It also returns index of the next message to read, pass it as `from` to continue reading.
+func (c *Wal) ReadFrames(from uint64, maxBytes int) ([]byte, uint64, error) {
+	if maxBytes <= 0 {
+		return nil, from, errors.New("maxBytes must be positive")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	bundle := append([]byte(bundleMagic), c.id[:]...)
+	var err error
+	next := from
+	c.index.Range(from, func(m Msg) bool {
+		if len(bundle) > BundleHeaderSize && len(bundle)+m.size > maxBytes {
+			next = m.Idx
+			return false
+		}
+		c.access.touch(m.seg)
+
+		var frame []byte
+		if frame, err = c.readFrameBytes(m); err != nil {
+			return false
+		}
+
+		bundle = append(bundle, frame...)
+		next = m.Idx + 1
+
+		return true
+	})
+	if err != nil {
+		return nil, from, err
+	}
+
+	return bundle, next, nil
+}
+
+// RawIterator returns push-based iterator over raw frames of the WAL messages, as they are stored on disk,
+// along with their indexes. Values are not decoded, so mirroring consumers can ship frames as is and
+// verify them with frame.Decode. Frames are returned from the oldest to the newest.
+//
+// Iteration stops on the first read error, which is returned by the err func.
+// Should be used like this:
+//
+//	frames, errFn := wal.RawIterator()
+//	for idx, frame := range frames {
+//		...
+//	}
+//	if err := errFn(); err != nil {
+//		...
+func (c *Wal) RawIterator() (iter.Seq2[uint64, []byte], func() error) {
+	var iterErr error
+
+	seq := func(yield func(uint64, []byte) bool) {
+		for from := uint64(0); ; {
+			c.mu.RLock()
+			m, ok := c.seek(from)
+			var data []byte
+			if ok {
+				c.access.touch(m.seg)
+				data, iterErr = c.readFrameBytes(m)
+			}
+			c.mu.RUnlock()
+
+			if !ok || iterErr != nil || !yield(m.Idx, data) || m.Idx == math.MaxUint64 {
+				return
+			}
+
+			from = m.Idx + 1
+		}
+	}
+
+	return seq, func() error { return iterErr }
+}
+
+// FramePosition describes where the frame of a msg is stored on disk.
+type FramePosition struct {
+	// Segment is the number of the segment file.
+	Segment int
+
+	// Offset is the offset of the frame in the segment file.
+	Offset int64
+
+	// Size is the size of the frame in bytes.
+	Size int
+}
+
+// Position returns position of the frame of msg with the given index.
+// It returns ErrNotFound if there is no msg with such index.
+func (c *Wal) Position(index uint64) (FramePosition, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m, ok := c.index.Get(index)
+	if !ok {
+		return FramePosition{}, ErrNotFound
+	}
+
+	return FramePosition{Segment: m.seg, Offset: m.off, Size: m.size}, nil
+}
+
+// FrameAt returns raw frame stored in the segment at the given offset, exactly as it is stored on disk.
+// The frame checksum is verified before returning, so replication layers can ship it to followers as is.
+func (c *Wal) FrameAt(segment int, offset int64) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	f, _, release, err := c.segmentReader(segment)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	c.access.touch(segment)
+
+	header := make([]byte, frame.HeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, errors.Wrapf(err, "failed to read frame header at offset %d", offset)
+	}
+
+	data := make([]byte, frame.Size(header))
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, errors.Wrapf(err, "failed to read frame at offset %d", offset)
+	}
+
+	if _, _, err := frame.Decode(data); err != nil {
+		return nil, errors.Wrapf(err, "failed to verify frame at offset %d", offset)
+	}
+
+	return data, nil
+}
+
 // Iterator returns push-based iterator for the WAL messages.
 // Messages are returned from the oldest to the newest.
+// Iteration stops if a msg can't be read from disk (see OffsetOnlyIndex), use Replay or PullIteratorCtx
+// to get the error.
 //
 // Should be used like this:
 //
 //	for msg := range wal.Iterator() {
 //		...
-func (c *Wal) Iterator() iter.Seq[msg] {
-	return func(yield func(msg) bool) {
-		msgIndexes := make([]uint64, 0, len(c.index))
+func (c *Wal) Iterator() iter.Seq[Msg] {
+	return func(yield func(Msg) bool) {
+		_ = c.ascend(0, yield)
+	}
+}
 
-		for k := range c.index {
-			msgIndexes = append(msgIndexes, k)
-		}
+// ScanPrefix returns push-based iterator for the WAL messages whose key starts with the given prefix.
+// Messages are returned from the oldest to the newest.
+func (c *Wal) ScanPrefix(prefix string) iter.Seq[Msg] {
+	return func(yield func(Msg) bool) {
+		_ = c.ascend(0, func(m Msg) bool {
+			if !strings.HasPrefix(m.Key, prefix) {
+				return true
+			}
 
-		sort.Slice(msgIndexes, func(i, j int) bool {
-			return msgIndexes[i] < msgIndexes[j]
+			return yield(m)
 		})
+	}
+}
 
-		for i := 0; i < len(msgIndexes); i++ {
-			if !yield(c.index[msgIndexes[i]]) {
-				break
+// IterateSince returns push-based iterator for the WAL messages written at or after t.
+// Messages are returned from the oldest to the newest (in order of indexes).
+func (c *Wal) IterateSince(t time.Time) iter.Seq[Msg] {
+	return func(yield func(Msg) bool) {
+		_ = c.ascend(0, func(m Msg) bool {
+			if m.Timestamp.Before(t) {
+				return true
 			}
+
+			return yield(m)
+		})
+	}
+}
+
+// Page returns up to limit messages with indexes greater than or equal to from, in ascending order,
+// and the cursor to pass as from to get the next page. Fewer than limit messages means there are no more
+// messages at the moment.
+//
+// Should be used like this:
+//
+//	for cursor := uint64(0); ; {
+//		msgs, next, err := wal.Page(cursor, 100)
+//		...
+//		if len(msgs) < 100 {
+//			break
+//		}
+//		cursor = next
+//	}
+func (c *Wal) Page(from uint64, limit int) ([]Msg, uint64, error) {
+	if limit <= 0 {
+		return nil, from, errors.New("limit must be positive")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	msgs := make([]Msg, 0)
+	var err error
+	c.index.Range(from, func(m Msg) bool {
+		if m, err = c.materialize(m); err != nil {
+			return false
+		}
+		msgs = append(msgs, m)
+
+		return len(msgs) < limit
+	})
+	if err != nil {
+		return nil, from, err
+	}
+
+	next := from
+	if len(msgs) > 0 {
+		next = msgs[len(msgs)-1].Idx + 1
+	}
+
+	return msgs, next, nil
+}
+
+// Replay applies messages starting from fromIndex to apply in ascending order of indexes,
+// stopping on the first error. It returns index of the last successfully applied msg (zero if none
+// was applied) and the error returned by apply, so recovery can be resumed from the next index.
+func (c *Wal) Replay(fromIndex uint64, apply func(Msg) error) (uint64, error) {
+	var (
+		lastApplied uint64
+		applyErr    error
+	)
+
+	err := c.ascend(fromIndex, func(m Msg) bool {
+		if applyErr = apply(m); applyErr != nil {
+			applyErr = errors.Wrapf(applyErr, "failed to apply msg %d", m.Idx)
+			return false
 		}
+
+		lastApplied = m.Idx
+
+		return true
+	})
+	if err != nil {
+		return lastApplied, errors.Wrap(err, "failed to read msg")
 	}
+
+	return lastApplied, applyErr
 }
 
 // PullIterator returns pull-based iterator for the WAL messages.
@@ -222,12 +1419,71 @@ func (c *Wal) Iterator() iter.Seq[msg] {
 //	next, stop := wal.PullIterator()
 //	defer stop()
 //	...
-func (c *Wal) PullIterator() (next func() (msg, bool), stop func()) {
+func (c *Wal) PullIterator() (next func() (Msg, bool), stop func()) {
 	return iter.Pull(c.Iterator())
 }
 
+// PullIteratorCtx works like PullIterator, but next returns ctx.Err() as soon as ctx is cancelled,
+// so long replays can be interrupted. next returns false when there are no more messages or ctx is done.
+//
+// Should be used like this:
+//
+//	next, stop := wal.PullIteratorCtx(ctx)
+//	defer stop()
+//	for {
+//		msg, ok, err := next()
+//		...
+func (c *Wal) PullIteratorCtx(ctx context.Context) (next func() (Msg, bool, error), stop func()) {
+	pull, stop := iter.Pull2(func(yield func(Msg, error) bool) {
+		if err := c.ascend(0, func(m Msg) bool { return yield(m, nil) }); err != nil {
+			yield(Msg{}, err)
+		}
+	})
+
+	next = func() (Msg, bool, error) {
+		if err := ctx.Err(); err != nil {
+			stop()
+			return Msg{}, false, err
+		}
+
+		m, err, ok := pull()
+		if err != nil {
+			stop()
+			return Msg{}, false, err
+		}
+
+		return m, ok, nil
+	}
+
+	return next, stop
+}
+
 // Close closes log and checksum files.
+// Channels of all active subscriptions are closed.
 func (c *Wal) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		close(c.stop)
+	}
+	c.closed = true
+	c.segmentRemoved.Broadcast()
+	c.closeSubscriptions()
+	c.closeSegmentFiles()
+
+	if c.annotationsLog != nil {
+		if err := c.annotationsLog.Close(); err != nil {
+			return errors.Wrap(err, "failed to close annotations file")
+		}
+	}
+
+	if c.checkpointsLog != nil {
+		if err := c.checkpointsLog.Close(); err != nil {
+			return errors.Wrap(err, "failed to close checkpoints file")
+		}
+	}
+
 	if err := c.log.Close(); err != nil {
 		return errors.Wrap(err, "failed to close log log file")
 	}
@@ -236,5 +1492,11 @@ func (c *Wal) Close() error {
 		return errors.Wrap(err, "failed to close checksum file")
 	}
 
+	if c.snapshotIndex && c.loadErr == nil {
+		if err := c.writeSnapshot(); err != nil {
+			return errors.Wrap(err, "failed to snapshot index")
+		}
+	}
+
 	return nil
 }
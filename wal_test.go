@@ -2,13 +2,20 @@ package gowal
 
 import (
 	"cmp"
+	"context"
+	"errors"
 	"github.com/stretchr/testify/require"
+	"github.com/vadiminshakov/gowal/frame"
 	"maps"
+	"math"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestWriteAndGet(t *testing.T) {
@@ -75,6 +82,25 @@ func TestIterator(t *testing.T) {
 		}
 	})
 
+	t.Run("PullIteratorCtx", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		next, stop := log.PullIteratorCtx(ctx)
+		defer stop()
+
+		for i := 0; i < 5; i++ {
+			msg, ok, err := next()
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "key"+strconv.Itoa(i), msg.Key)
+		}
+
+		cancel()
+
+		_, ok, err := next()
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, ok)
+	})
+
 	require.NoError(t, os.RemoveAll("./testlogdata"))
 }
 
@@ -92,7 +118,7 @@ func TestLoadIndex(t *testing.T) {
 		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
 	}
 
-	index, err := loadIndexes(log.log)
+	index, err := loadIndexes(log.log, defaultCodec)
 	require.NoError(t, err)
 
 	for i := 0; i < 10; i++ {
@@ -122,13 +148,13 @@ func TestSegmentRotation(t *testing.T) {
 	}
 
 	// load index of last segment
-	index, err := loadIndexes(log.log)
+	index, err := loadIndexes(log.log, defaultCodec)
 	require.NoError(t, err)
 
 	// check
 	indexValues := slices.Collect(maps.Values(index))
 
-	slices.SortFunc(indexValues, func(a, b msg) int {
+	slices.SortFunc(indexValues, func(a, b Msg) int {
 		return cmp.Compare(a.Idx, b.Idx)
 	})
 
@@ -173,8 +199,8 @@ func TestServiceDownUpAndRepairIndex(t *testing.T) {
 
 	// check
 	for i := 0; i < segmentThreshold+(segmentThreshold/2); i++ {
-		require.Equal(t, "key"+strconv.Itoa(i), log.index[uint64(i)].Key)
-		require.Equal(t, "value"+strconv.Itoa(i), string(log.index[uint64(i)].Value))
+		require.Equal(t, "key"+strconv.Itoa(i), indexed(log, uint64(i)).Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(indexed(log, uint64(i)).Value))
 	}
 
 	require.NoError(t, os.RemoveAll("./testlogdata"))
@@ -247,7 +273,7 @@ func TestChecksum_Check_Checksum_Files(t *testing.T) {
 			continue
 		}
 
-		if strings.Contains(f.Name(), "checksum") {
+		if strings.Contains(f.Name(), "checksum") || strings.HasSuffix(f.Name(), manifestPostfix) || strings.HasSuffix(f.Name(), currentPostfix) || strings.HasSuffix(f.Name(), sidecarPostfix) {
 			continue
 		}
 
@@ -282,7 +308,2187 @@ func TestChecksum_UnsafeRecover(t *testing.T) {
 	removedFiles, err := UnsafeRecover("./testlogdata", "log_")
 	require.NoError(t, err)
 	require.Equal(t, 2, len(removedFiles))
-	require.ElementsMatch(t, []string{"testlogdata/log_4", "testlogdata/log_4.checksum"}, removedFiles)
+	require.ElementsMatch(t, []string{"testlogdata/log_000000004", "testlogdata/log_000000004.checksum"}, removedFiles)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestUnsafeRecoverQuarantine(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	log.log.Write([]byte("corrupted data"))
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile("testlogdata/log_000000004")
+	require.NoError(t, err)
+
+	removedFiles, err := UnsafeRecoverWithOptions("./testlogdata", "log_", RecoverOptions{Quarantine: true})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"testlogdata/log_000000004", "testlogdata/log_000000004.checksum"}, removedFiles)
+
+	// the corrupted segment is kept as is for inspection
+	_, err = os.Stat("testlogdata/log_000000004")
+	require.True(t, os.IsNotExist(err))
+	quarantined, err := os.ReadFile("testlogdata/quarantine/log_000000004")
+	require.NoError(t, err)
+	require.Equal(t, data, quarantined)
+	_, err = os.Stat("testlogdata/quarantine/log_000000004.checksum")
+	require.NoError(t, err)
+
+	msgs, _, err := Salvage("./testlogdata/quarantine", "log_")
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+
+	// the quarantine dir doesn't prevent the WAL from opening
+	log, err = NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 2, MaxSegments: 5})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestReadFrames(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	t.Run("all frames", func(t *testing.T) {
+		bundle, next, err := log.ReadFrames(2, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), next)
+
+		msgs, err := DecodeFrames(bundle, log.Stats().ID)
+		require.NoError(t, err)
+		require.Len(t, msgs, 8)
+		for i, m := range msgs {
+			require.Equal(t, uint64(i+2), m.Idx)
+			require.Equal(t, "key"+strconv.Itoa(i+2), m.Key)
+			require.Equal(t, "value"+strconv.Itoa(i+2), string(m.Value))
+		}
+	})
+
+	t.Run("limited by size", func(t *testing.T) {
+		var read []Msg
+		from := uint64(0)
+		for from < 10 {
+			bundle, next, err := log.ReadFrames(from, 1)
+			require.NoError(t, err)
+			require.Equal(t, from+1, next)
+
+			msgs, err := DecodeFrames(bundle, log.Stats().ID)
+			require.NoError(t, err)
+			read = append(read, msgs...)
+			from = next
+		}
+		require.Len(t, read, 10)
+	})
+
+	t.Run("decode with frame package", func(t *testing.T) {
+		bundle, _, err := log.ReadFrames(0, 1<<20)
+		require.NoError(t, err)
+
+		bundle = bundle[BundleHeaderSize:]
+		for i := 0; i < 10; i++ {
+			r, n, err := frame.DecodeFrame(bundle)
+			require.NoError(t, err)
+			require.Equal(t, uint64(i), r.Idx)
+			require.Equal(t, "key"+strconv.Itoa(i), r.Key)
+			require.Equal(t, "value"+strconv.Itoa(i), string(r.Value))
+			bundle = bundle[n:]
+		}
+	})
+
+	t.Run("corrupted bundle", func(t *testing.T) {
+		bundle, _, err := log.ReadFrames(0, 1<<20)
+		require.NoError(t, err)
+
+		bundle[len(bundle)-1] ^= 0xff
+		_, err = DecodeFrames(bundle, log.Stats().ID)
+		require.ErrorIs(t, err, ErrCorruptedFrame)
+	})
+
+	t.Run("bundle of another WAL", func(t *testing.T) {
+		bundle, _, err := log.ReadFrames(0, 1<<20)
+		require.NoError(t, err)
+
+		id, err := BundleID(bundle)
+		require.NoError(t, err)
+		require.Equal(t, log.Stats().ID, id)
+
+		other, err := newID()
+		require.NoError(t, err)
+		_, err = DecodeFrames(bundle, formatID(other))
+		require.ErrorIs(t, err, ErrForeignBundle)
+
+		_, err = DecodeFrames(bundle[BundleHeaderSize:], log.Stats().ID)
+		require.ErrorIs(t, err, ErrMalformedBundle)
+	})
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestExists(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	for i := 0; i < 5; i++ {
+		require.True(t, log.Exists(uint64(i)))
+	}
+	require.False(t, log.Exists(5))
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestStats_ID(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	id := log.Stats().ID
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, id)
+
+	require.NoError(t, log.Write(0, "key", []byte("value")))
+	require.NoError(t, log.Close())
+
+	// ID survives restarts
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, id, log.Stats().ID)
+	require.Equal(t, 1, log.Stats().Records)
+	require.NoError(t, log.Close())
+
+	// another WAL gets another ID
+	other, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "other_", SegmentThreshold: 10, MaxSegments: 5})
+	require.NoError(t, err)
+	require.NotEqual(t, id, other.Stats().ID)
+	require.NoError(t, other.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestForeignSegment(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	require.NoError(t, log.Write(0, "key", []byte("value")))
+	require.NoError(t, log.Close())
+
+	foreignCfg := cfg
+	foreignCfg.Dir = "./testlogdata/foreign"
+	foreign, err := NewWAL(foreignCfg)
+	require.NoError(t, err)
+	require.NoError(t, foreign.Write(1, "key", []byte("value")))
+	require.NoError(t, foreign.Close())
+
+	// copy segment of another WAL into the directory
+	for _, postfix := range []string{"", checkSumPostfix} {
+		data, err := os.ReadFile("./testlogdata/foreign/" + segmentName("log_", 0) + postfix)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile("./testlogdata/"+segmentName("log_", 1)+postfix, data, 0755))
+	}
+
+	_, err = NewWAL(cfg)
+	require.ErrorIs(t, err, ErrForeignSegment)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestLenAndSizeBytes(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	require.Equal(t, 5, log.Len())
+
+	var expected int64
+	entries, err := os.ReadDir("./testlogdata")
+	require.NoError(t, err)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), manifestPostfix) || strings.HasSuffix(e.Name(), currentPostfix) {
+			continue
+		}
+		info, err := e.Info()
+		require.NoError(t, err)
+		expected += info.Size()
+	}
+
+	size, err := log.SizeBytes()
+	require.NoError(t, err)
+	require.Equal(t, expected, size)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestGetMsg(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Write(0, "", nil))
+	require.NoError(t, log.Write(1, "key", []byte("value")))
+
+	m, err := log.GetMsg(0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), m.Idx)
+	require.Empty(t, m.Key)
+	require.Empty(t, m.Value)
+
+	m, err = log.GetMsg(1)
+	require.NoError(t, err)
+	require.Equal(t, "key", m.Key)
+	require.Equal(t, "value", string(m.Value))
+
+	_, err = log.GetMsg(2)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMaxUnflushedBytes(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:               "./testlogdata",
+		Prefix:            "log_",
+		SegmentThreshold:  10,
+		MaxSegments:       5,
+		IsInSyncDiskMode:  false,
+		MaxUnflushedBytes: 100,
+	})
+	require.NoError(t, err)
+
+	var written int64
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+
+		written += int64(indexed(log, uint64(i)).size)
+		if written >= 100 {
+			require.Zero(t, log.unflushedBytes)
+			written = 0
+			continue
+		}
+		require.Equal(t, written, log.unflushedBytes)
+	}
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestView(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Write(0, "key", []byte("value")))
+
+	var viewed string
+	require.NoError(t, log.View(0, func(key string, value []byte) error {
+		viewed = key + "=" + string(value)
+		return nil
+	}))
+	require.Equal(t, "key=value", viewed)
+
+	errStop := errors.New("stop")
+	require.ErrorIs(t, log.View(0, func(string, []byte) error { return errStop }), errStop)
+	require.ErrorIs(t, log.View(1, func(string, []byte) error { return nil }), ErrNotFound)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestFrameAt(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	for i := 0; i < 10; i++ {
+		pos, err := log.Position(uint64(i))
+		require.NoError(t, err)
+
+		data, err := log.FrameAt(pos.Segment, pos.Offset)
+		require.NoError(t, err)
+		require.Len(t, data, pos.Size)
+
+		r, _, err := frame.DecodeFrame(data)
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), r.Idx)
+		require.Equal(t, "value"+strconv.Itoa(i), string(r.Value))
+	}
+
+	_, err = log.Position(10)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestOrderedIndex(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 100,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	_, ok := log.FirstIndex()
+	require.False(t, ok)
+
+	// write indexes out of order
+	for _, i := range []int{5, 1, 9, 3, 7, 0, 2, 8, 4, 6} {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	first, ok := log.FirstIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(0), first)
+
+	last, ok := log.LastIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(9), last)
+
+	i := 0
+	for m := range log.Iterator() {
+		require.Equal(t, uint64(i), m.Idx)
+		i++
+	}
+	require.Equal(t, 10, i)
+
+	// writes during iteration are seen by the iterator
+	i = 0
+	for m := range log.Iterator() {
+		if m.Idx == 9 {
+			require.NoError(t, log.Write(10, "key10", []byte("value10")))
+		}
+		require.Equal(t, uint64(i), m.Idx)
+		i++
+	}
+	require.Equal(t, 11, i)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestUniqueKeys(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		UniqueKeys:       true,
+	}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, log.Write(0, "key0", []byte("value0")))
+	require.NoError(t, log.Write(1, "key1", []byte("value1")))
+	require.ErrorIs(t, log.Write(2, "key0", []byte("value2")), ErrKeyExists)
+	require.False(t, log.Exists(2))
+	require.NoError(t, log.Close())
+
+	// key index is restored on init
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.ErrorIs(t, log.Write(2, "key1", []byte("value2")), ErrKeyExists)
+	require.NoError(t, log.Write(2, "key2", []byte("value2")))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestReplay(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	var applied []uint64
+	last, err := log.Replay(3, func(m Msg) error {
+		applied = append(applied, m.Idx)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), last)
+	require.Equal(t, []uint64{3, 4, 5, 6, 7, 8, 9}, applied)
+
+	errApply := errors.New("apply failed")
+	last, err = log.Replay(0, func(m Msg) error {
+		if m.Idx == 5 {
+			return errApply
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errApply)
+	require.Equal(t, uint64(4), last)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestScanPrefix(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		tenant := "tenant" + strconv.Itoa(i%2) + "/"
+		require.NoError(t, log.Write(uint64(i), tenant+"key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	var seen []uint64
+	for m := range log.ScanPrefix("tenant1/") {
+		require.True(t, strings.HasPrefix(m.Key, "tenant1/"))
+		seen = append(seen, m.Idx)
+	}
+	require.Equal(t, []uint64{1, 3, 5, 7, 9}, seen)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestIterateSince(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+
+	for i := 5; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	// timestamps are persisted
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	var seen []uint64
+	for m := range log.IterateSince(since) {
+		require.False(t, m.Timestamp.Before(since))
+		seen = append(seen, m.Idx)
+	}
+	require.Equal(t, []uint64{5, 6, 7, 8, 9}, seen)
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRawIterator(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	frames, errFn := log.RawIterator()
+
+	i := 0
+	for idx, data := range frames {
+		require.Equal(t, uint64(i), idx)
+
+		r, n, err := frame.DecodeFrame(data)
+		require.NoError(t, err)
+		require.Len(t, data, n)
+		require.Equal(t, "value"+strconv.Itoa(i), string(r.Value))
+		i++
+	}
+	require.NoError(t, errFn())
+	require.Equal(t, 10, i)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestPage(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	var (
+		seen   []uint64
+		pages  int
+		cursor uint64
+	)
+	for {
+		msgs, next, err := log.Page(cursor, 3)
+		require.NoError(t, err)
+		pages++
+
+		for _, m := range msgs {
+			seen = append(seen, m.Idx)
+		}
+
+		if len(msgs) < 3 {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, seen)
+	require.Equal(t, 4, pages)
+
+	_, _, err = log.Page(0, 0)
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestOffsetOnlyIndex(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	check := func(log *Wal) {
+		log.index.Range(0, func(m Msg) bool {
+			require.Empty(t, m.Value)
+			return true
+		})
+
+		key, value, ok := log.Get(5)
+		require.True(t, ok)
+		require.Equal(t, "key5", key)
+		require.Equal(t, "value5", string(value))
+
+		i := 0
+		for m := range log.Iterator() {
+			require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+			i++
+		}
+		require.Equal(t, 10, i)
+
+		cur := log.Cursor()
+		require.True(t, cur.Last())
+		require.Equal(t, "value9", string(cur.Value().Value))
+
+		msgs, _, err := log.Page(7, 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 3)
+		require.Equal(t, "value7", string(msgs[0].Value))
+	}
+	check(log)
+
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	check(log)
+
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = log.GetMsg(4)
+	require.ErrorIs(t, err, ErrCorruptedFrame)
+	require.Equal(t, uint64(1), log.Stats().DiskCorruptions)
+
+	_, err = log.Replay(0, func(Msg) error { return nil })
+	require.ErrorIs(t, err, ErrCorruptedFrame)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestDisableChecksums(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		DisableChecksums: true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	_, value, ok := log.Get(3)
+	require.True(t, ok)
+	require.Equal(t, "value3", string(value))
+	require.Zero(t, log.Stats().MemoryCorruptions)
+
+	// msgs written without checksums are read back by the WAL with checksums enabled
+	require.NoError(t, log.Close())
+	cfg.DisableChecksums = false
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+
+	data, _, err := log.ReadFrames(0, 1<<20)
+	require.NoError(t, err)
+	msgs, err := DecodeFrames(data, log.Stats().ID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 5)
+
+	pos, err := log.Position(4)
+	require.NoError(t, err)
+	data, err = log.FrameAt(pos.Segment, pos.Offset)
+	require.NoError(t, err)
+	require.Len(t, data, pos.Size)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 10,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+
+	// every restart writes msgs with another algorithm, all of them are read back and verified
+	for n, checksum := range []Checksum{CRC32IEEE, CRC32Castagnoli, XXHash64} {
+		cfg.Checksum = checksum
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+
+		for i := n * 5; i < n*5+5; i++ {
+			require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		}
+		require.NoError(t, log.Close())
+	}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 15; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+
+	data, _, err := log.ReadFrames(0, 1<<20)
+	require.NoError(t, err)
+	msgs, err := DecodeFrames(data, log.Stats().ID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 15)
+
+	report, err := log.Verify()
+	require.NoError(t, err)
+	require.True(t, report.OK())
+	require.Equal(t, 15, report.Records)
+	require.NoError(t, log.Close())
+
+	_, err = NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 10, MaxSegments: 5, Checksum: 3})
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestWarm(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	require.NoError(t, <-log.Warm(context.Background(), 2, 8))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, <-log.Warm(ctx, 0, 9), context.Canceled)
+
+	pos, err := log.Position(5)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, <-log.Warm(context.Background(), 0, 4))
+	require.ErrorIs(t, <-log.Warm(context.Background(), 0, 9), ErrCorruptedFrame)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestWarmHotSegments(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      10,
+		IsInSyncDiskMode: false,
+		HotSegments:      2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// the greatest possible index doesn't make warming wrap around
+	require.NoError(t, <-log.Warm(context.Background(), 6, math.MaxUint64))
+
+	pos, err := log.Position(1)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(log.segmentPath(pos.Segment), os.O_RDWR, 0755)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), pos.Offset+int64(pos.Size)-7)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// msgs of cold segments are read from disk even without OffsetOnlyIndex
+	require.ErrorIs(t, <-log.Warm(context.Background(), 0, 2), ErrCorruptedFrame)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSidecar(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	sum, err := os.ReadFile(log.segmentPath(0) + checkSumPostfix)
+	require.NoError(t, err)
+	index, filter, err := readSidecar(log.segmentPath(0), sum)
+	require.NoError(t, err)
+	require.Len(t, index, 3)
+	require.True(t, filter.mayContain("key1"))
+
+	check := func() {
+		log, err := NewWAL(cfg)
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			_, value, ok := log.Get(uint64(i))
+			require.True(t, ok)
+			require.Equal(t, "value"+strconv.Itoa(i), string(value))
+		}
+		require.NoError(t, log.Close())
+	}
+	check()
+
+	// corrupted sidecar is ignored
+	require.NoError(t, os.WriteFile(log.segmentPath(1)+sidecarPostfix, []byte("garbage"), 0755))
+	check()
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegments(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	_, _, ok := log.Get(1)
+	require.True(t, ok)
+	_, _, ok = log.Get(2)
+	require.True(t, ok)
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+
+	require.Equal(t, 0, segments[0].Number)
+	require.Equal(t, 3, segments[0].Records)
+	require.Equal(t, uint64(0), segments[0].FirstIndex)
+	require.Equal(t, uint64(2), segments[0].LastIndex)
+	require.Equal(t, uint64(2), segments[0].Reads)
+	require.False(t, segments[0].LastAccess.IsZero())
+	require.Positive(t, segments[0].Size)
+	require.False(t, segments[0].Active)
+
+	require.Zero(t, segments[1].Reads)
+	require.True(t, segments[1].LastAccess.IsZero())
+
+	require.True(t, segments[2].Active)
+	require.Equal(t, 1, segments[2].Records)
+	require.Equal(t, uint64(6), segments[2].FirstIndex)
+
+	// the same layout is described without opening the WAL
+	listed, err := ListSegments("./testlogdata", "log_")
+	require.NoError(t, err)
+	for i := range segments {
+		segments[i].Reads, segments[i].LastAccess = 0, time.Time{}
+	}
+	require.Equal(t, segments, listed)
+	require.NoError(t, log.Close())
+
+	// nothing is changed on disk: unpadded names of older versions are kept, missing dir is not created
+	require.NoError(t, os.Rename("./testlogdata/log_000000000", "./testlogdata/log_0"))
+	listed, err = ListSegments("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Equal(t, "testlogdata/log_0", listed[0].Path)
+	require.Equal(t, 3, listed[0].Records)
+	_, err = os.Stat("./testlogdata/log_0")
+	require.NoError(t, err)
+
+	_, err = ListSegments("./testlogdata/missing", "log_")
+	require.Error(t, err)
+	_, err = os.Stat("./testlogdata/missing")
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestGetByKey(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		if i == 1 || i == 7 {
+			key = "dup"
+		}
+		require.NoError(t, log.Write(uint64(i), key, []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	m, err := log.GetByKey("dup")
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), m.Idx)
+	require.Equal(t, "value7", string(m.Value))
+
+	m, err = log.GetByKey("key4")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), m.Idx)
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	reads := uint64(0)
+	for _, s := range segments {
+		reads += s.Reads
+	}
+
+	// segments without the key are skipped
+	_, err = log.GetByKey("missing")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	segments, err = log.Segments()
+	require.NoError(t, err)
+	for _, s := range segments {
+		reads -= s.Reads
+	}
+	require.Zero(t, reads)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestKeyFiltersWithoutThreshold(t *testing.T) {
+	cfg := Config{
+		Dir:             "./testlogdata",
+		Prefix:          "log_",
+		SegmentMaxBytes: 128 << 10,
+		MaxSegments:     10,
+		OffsetOnlyIndex: true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 7000; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("v")))
+	}
+	require.NoError(t, log.Close())
+
+	// filters of sealed segments are sized for the number of their keys, not for the default
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Greater(t, len(log.segments), 2)
+
+	var checks, positives int
+	for _, seg := range log.segments[:len(log.segments)-1] {
+		require.Greater(t, log.metas[seg].records, defaultFilterKeys)
+		for i := 0; i < 1000; i++ {
+			checks++
+			if log.filters[seg].mayContain("missing" + strconv.Itoa(i)) {
+				positives++
+			}
+		}
+	}
+	require.Less(t, positives, checks/20)
+
+	m, err := log.GetByKey("key42")
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), m.Idx)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMaxIndexMemoryBytes(t *testing.T) {
+	cfg := Config{
+		Dir:                 "./testlogdata",
+		Prefix:              "log_",
+		SegmentThreshold:    5,
+		MaxSegments:         10,
+		IsInSyncDiskMode:    false,
+		MaxIndexMemoryBytes: 8000,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	value := func(i int) []byte {
+		return []byte(strings.Repeat(strconv.Itoa(i%10), 1000))
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), value(i)))
+	}
+
+	check := func(log *Wal) {
+		stats := log.Stats()
+		require.LessOrEqual(t, stats.IndexMemoryBytes, cfg.MaxIndexMemoryBytes)
+		require.Positive(t, stats.OffsetOnlySegments)
+
+		require.True(t, indexed(log, 0).onDisk)
+		require.False(t, indexed(log, 19).onDisk)
+
+		for i := 0; i < 20; i++ {
+			_, v, ok := log.Get(uint64(i))
+			require.True(t, ok)
+			require.Equal(t, value(i), v)
+		}
+	}
+	check(log)
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	check(log)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMaxIndexMemoryBytesActiveSegment(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                 "./testlogdata",
+		Prefix:              "log_",
+		SegmentThreshold:    5,
+		MaxSegments:         10,
+		MaxIndexMemoryBytes: 1000,
+	})
+	require.NoError(t, err)
+
+	// the active segment alone exceeds the budget, it is kept in memory and only sealed segments are stripped
+	for i := 0; i < 13; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte(strings.Repeat("v", 1000))))
+
+		log.mu.RLock()
+		var resident int64
+		for seg, meta := range log.metas {
+			resident += meta.resident
+			require.Equal(t, seg == log.activeSegment, meta.resident > 0, seg)
+		}
+		require.Equal(t, log.residentBytes, resident)
+		log.mu.RUnlock()
+	}
+	require.Greater(t, log.Stats().IndexMemoryBytes, int64(1000))
+	require.Equal(t, 2, log.Stats().OffsetOnlySegments)
+	require.False(t, indexed(log, 12).onDisk)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestReadCache(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+		ReadCacheSize:    2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	for i := 0; i < 3; i++ {
+		_, value, ok := log.Get(5)
+		require.True(t, ok)
+		require.Equal(t, "value5", string(value))
+	}
+	require.Equal(t, uint64(2), log.Stats().CacheHits)
+	require.Equal(t, uint64(1), log.Stats().CacheMisses)
+
+	// corrupted cached copy is read from disk again
+	log.cache.entries[5].Value.(Msg).Value[0] ^= 0x01
+	_, value, ok := log.Get(5)
+	require.True(t, ok)
+	require.Equal(t, "value5", string(value))
+	require.Equal(t, uint64(2), log.Stats().CacheMisses)
+
+	// least recently used msg is evicted
+	log.Get(6)
+	log.Get(7)
+	require.Len(t, log.cache.entries, 2)
+	require.NotContains(t, log.cache.entries, uint64(5))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestNewWALWithProfile(t *testing.T) {
+	for _, p := range []Profile{ProfileDurable, ProfileBalanced, ProfileThroughput} {
+		t.Run(p.String(), func(t *testing.T) {
+			log, err := NewWALWithProfile("./testlogdata", p)
+			require.NoError(t, err)
+
+			require.NoError(t, log.Write(1, "key", []byte("value")))
+			_, value, ok := log.Get(1)
+			require.True(t, ok)
+			require.Equal(t, "value", string(value))
+
+			require.NoError(t, log.Close())
+			require.NoError(t, os.RemoveAll("./testlogdata"))
+		})
+	}
+
+	_, err := NewWALWithProfile("./testlogdata", Profile(42))
+	require.Error(t, err)
+}
+
+func TestMmapSegments(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+		MmapSegments:     true,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	var values [][]byte
+	for m := range log.Iterator() {
+		require.Equal(t, "value"+strconv.Itoa(int(m.Idx)), string(m.Value))
+		values = append(values, m.Value)
+	}
+	require.Len(t, values, 10)
+	require.NotEmpty(t, log.readers)
+
+	data, _, err := log.ReadFrames(0, 1<<20)
+	require.NoError(t, err)
+	msgs, err := DecodeFrames(data, log.Stats().ID)
+	require.NoError(t, err)
+	require.Len(t, msgs, 10)
+
+	// values don't point into the mappings
+	require.NoError(t, log.Close())
+	require.Empty(t, log.readers)
+	require.Equal(t, "value0", string(values[0]))
+	require.Equal(t, "value0", string(msgs[0].Value))
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMaxOpenSegments(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+		OffsetOnlyIndex:  true,
+		MaxOpenSegments:  2,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 20; i++ {
+			_, value, ok := log.Get(uint64(i))
+			require.True(t, ok)
+			require.Equal(t, "value"+strconv.Itoa(i), string(value))
+			require.LessOrEqual(t, len(log.readers), 2)
+		}
+	}
+
+	pos, err := log.Position(0)
+	require.NoError(t, err)
+	_, err = log.FrameAt(pos.Segment, pos.Offset)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(log.readers), 2)
+	require.Equal(t, len(log.readers), log.openReaders.Len())
+
+	require.NoError(t, log.Close())
+	require.Empty(t, log.readers)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestLazyLoad(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	cfg.LazyLoad = true
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), log.CurrentIndex())
+
+	require.ErrorIs(t, log.Write(0, "key0", []byte("value0")), ErrExists)
+	require.Equal(t, 10, log.Len())
+
+	key, value, ok := log.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "key1", key)
+	require.Equal(t, "value1", string(value))
+
+	var n int
+	for m := range log.Iterator() {
+		require.Equal(t, "value"+strconv.Itoa(int(m.Idx)), string(m.Value))
+		n++
+	}
+	require.Equal(t, 10, n)
+
+	require.NoError(t, log.Write(10, "key10", []byte("value10")))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSnapshotIndex(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		SnapshotIndex:    true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	segmentsNumbers, err := findSegmentNumber(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	index, _, _, err := readSnapshot(cfg.Dir, cfg.Prefix, log.id, segmentsNumbers)
+	require.NoError(t, err)
+	require.Len(t, index, 10)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 10, log.Len())
+	for i := 0; i < 10; i++ {
+		key, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "key"+strconv.Itoa(i), key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.ErrorIs(t, log.Write(9, "key9", []byte("value9")), ErrExists)
+	require.NoError(t, log.Write(10, "key10", []byte("value10")))
+	require.NoError(t, log.Close())
+
+	// snapshot is stale after writes without snapshotting
+	cfg.SnapshotIndex = false
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.NoError(t, log.Write(11, "key11", []byte("value11")))
+	require.NoError(t, log.Close())
+
+	segmentsNumbers, err = findSegmentNumber(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	_, _, _, err = readSnapshot(cfg.Dir, cfg.Prefix, log.id, segmentsNumbers)
+	require.ErrorIs(t, err, errBadSnapshot)
+
+	cfg.SnapshotIndex = true
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	_, value, ok := log.Get(11)
+	require.True(t, ok)
+	require.Equal(t, "value11", string(value))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+// indexed returns msg kept in the index, the zero Msg if there is no msg with such index.
+func indexed(log *Wal, idx uint64) Msg {
+	m, _ := log.index.Get(idx)
+	return m
+}
+
+// forwardIndex hides RangeReverse of the default index.
+type forwardIndex struct {
+	Index
+}
+
+func TestNewIndex(t *testing.T) {
+	var created int
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+		NewIndex: func() Index {
+			created++
+			return forwardIndex{newMapIndex()}
+		},
+	})
+	require.NoError(t, err)
+	require.Positive(t, created)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	_, ok := log.index.(forwardIndex)
+	require.True(t, ok)
+	require.Equal(t, 10, log.Len())
+
+	last, ok := log.LastIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(9), last)
+
+	cur := log.Cursor()
+	var indexes []uint64
+	for ok := cur.Last(); ok; ok = cur.Prev() {
+		indexes = append(indexes, cur.Value().Idx)
+	}
+	require.Equal(t, []uint64{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, indexes)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestGaps(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      5,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+	require.Empty(t, log.Gaps())
+
+	for _, i := range []uint64{0, 1, 5, 6, 9} {
+		require.NoError(t, log.Write(i, "key"+strconv.Itoa(int(i)), []byte("value")))
+	}
+	require.Equal(t, []IndexRange{{From: 2, To: 4}, {From: 7, To: 8}}, log.Gaps())
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentRotationAfterRestart(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      3,
+		IsInSyncDiskMode: false,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	// only msgs of the removed segment are dropped
+	require.Equal(t, 7, log.Len())
+	first, ok := log.FirstIndex()
+	require.True(t, ok)
+	require.Equal(t, uint64(3), first)
+	require.NoError(t, log.Close())
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 10; i < 15; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	segmentsNumbers, err := findSegmentNumber(cfg.Dir, cfg.Prefix)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3, 4}, segmentsNumbers)
+	require.Equal(t, 9, log.Len())
+
+	for i := 6; i < 15; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.False(t, log.Exists(5))
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentMeta(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      2,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 8; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, []uint64{3, 5, 6, 7}, []uint64{segments[0].FirstIndex, segments[0].LastIndex,
+		segments[1].FirstIndex, segments[1].LastIndex})
+	require.Equal(t, []int{3, 2}, []int{segments[0].Records, segments[1].Records})
+
+	require.False(t, log.mayContainIndex(1))
+	require.True(t, log.mayContainIndex(4))
+	require.False(t, log.mayContainIndex(100))
+
+	_, err = log.GetMsg(1)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestInternKeysAndValueArena(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:                 "./testlogdata",
+		Prefix:              "log_",
+		SegmentThreshold:    10,
+		MaxSegments:         5,
+		IsInSyncDiskMode:    false,
+		InternKeys:          true,
+		ValueArenaChunkSize: 1024,
+	})
+	require.NoError(t, err)
+
+	value := []byte("value")
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), strings.Clone("key"), value))
+	}
+	// values are copied into the arena
+	value[0] = 'X'
+
+	first, second := indexed(log, 0), indexed(log, 1)
+	require.Equal(t, unsafe.StringData(first.Key), unsafe.StringData(second.Key))
+	require.Equal(t, "value", string(first.Value))
+	require.Equal(t, len(first.Value), cap(first.Value))
+	// values are allocated next to each other
+	require.Equal(t, uintptr(unsafe.Pointer(&first.Value[0]))+uintptr(len(first.Value)), uintptr(unsafe.Pointer(&second.Value[0])))
+
+	// checksums still match
+	_, v, ok := log.Get(4)
+	require.True(t, ok)
+	require.Equal(t, "value", string(v))
+	require.Zero(t, log.Stats().MemoryCorruptions)
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestHotSegments(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 3,
+		MaxSegments:      10,
+		IsInSyncDiskMode: false,
+		HotSegments:      2,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 12; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	onDisk := func(idx uint64) bool {
+		log.mu.RLock()
+		defer log.mu.RUnlock()
+		return indexed(log, idx).onDisk
+	}
+	require.True(t, onDisk(0))
+	require.True(t, onDisk(5))
+	require.False(t, onDisk(6))
+	require.False(t, onDisk(11))
+	require.Equal(t, 2, log.Stats().ColdSegments)
+	require.Zero(t, log.Stats().OffsetOnlySegments)
+
+	// reading a cold segment promotes it and demotes the segment that became hot earliest
+	_, value, ok := log.Get(0)
+	require.True(t, ok)
+	require.Equal(t, "value0", string(value))
+	require.Eventually(t, func() bool { return !onDisk(0) }, time.Second, 10*time.Millisecond)
+	require.True(t, onDisk(6))
+	require.False(t, onDisk(11))
+	require.NoError(t, log.Close())
+
+	// only the newest segments are hot after restart
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.True(t, onDisk(0))
+	require.False(t, onDisk(6))
+	for i := 0; i < 12; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentMaxBytes(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 1000,
+		SegmentMaxBytes:  1024,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	value := make([]byte, 300)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), value))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Greater(t, len(segments), 2)
+	for _, s := range segments[:len(segments)-1] {
+		// rotated as soon as the limit is reached, so only the last msg crosses it
+		require.GreaterOrEqual(t, s.Size, int64(1024))
+		require.Less(t, s.Size, int64(1024+400))
+	}
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentMaxBytesWithoutThreshold(t *testing.T) {
+	_, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", MaxSegments: 100})
+	require.Error(t, err)
+
+	log, err := NewWAL(Config{
+		Dir:             "./testlogdata",
+		Prefix:          "log_",
+		SegmentMaxBytes: 1024,
+		MaxSegments:     100,
+	})
+	require.NoError(t, err)
+
+	// zero SegmentThreshold doesn't rotate the segment on every write
+	value := make([]byte, 300)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), value))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 4)
+	for _, s := range segments[:len(segments)-1] {
+		require.Equal(t, 3, s.Records)
+	}
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentMaxAge(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 1000,
+		SegmentMaxAge:    50 * time.Millisecond,
+		MaxSegments:      100,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, log.Write(0, "key0", []byte("value0")))
+
+	// rotated in the background without writes
+	require.Eventually(t, func() bool {
+		segments, err := log.Segments()
+		require.NoError(t, err)
+		return len(segments) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// empty segment is not rotated
+	time.Sleep(100 * time.Millisecond)
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+
+	require.NoError(t, log.Write(1, "key1", []byte("value1")))
+	_, value, ok := log.Get(0)
+	require.True(t, ok)
+	require.Equal(t, "value0", string(value))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRotate(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 1000,
+		MaxSegments:      2,
+		IsInSyncDiskMode: false,
+	})
+	require.NoError(t, err)
+
+	// nothing to seal
+	require.NoError(t, log.Rotate())
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+		require.NoError(t, log.Rotate())
+	}
+
+	// sealed segments over the limit are removed
+	segments, err = log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.Equal(t, []int{2, 3}, []int{segments[0].Number, segments[1].Number})
+	require.True(t, segments[1].Active)
+	require.Zero(t, segments[1].Records)
+	require.Equal(t, 1, log.Len())
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestOnEvict(t *testing.T) {
+	var (
+		evicted []string
+		veto    bool
+	)
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      2,
+		OnEvict: func(segmentPath string) error {
+			if veto {
+				return errors.New("archive is unavailable")
+			}
+
+			data, err := os.ReadFile(segmentPath)
+			if err != nil {
+				return err
+			}
+			evicted = append(evicted, filepath.Base(segmentPath))
+			require.NotEmpty(t, data)
+
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.Equal(t, []string{"log_000000000"}, evicted)
+	_, err = os.Stat("./testlogdata/log_000000000")
+	require.True(t, os.IsNotExist(err))
+
+	// vetoed segments are kept until the next rotation
+	veto = true
+	for i := 5; i < 7; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	require.True(t, log.Exists(2))
+
+	veto = false
+	for i := 7; i < 9; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.Equal(t, []string{"log_000000000", "log_000000001", "log_000000002"}, evicted)
+	segments, err = log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	require.False(t, log.Exists(5))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestRetentionAge(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		RetentionAge:     200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.Equal(t, 3, log.Len())
+
+	// segments are evicted in the background, the active one is sealed first
+	require.Eventually(t, func() bool { return log.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, 2, segments[0].Number)
+	require.True(t, segments[0].Active)
+
+	require.NoError(t, log.Write(3, "key3", []byte("value3")))
+	require.True(t, log.Exists(3))
+	require.NoError(t, log.Close())
+
+	// segments of the previous run are evicted by age of their files
+	require.NoError(t, os.Chtimes("./testlogdata/log_000000002", time.Now(), time.Now().Add(-2*time.Hour)))
+	log, err = NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		OffsetOnlyIndex:  true,
+		RetentionAge:     time.Hour,
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return log.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMaxTotalBytes(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		MaxTotalBytes:    1000,
+	})
+	require.NoError(t, err)
+
+	value := make([]byte, 100)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), value))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.Less(t, len(segments), 10)
+	require.Equal(t, 9, segments[len(segments)-1].Number)
+	require.True(t, log.Exists(19))
+	require.False(t, log.Exists(0))
+
+	// sealed segments fit into the limit, the active one grows until the next rotation
+	var total int64
+	for _, s := range segments[:len(segments)-1] {
+		size, err := log.segmentDiskSize(s.Number)
+		require.NoError(t, err)
+		total += size
+	}
+	require.LessOrEqual(t, total, int64(1000))
+
+	require.NoError(t, log.Close())
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentNames(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		OffsetOnlyIndex:  true,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 25; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	// lexical order matches numeric order
+	entries, err := os.ReadDir("./testlogdata")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		if !strings.Contains(e.Name(), ".") && strings.HasPrefix(e.Name(), "log_0") {
+			names = append(names, e.Name())
+		}
+	}
+	require.Len(t, names, 13)
+	require.Equal(t, "log_000000010", names[10])
+	require.True(t, slices.IsSorted(names))
+
+	// segments written by older versions are renamed on startup
+	for _, postfix := range []string{"", checkSumPostfix, sidecarPostfix} {
+		require.NoError(t, os.Rename("./testlogdata/log_000000011"+postfix, "./testlogdata/log_11"+postfix))
+	}
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	require.Equal(t, 25, log.Len())
+	_, value, ok := log.Get(22)
+	require.True(t, ok)
+	require.Equal(t, "value22", string(value))
+	for _, postfix := range []string{"", checkSumPostfix, sidecarPostfix} {
+		_, err = os.Stat("./testlogdata/log_000000011" + postfix)
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSharedDir(t *testing.T) {
+	prefixes := []string{"log_", "log_x_", "node_1_log_", "node_2_log_"}
+	for n, prefix := range prefixes {
+		log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: prefix, SegmentThreshold: 2, MaxSegments: 3})
+		require.NoError(t, err)
+
+		for i := 0; i < 5+n; i++ {
+			require.NoError(t, log.Write(uint64(i), prefix+strconv.Itoa(i), []byte(prefix+strconv.Itoa(i))))
+		}
+		require.NoError(t, log.Close())
+	}
+
+	for n, prefix := range prefixes {
+		log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: prefix, SegmentThreshold: 2, MaxSegments: 3})
+		require.NoError(t, err)
+
+		last := uint64(5 + n - 1)
+		require.Equal(t, last, log.CurrentIndex())
+		key, value, ok := log.Get(last)
+		require.True(t, ok)
+		require.Equal(t, prefix+strconv.Itoa(int(last)), key)
+		require.Equal(t, prefix+strconv.Itoa(int(last)), string(value))
+
+		segments, err := ListSegments("./testlogdata", prefix)
+		require.NoError(t, err)
+		require.Len(t, segments, 3)
+		require.NoError(t, log.Close())
+	}
+
+	_, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log1", SegmentThreshold: 2, MaxSegments: 3})
+	require.Error(t, err)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestSegmentHeader(t *testing.T) {
+	cfg := Config{
+		Dir:              "./testlogdata",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+	}
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+
+	segments, err := log.Segments()
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), segments[1].CreatedAt, time.Minute)
+
+	header, err := readSegmentHeaderFromFile(log.segmentPath(1))
+	require.NoError(t, err)
+	require.Equal(t, uint16(segmentFormatVersion), header.version)
+	require.Equal(t, 1, header.number)
+	require.Equal(t, "log_", header.prefix)
+	require.NoError(t, log.Close())
+
+	// segment copied under another name is rejected
+	for _, postfix := range []string{"", checkSumPostfix} {
+		data, err := os.ReadFile("./testlogdata/" + segmentName("log_", 0) + postfix)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile("./testlogdata/"+segmentName("log_", 2)+postfix, data, 0755))
+	}
+	_, err = NewWAL(cfg)
+	require.ErrorIs(t, err, ErrBadSegmentHeader)
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+// legacyFixture is the WAL written by the version without segment headers: 10 msgs with indexes 0-9
+// in segments log_0, log_1 and log_2 of 4, 4 and 2 msgs.
+const legacyFixture = "./testdata/legacy"
+
+// copyLegacyFixture copies legacyFixture into dir and returns names of the copied files.
+func copyLegacyFixture(t *testing.T, dir string) []string {
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	entries, err := os.ReadDir(legacyFixture)
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(legacyFixture, e.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, e.Name()), data, 0755))
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+// dirNames returns names of files in dir.
+func dirNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	return names
+}
+
+func TestLegacySegmentHeader(t *testing.T) {
+	names := copyLegacyFixture(t, "./testlogdata")
+
+	header, err := readSegmentHeaderFromFile("./testlogdata/log_0")
+	require.ErrorIs(t, err, ErrLegacySegment)
+	require.Equal(t, uint16(segmentFormatLegacy), header.version)
+
+	// legacy segments are neither renamed nor truncated by functions that can't read them
+	_, err = SafeRecover("./testlogdata", "log_")
+	require.ErrorIs(t, err, ErrLegacySegment)
+	_, err = RebuildIndex("./testlogdata", "log_")
+	require.ErrorIs(t, err, ErrLegacySegment)
+	require.Equal(t, names, dirNames(t, "./testlogdata"))
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestLegacySegments(t *testing.T) {
+	copyLegacyFixture(t, "./testlogdata")
+	cfg := Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 4, MaxSegments: 10}
+
+	log, err := NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		m, err := log.GetMsg(uint64(i))
+		require.NoError(t, err)
+		require.Equal(t, "key"+strconv.Itoa(i), m.Key)
+		require.Equal(t, "value"+strconv.Itoa(i), string(m.Value))
+		require.False(t, m.Timestamp.IsZero())
+	}
+	require.NoError(t, log.Write(10, "key10", []byte("value10")))
+	require.NoError(t, log.Close())
+
+	// segments are migrated under zero-padded names, sealed ones get footers
+	for n := 0; n < 3; n++ {
+		_, err := os.Stat("./testlogdata/log_" + strconv.Itoa(n))
+		require.True(t, os.IsNotExist(err))
+	}
+	footer, err := VerifySegment("./testlogdata/" + segmentName("log_", 1))
+	require.NoError(t, err)
+	require.Equal(t, SegmentFooter{Records: 4, FirstIndex: 4, LastIndex: 7, CRC: footer.CRC}, footer)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i <= 10; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok, i)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+
+	// nothing is migrated if a legacy segment is corrupted
+	names := copyLegacyFixture(t, "./testlogdata")
+	data, err := os.ReadFile("./testlogdata/log_1")
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile("./testlogdata/log_1", data, 0755))
+
+	_, err = NewWAL(cfg)
+	require.ErrorIs(t, err, ErrCorruptedFrame)
+	require.Equal(t, names, dirNames(t, "./testlogdata"))
+
+	// UnsafeRecover removes it and migrates the rest
+	removed, err := UnsafeRecover("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.Equal(t, []string{"testlogdata/log_1", "testlogdata/log_1.checksum"}, removed)
+
+	log, err = NewWAL(cfg)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, _, ok := log.Get(uint64(i))
+		require.Equal(t, i < 4 || i > 7, ok, i)
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestMigrateLegacySegments(t *testing.T) {
+	copyLegacyFixture(t, "./testlogdata")
+
+	migrated, err := MigrateLegacySegments("./testlogdata", "log_", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"testlogdata/" + segmentName("log_", 0), "testlogdata/" + segmentName("log_", 1), "testlogdata/" + segmentName("log_", 2)}, migrated)
+
+	// functions working on the directory without opening the WAL can read it now
+	report, err := Verify("./testlogdata", "log_")
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	migrated, err = MigrateLegacySegments("./testlogdata", "log_", nil)
+	require.NoError(t, err)
+	require.Empty(t, migrated)
+
+	log, err := NewWAL(Config{Dir: "./testlogdata", Prefix: "log_", SegmentThreshold: 4, MaxSegments: 10})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, value, ok := log.Get(uint64(i))
+		require.True(t, ok, i)
+		require.Equal(t, "value"+strconv.Itoa(i), string(value))
+	}
+	require.NoError(t, log.Close())
+
+	require.NoError(t, os.RemoveAll("./testlogdata"))
+}
+
+func TestFileMode(t *testing.T) {
+	log, err := NewWAL(Config{
+		Dir:              "./testlogdata/wal",
+		Prefix:           "log_",
+		SegmentThreshold: 2,
+		MaxSegments:      100,
+		OffsetOnlyIndex:  true,
+		FileMode:         0600,
+		DirMode:          0700,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, log.Write(uint64(i), "key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i))))
+	}
+	require.NoError(t, log.Close())
+
+	stat, err := os.Stat("./testlogdata/wal")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+
+	entries, err := os.ReadDir("./testlogdata/wal")
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	for _, e := range entries {
+		info, err := e.Info()
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm(), e.Name())
+	}
 
 	require.NoError(t, os.RemoveAll("./testlogdata"))
 }